@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"sso/internal/config"
+	"sso/internal/mailer"
+	"sso/internal/services/auth"
+	"sso/internal/storage/postgres"
+	"strings"
+)
+
+// Create a confidential client: go run cmd/clients/main.go --config=./cmd/config/local.yaml --app-id=1 --scopes="orders:read orders:write"
+
+func main() {
+	var appID int
+	var scopes string
+
+	flag.IntVar(&appID, "app-id", 0, "id of the app the client belongs to")
+	flag.StringVar(&scopes, "scopes", "", "space separated scopes the client may request")
+
+	// flags are parsed by config.MustLoad
+	cfg := config.MustLoad()
+
+	if appID == 0 {
+		panic("app-id is required")
+	}
+
+	log := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}))
+
+	storage, err := postgres.New(&cfg.Storage)
+	if err != nil {
+		panic(err)
+	}
+
+	// client registration needs neither the second factor secrets, passkeys, directories nor password hashing
+	authService := auth.New(log, auth.Deps{
+		Storage: storage,
+		Mailer:  mailer.NewMemory(),
+	}, cfg)
+
+	clientID, secret, err := authService.CreateClient(context.Background(), appID, strings.Fields(scopes))
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("client_id: %s\nclient_secret: %s\n", clientID, secret)
+}
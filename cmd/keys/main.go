@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"sso/internal/config"
+	"sso/internal/lib/secretbox"
+	"sso/internal/mailer"
+	"sso/internal/services/auth"
+	"sso/internal/storage/postgres"
+)
+
+// Rotate app signing key: go run cmd/keys/main.go --config=./cmd/config/local.yaml --app-id=1
+
+func main() {
+	var appID int
+	var immediate bool
+
+	flag.IntVar(&appID, "app-id", 0, "id of the app to rotate the signing key for")
+	flag.BoolVar(&immediate, "immediate", false, "sign with the new key right away, e.g. when the current key is compromised")
+
+	// flags are parsed by config.MustLoad
+	cfg := config.MustLoad()
+
+	if appID == 0 {
+		panic("app-id is required")
+	}
+
+	log := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}))
+
+	storage, err := postgres.New(&cfg.Storage)
+	if err != nil {
+		panic(err)
+	}
+
+	// key rotation needs neither passkeys, directories nor password hashing
+	deps := auth.Deps{
+		Storage: storage,
+		Mailer:  mailer.NewMemory(),
+	}
+	// private keys are sealed with the key of the second factor secrets
+	if cfg.MFA.EncryptionKey != "" {
+		deps.Secrets, err = secretbox.New(cfg.MFA.EncryptionKey)
+		if err != nil {
+			panic(err)
+		}
+	}
+	authService := auth.New(log, deps, cfg)
+
+	key, err := authService.RotateSigningKey(context.Background(), appID, immediate)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("new signing key %s (%s) activates at %s\n", key.Kid, key.Algorithm, key.ActivatesAt)
+}
@@ -25,9 +25,10 @@ func main() {
 		slog.String("env", cfg.Env),
 	)
 
-	application := app.New(log, &cfg.Storage, cfg.GRPC.Port, cfg.TokenTTL)
+	application := app.New(log, cfg)
 
 	go application.GRPCSrv.MustRun()
+	go application.HTTPSrv.MustRun()
 
 	stop := make(chan os.Signal, 1)
 
@@ -35,7 +36,7 @@ func main() {
 
 	sign := <-stop
 
-	application.GRPCSrv.Stop()
+	application.Stop()
 	log.Info("application stopped", slog.Any("signal", sign))
 }
 
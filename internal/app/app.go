@@ -1,33 +1,141 @@
 package app
 
 import (
+	"context"
+	"fmt"
+	"github.com/go-webauthn/webauthn/webauthn"
 	"log/slog"
 	grpcapp "sso/internal/app/grpc"
+	httpapp "sso/internal/app/http"
 	"sso/internal/config"
+	"sso/internal/lib/hibp"
+	"sso/internal/lib/ldap"
+	"sso/internal/lib/password"
+	"sso/internal/lib/secretbox"
+	"sso/internal/mailer"
 	auth2 "sso/internal/services/auth"
 	"sso/internal/storage/postgres"
-	"time"
 )
 
 type App struct {
-	GRPCSrv *grpcapp.App
+	GRPCSrv    *grpcapp.App
+	HTTPSrv    *httpapp.App
+	cancelJobs context.CancelFunc
 }
 
 func New(
 	log *slog.Logger,
-	storageCfg *config.StorageConfig,
-	grpcPort int,
-	tokenTTL time.Duration,
+	cfg *config.Config,
 ) *App {
-	storage, err := postgres.New(storageCfg)
+	storage, err := postgres.New(&cfg.Storage)
+	if err != nil {
+		panic(err)
+	}
+	mail, err := newMailer(&cfg.Mailer)
+	if err != nil {
+		panic(err)
+	}
+	passwords, err := newPasswordHasher(&cfg.Password)
+	if err != nil {
+		panic(err)
+	}
+	breaches, err := newBreachChecker(&cfg.Password.Breach)
+	if err != nil {
+		panic(err)
+	}
+	secrets, err := newSecretBox(cfg.MFA.EncryptionKey)
+	if err != nil {
+		panic(err)
+	}
+	if secrets == nil {
+		log.Warn("mfa encryption key is not set, totp enrollment is disabled")
+	}
+	passkeys, err := webauthn.New(&webauthn.Config{
+		RPID:          cfg.WebAuthn.RPID,
+		RPDisplayName: cfg.WebAuthn.RPDisplayName,
+		RPOrigins:     cfg.WebAuthn.RPOrigins,
+	})
 	if err != nil {
 		panic(err)
 	}
-	auth := auth2.New(log, storage, storage, storage, tokenTTL)
 
-	grpcApp := grpcapp.New(log, auth, grpcPort)
+	auth := auth2.New(log, auth2.Deps{
+		Storage:   storage,
+		Secrets:   secrets,
+		Passkeys:  passkeys,
+		Directory: ldap.New(cfg.LDAP.Timeout),
+		Passwords: passwords,
+		Breaches:  breaches,
+		Mailer:    mail,
+	}, cfg)
+
+	grpcApp := grpcapp.New(log, auth, cfg.GRPC.Port, cfg.RateLimit)
+	httpApp := httpapp.New(log, auth, cfg.OAuth.Issuer, cfg.HTTP.Port, cfg.HTTP.Timeout)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go auth.CleanupRevokedTokens(ctx, cfg.RevokedCleanupInterval)
+	go auth.RotateSigningKeys(ctx)
 
 	return &App{
-		GRPCSrv: grpcApp,
+		GRPCSrv:    grpcApp,
+		HTTPSrv:    httpApp,
+		cancelJobs: cancel,
+	}
+}
+
+// Stop stops background jobs and gracefully stops the servers.
+func (a *App) Stop() {
+	a.cancelJobs()
+	a.HTTPSrv.Stop()
+	a.GRPCSrv.Stop()
+}
+
+func newMailer(cfg *config.MailerConfig) (auth2.Mailer, error) {
+	switch cfg.Type {
+	case mailer.TypeFile:
+		return mailer.NewFile(cfg.Dir)
+	case mailer.TypeMemory:
+		return mailer.NewMemory(), nil
+	}
+	return nil, fmt.Errorf("unknown mailer type: %s", cfg.Type)
+}
+
+func newPasswordHasher(cfg *config.PasswordConfig) (auth2.PasswordHasher, error) {
+	switch cfg.Algorithm {
+	case password.AlgorithmArgon2id:
+		alg := password.Argon2id{
+			Memory:      cfg.Argon2Memory,
+			Iterations:  cfg.Argon2Iterations,
+			Parallelism: cfg.Argon2Parallelism,
+		}
+		if err := alg.Validate(); err != nil {
+			return nil, err
+		}
+		return password.New(alg), nil
+	case password.AlgorithmScrypt:
+		alg := password.Scrypt{N: cfg.ScryptN, R: cfg.ScryptR, P: cfg.ScryptP}
+		if err := alg.Validate(); err != nil {
+			return nil, err
+		}
+		return password.New(alg), nil
+	case password.AlgorithmBcrypt:
+		return password.New(password.Bcrypt{Cost: cfg.BcryptCost}), nil
+	}
+	return nil, fmt.Errorf("unknown password algorithm: %s", cfg.Algorithm)
+}
+
+// newBreachChecker opens the breached passwords corpus, the check is disabled without a path.
+func newBreachChecker(cfg *config.BreachConfig) (auth2.BreachChecker, error) {
+	if cfg.Path == "" {
+		return nil, nil
+	}
+	return hibp.Open(cfg.Path)
+}
+
+// newSecretBox creates the box of the secrets stored at rest, there is none without a key.
+func newSecretBox(key string) (auth2.SecretBox, error) {
+	if key == "" {
+		return nil, nil
 	}
+	return secretbox.New(key)
 }
@@ -1,12 +1,16 @@
 package grpcapp
 
 import (
+	"context"
 	"fmt"
 	"github.com/go-playground/validator/v10"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
 	"log/slog"
 	"net"
+	"sso/internal/config"
 	authgrpc "sso/internal/grpc/auth"
+	"sso/internal/lib/clientip"
 	myVal "sso/pkg/validator"
 )
 
@@ -22,8 +26,12 @@ func New(
 	log *slog.Logger,
 	auth authgrpc.Auth,
 	port int,
+	rateLimit config.RateLimitConfig,
 ) *App {
-	gRPCServer := grpc.NewServer()
+	gRPCServer := grpc.NewServer(grpc.ChainUnaryInterceptor(
+		clientIPInterceptor,
+		rateLimitInterceptor(log, rateLimit),
+	))
 
 	v := getValidator()
 
@@ -69,6 +77,19 @@ func (a *App) Stop() {
 	a.gRPCServer.GracefulStop()
 }
 
+// clientIPInterceptor passes the address of the peer to the services.
+func clientIPInterceptor(
+	ctx context.Context,
+	req interface{},
+	_ *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		ctx = clientip.NewContext(ctx, p.Addr.String())
+	}
+	return handler(ctx, req)
+}
+
 func getValidator() *validator.Validate {
 	if val != nil {
 		return val
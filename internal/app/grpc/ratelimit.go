@@ -0,0 +1,82 @@
+package grpcapp
+
+import (
+	"context"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"log/slog"
+	"math"
+	"sso/internal/config"
+	"sso/internal/lib/clientip"
+	"sso/internal/lib/ratelimit"
+	"strconv"
+	"time"
+)
+
+const (
+	errorDomain     = "sso"
+	rateLimitReason = "RATE_LIMIT_EXCEEDED"
+)
+
+// appRequest is a request naming the app it is made for.
+type appRequest interface {
+	GetAppId() int32
+}
+
+// rateLimitInterceptor rejects the requests of a client address to a method for an app
+// beyond the limit. It runs after clientIPInterceptor, which provides the address.
+func rateLimitInterceptor(log *slog.Logger, cfg config.RateLimitConfig) grpc.UnaryServerInterceptor {
+	limiter := ratelimit.New()
+
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		limit := ratelimit.Limit{Rate: cfg.Rate, Burst: cfg.Burst}
+		if m, ok := cfg.Methods[info.FullMethod]; ok {
+			limit = ratelimit.Limit{Rate: m.Rate, Burst: m.Burst}
+		}
+
+		var appID int32
+		if r, ok := req.(appRequest); ok {
+			appID = r.GetAppId()
+		}
+		ip := clientip.FromContext(ctx)
+
+		key := info.FullMethod + " " + ip + " " + strconv.Itoa(int(appID))
+		if ok, retryAfter := limiter.Allow(key, limit); !ok {
+			log.Warn("rate limit exceeded",
+				slog.String("method", info.FullMethod),
+				slog.String("ip", ip),
+				slog.Int("app_id", int(appID)),
+			)
+			return nil, rateLimitedStatus(retryAfter)
+		}
+		return handler(ctx, req)
+	}
+}
+
+// rateLimitedStatus tells the client when to retry, in a RetryInfo and in the metadata
+// of an ErrorInfo in whole seconds.
+func rateLimitedStatus(retryAfter time.Duration) error {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+
+	st := status.New(codes.ResourceExhausted, "rate limit exceeded")
+	detailed, err := st.WithDetails(
+		&errdetails.RetryInfo{RetryDelay: durationpb.New(retryAfter)},
+		&errdetails.ErrorInfo{
+			Reason:   rateLimitReason,
+			Domain:   errorDomain,
+			Metadata: map[string]string{"retry_after": strconv.Itoa(seconds)},
+		},
+	)
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
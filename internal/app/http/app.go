@@ -0,0 +1,83 @@
+package httpapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	authhttp "sso/internal/http/auth"
+	"sso/internal/lib/clientip"
+	"time"
+)
+
+const (
+	shutdownTimeout = 10 * time.Second
+)
+
+type App struct {
+	log        *slog.Logger
+	httpServer *http.Server
+	port       int
+}
+
+func New(
+	log *slog.Logger,
+	auth authhttp.Auth,
+	issuer string,
+	port int,
+	timeout time.Duration,
+) *App {
+	mux := http.NewServeMux()
+
+	authhttp.Register(mux, log, auth, issuer)
+
+	return &App{
+		log: log,
+		httpServer: &http.Server{
+			Addr:         fmt.Sprintf(":%d", port),
+			Handler:      withClientIP(mux),
+			ReadTimeout:  timeout,
+			WriteTimeout: timeout,
+		},
+		port: port,
+	}
+}
+
+func (a *App) MustRun() {
+	if err := a.Run(); err != nil {
+		panic(err)
+	}
+}
+
+func (a *App) Run() error {
+	const op = "httpapp.Run"
+
+	a.log.Info("HTTP server running...", slog.Int("port", a.port))
+
+	if err := a.httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+func (a *App) Stop() {
+	const op = "httpapp.Stop"
+
+	log := a.log.With(slog.String("op", op))
+	log.Info("stopping HTTP server", slog.Int("port", a.port))
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := a.httpServer.Shutdown(ctx); err != nil {
+		log.Error("failed to stop HTTP server", slog.String("error", err.Error()))
+	}
+}
+
+// withClientIP passes the address of the client to the services.
+func withClientIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(clientip.NewContext(r.Context(), r.RemoteAddr)))
+	})
+}
@@ -13,10 +13,26 @@ const (
 )
 
 type Config struct {
-	Env      string        `yaml:"env" env-default:"local"`
-	GRPC     GRPCConfig    `yaml:"grpc"`
-	Storage  StorageConfig `yaml:"storage"`
-	TokenTTL time.Duration `yaml:"token_ttl" env-required:"true"`
+	Env             string        `yaml:"env" env-default:"local"`
+	GRPC            GRPCConfig    `yaml:"grpc"`
+	HTTP            HTTPConfig    `yaml:"http"`
+	Storage         StorageConfig `yaml:"storage"`
+	TokenTTL        time.Duration `yaml:"token_ttl" env-required:"true"`
+	RefreshTokenTTL time.Duration `yaml:"refresh_token_ttl" env-default:"720h"`
+	// RevokedCleanupInterval is how often expired entries are removed from the revocation list.
+	RevokedCleanupInterval time.Duration           `yaml:"revoked_cleanup_interval" env-default:"1h"`
+	KeyRotation            KeyRotationConfig       `yaml:"key_rotation"`
+	PasswordResetTTL       time.Duration           `yaml:"password_reset_ttl" env-default:"1h"`
+	Mailer                 MailerConfig            `yaml:"mailer"`
+	EmailVerification      EmailVerificationConfig `yaml:"email_verification"`
+	MFA                    MFAConfig               `yaml:"mfa"`
+	WebAuthn               WebAuthnConfig          `yaml:"webauthn"`
+	OAuth                  OAuthConfig             `yaml:"oauth"`
+	Federation             FederationConfig        `yaml:"federation"`
+	LDAP                   LDAPConfig              `yaml:"ldap"`
+	Password               PasswordConfig          `yaml:"password"`
+	BruteForce             BruteForceConfig        `yaml:"brute_force"`
+	RateLimit              RateLimitConfig         `yaml:"rate_limit"`
 }
 
 type GRPCConfig struct {
@@ -24,6 +40,153 @@ type GRPCConfig struct {
 	Timeout time.Duration `yaml:"timeout"`
 }
 
+type HTTPConfig struct {
+	Port    int           `yaml:"port" env-default:"8080"`
+	Timeout time.Duration `yaml:"timeout" env-default:"10s"`
+}
+
+type KeyRotationConfig struct {
+	// Interval is how often signing keys are checked for rotation.
+	Interval time.Duration `yaml:"interval" env-default:"1h"`
+	// MaxAge is how long a key is used for signing before it is rotated.
+	MaxAge time.Duration `yaml:"max_age" env-default:"720h"`
+	// PublishDelay is how long a new key is published in the JWKS before it is used
+	// for signing, so verifiers caching the key set pick it up in time.
+	PublishDelay time.Duration `yaml:"publish_delay" env-default:"1h"`
+}
+
+type EmailVerificationConfig struct {
+	TTL time.Duration `yaml:"ttl" env-default:"24h"`
+	// ResendInterval is the minimal interval between two verification emails to a user.
+	ResendInterval time.Duration `yaml:"resend_interval" env-default:"1m"`
+}
+
+type MFAConfig struct {
+	// Issuer is shown in authenticator apps.
+	Issuer string `yaml:"issuer" env-default:"sso"`
+	// EncryptionKey is a base64 encoded 32 byte key second factor secrets and
+	// private signing keys are encrypted with. Without it TOTP enrollment is disabled
+	// and private signing keys are stored unencrypted.
+	EncryptionKey string        `yaml:"encryption_key" env:"MFA_ENCRYPTION_KEY"`
+	ChallengeTTL  time.Duration `yaml:"challenge_ttl" env-default:"5m"`
+	MaxAttempts   int           `yaml:"max_attempts" env-default:"5"`
+}
+
+type WebAuthnConfig struct {
+	// RPID is the relying party id, the domain passkeys are bound to.
+	RPID          string        `yaml:"rp_id" env-default:"localhost"`
+	RPDisplayName string        `yaml:"rp_display_name" env-default:"SSO"`
+	RPOrigins     []string      `yaml:"rp_origins" env-default:"http://localhost:8080"`
+	SessionTTL    time.Duration `yaml:"session_ttl" env-default:"5m"`
+}
+
+type OAuthConfig struct {
+	// Issuer is the OpenID Connect issuer identifier, the public base url of the HTTP server.
+	Issuer string `yaml:"issuer" env-default:"http://localhost:8080"`
+	// AuthorizationCodeTTL is how long an authorization code can be exchanged for tokens.
+	AuthorizationCodeTTL time.Duration `yaml:"authorization_code_ttl" env-default:"1m"`
+	// DeviceCodeTTL is how long the user has to approve a device authorization.
+	DeviceCodeTTL time.Duration `yaml:"device_code_ttl" env-default:"10m"`
+	// DevicePollInterval is the minimal interval between two token requests of a device.
+	DevicePollInterval time.Duration `yaml:"device_poll_interval" env-default:"5s"`
+}
+
+type FederationConfig struct {
+	// LoginTTL is how long the user has to sign in at the upstream provider.
+	LoginTTL time.Duration `yaml:"login_ttl" env-default:"10m"`
+	// Timeout limits each request to an upstream provider.
+	Timeout time.Duration `yaml:"timeout" env-default:"10s"`
+}
+
+type LDAPConfig struct {
+	// Timeout limits the authentication of a user against a directory.
+	Timeout time.Duration `yaml:"timeout" env-default:"10s"`
+}
+
+type PasswordConfig struct {
+	// Algorithm new passwords are hashed with, either "argon2id", "scrypt" or "bcrypt".
+	// Hashes made with another algorithm or other parameters are replaced on the next login.
+	Algorithm  string `yaml:"algorithm" env-default:"argon2id"`
+	BcryptCost int    `yaml:"bcrypt_cost" env-default:"10"`
+	// Argon2Memory is in KiB.
+	Argon2Memory      uint32 `yaml:"argon2_memory" env-default:"19456"`
+	Argon2Iterations  uint32 `yaml:"argon2_iterations" env-default:"2"`
+	Argon2Parallelism uint8  `yaml:"argon2_parallelism" env-default:"1"`
+	// ScryptN is the CPU/memory cost, a power of two.
+	ScryptN int `yaml:"scrypt_n" env-default:"32768"`
+	ScryptR int `yaml:"scrypt_r" env-default:"8"`
+	ScryptP int `yaml:"scrypt_p" env-default:"1"`
+	// Policy applies to apps without a password policy of their own.
+	Policy PasswordPolicyConfig `yaml:"policy"`
+	Breach BreachConfig         `yaml:"breach"`
+	// ChangeTicketTTL is how long the user has to change an expired password after Login.
+	ChangeTicketTTL time.Duration `yaml:"change_ticket_ttl" env-default:"10m"`
+}
+
+type BreachConfig struct {
+	// Path is the Pwned Passwords corpus ordered by hash, the check is disabled without it.
+	Path string `yaml:"path" env:"BREACHED_PASSWORDS_PATH"`
+	// MinCount is how often a password has to appear in the corpus to be rejected.
+	MinCount int `yaml:"min_count" env-default:"1"`
+}
+
+type PasswordPolicyConfig struct {
+	MinLength      int  `yaml:"min_length" env-default:"8"`
+	MaxLength      int  `yaml:"max_length" env-default:"128"`
+	RequireUpper   bool `yaml:"require_upper" env-default:"true"`
+	RequireLower   bool `yaml:"require_lower" env-default:"false"`
+	RequireDigit   bool `yaml:"require_digit" env-default:"true"`
+	RequireSpecial bool `yaml:"require_special" env-default:"true"`
+	// BannedWords is the space separated list of words passwords must not contain.
+	BannedWords string `yaml:"banned_words" env-default:"password qwerty letmein"`
+	// EmailSimilarity is the similarity from 0 to 1 to the local part of the email
+	// from which passwords are rejected, 0 disables the rule.
+	EmailSimilarity float64 `yaml:"email_similarity" env-default:"0.7"`
+	// HistorySize is how many of the latest passwords, the current one included,
+	// a new password must differ from, 0 disables the rule.
+	HistorySize int `yaml:"history_size" env-default:"0"`
+	// MaxAgeDays is how many days a password is valid, 0 disables the expiry.
+	MaxAgeDays int `yaml:"max_age_days" env-default:"0"`
+}
+
+// BruteForceConfig throttles failed logins per login and per client address.
+// After the free attempts every failure delays the next attempt, starting with Delay
+// and doubling up to MaxDelay. Reaching the lockout threshold locks for LockoutDuration.
+type BruteForceConfig struct {
+	// Window is how long failures are counted from the first one.
+	Window               time.Duration `yaml:"window" env-default:"15m"`
+	Delay                time.Duration `yaml:"delay" env-default:"1s"`
+	MaxDelay             time.Duration `yaml:"max_delay" env-default:"1m"`
+	LockoutDuration      time.Duration `yaml:"lockout_duration" env-default:"15m"`
+	UserFreeAttempts     int           `yaml:"user_free_attempts" env-default:"3"`
+	UserLockoutThreshold int           `yaml:"user_lockout_threshold" env-default:"10"`
+	// The client address limits are higher, many users may share an address.
+	IPFreeAttempts     int `yaml:"ip_free_attempts" env-default:"100"`
+	IPLockoutThreshold int `yaml:"ip_lockout_threshold" env-default:"1000"`
+}
+
+// RateLimitConfig limits the gRPC requests of a client address to a method per app
+// with a token bucket. Rate is the number of requests per second, 0 disables the limit,
+// Burst is the number of requests a client may send at once.
+type RateLimitConfig struct {
+	Rate  float64 `yaml:"rate" env-default:"10"`
+	Burst int     `yaml:"burst" env-default:"100"`
+	// Methods overrides the limit of the methods by their full name, such as /auth.Auth/Login.
+	Methods map[string]RateLimit `yaml:"methods"`
+}
+
+type RateLimit struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+type MailerConfig struct {
+	// Type is either "file" or "memory".
+	Type string `yaml:"type" env-default:"file"`
+	// Dir is where the file mailer writes messages.
+	Dir string `yaml:"dir" env-default:"./mail"`
+}
+
 type StorageConfig struct {
 	Host     string `yaml:"host"`
 	Port     string `yaml:"port"`
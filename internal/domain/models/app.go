@@ -1,7 +1,35 @@
 package models
 
+import "time"
+
 type App struct {
-	ID     int64  `db:"id"`
-	Name   string `db:"name"`
-	Secret string `db:"secret"`
+	ID                   int64  `db:"id"`
+	Name                 string `db:"name"`
+	Secret               string `db:"secret"`
+	SigningAlg           string `db:"signing_alg"`
+	RequireVerifiedEmail bool   `db:"require_verified_email"`
+}
+
+// PasswordPolicy are the rules new passwords have to meet. Apps without a policy of their own
+// use the policy of the config. A MaxLength, EmailSimilarity, HistorySize or MaxAgeDays
+// of zero disables the rule.
+type PasswordPolicy struct {
+	AppID          int64 `db:"app_id"`
+	MinLength      int   `db:"min_length"`
+	MaxLength      int   `db:"max_length"`
+	RequireUpper   bool  `db:"require_upper"`
+	RequireLower   bool  `db:"require_lower"`
+	RequireDigit   bool  `db:"require_digit"`
+	RequireSpecial bool  `db:"require_special"`
+	// BannedWords is the space separated list of words passwords must not contain.
+	BannedWords string `db:"banned_words"`
+	// EmailSimilarity is the similarity from 0 to 1 to the local part of the email
+	// from which passwords are rejected.
+	EmailSimilarity float64 `db:"email_similarity"`
+	// HistorySize is how many of the latest passwords of the user, the current one included,
+	// a new password must differ from.
+	HistorySize int `db:"history_size"`
+	// MaxAgeDays is how many days a password is valid before it has to be changed.
+	MaxAgeDays int       `db:"max_age_days"`
+	CreatedAt  time.Time `db:"created_at"`
 }
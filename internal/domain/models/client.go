@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// Client is a confidential OAuth client of an app, authenticated with a secret.
+// Scopes is the space separated list of scopes the client may be granted.
+type Client struct {
+	ID         int64     `db:"id"`
+	ClientID   string    `db:"client_id"`
+	SecretHash string    `db:"secret_hash"`
+	AppID      int64     `db:"app_id"`
+	Scopes     string    `db:"scopes"`
+	CreatedAt  time.Time `db:"created_at"`
+}
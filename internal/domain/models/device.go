@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// Statuses of a device code.
+const (
+	DeviceCodePending  = "pending"
+	DeviceCodeApproved = "approved"
+	DeviceCodeDenied   = "denied"
+)
+
+// DeviceCode is a pending device authorization (RFC 8628). The device polls
+// with the device code while the user approves the user code on another device.
+type DeviceCode struct {
+	ID             int64  `db:"id"`
+	DeviceCodeHash string `db:"device_code_hash"`
+	UserCode       string `db:"user_code"`
+	AppID          int64  `db:"app_id"`
+	Scope          string `db:"scope"`
+	// UserID is set once the user approved or denied the request.
+	UserID *int64 `db:"user_id"`
+	Status string `db:"status"`
+	// PollInterval is the minimal number of seconds between two polls.
+	PollInterval int        `db:"poll_interval"`
+	LastPolledAt *time.Time `db:"last_polled_at"`
+	ExpiresAt    time.Time  `db:"expires_at"`
+	UsedAt       *time.Time `db:"used_at"`
+	CreatedAt    time.Time  `db:"created_at"`
+}
+
+// DeviceAuthorization is returned to the device which started the flow.
+type DeviceAuthorization struct {
+	DeviceCode string
+	UserCode   string
+	ExpiresIn  time.Duration
+	Interval   time.Duration
+}
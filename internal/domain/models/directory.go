@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+// LDAPDirectory is the LDAP or Active Directory users of the app sign in with.
+// The service account of BindDN searches BaseDN for the entry matching UserFilter,
+// in which %s is replaced with the escaped login, then the user binds as that entry.
+type LDAPDirectory struct {
+	ID       int64  `db:"id"`
+	AppID    int64  `db:"app_id"`
+	URL      string `db:"url"`
+	StartTLS bool   `db:"start_tls"`
+	BindDN   string `db:"bind_dn"`
+	// BindPassword is the password of the service account.
+	BindPassword   string    `db:"bind_password"`
+	BaseDN         string    `db:"base_dn"`
+	UserFilter     string    `db:"user_filter"`
+	EmailAttribute string    `db:"email_attribute"`
+	GroupAttribute string    `db:"group_attribute"`
+	CreatedAt      time.Time `db:"created_at"`
+}
+
+// LDAPGroupRole grants the role in the app to the members of the directory group.
+type LDAPGroupRole struct {
+	ID          int64  `db:"id"`
+	DirectoryID int64  `db:"directory_id"`
+	GroupDN     string `db:"group_dn"`
+	Role        string `db:"role"`
+}
+
+// DirectoryEntry is the entry of a user who authenticated against a directory.
+type DirectoryEntry struct {
+	DN     string
+	Email  string
+	Groups []string
+}
@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+// TokenExchangePolicy allows a client to exchange user tokens for tokens of the audience app.
+// Scopes is the space separated list of scopes the exchanged tokens may be granted.
+type TokenExchangePolicy struct {
+	ID            int64  `db:"id"`
+	ClientID      string `db:"client_id"`
+	AudienceAppID int64  `db:"audience_app_id"`
+	Scopes        string `db:"scopes"`
+	// AllowImpersonation allows admins to obtain tokens of other users through the client.
+	AllowImpersonation bool      `db:"allow_impersonation"`
+	CreatedAt          time.Time `db:"created_at"`
+}
+
+// TokenExchangeAudit records a token issued by a token exchange.
+// ActorUserID is the admin who impersonated the subject, nil for delegation by the client.
+type TokenExchangeAudit struct {
+	ID            int64     `db:"id"`
+	TokenID       string    `db:"token_id"`
+	ClientID      string    `db:"client_id"`
+	SubjectUserID int64     `db:"subject_user_id"`
+	ActorUserID   *int64    `db:"actor_user_id"`
+	AudienceAppID int64     `db:"audience_app_id"`
+	Scope         string    `db:"scope"`
+	CreatedAt     time.Time `db:"created_at"`
+}
+
+// TokenExchangeRequest is a token exchange request (RFC 8693) of an authenticated client.
+type TokenExchangeRequest struct {
+	SubjectToken     string
+	SubjectTokenType string
+	ActorToken       string
+	ActorTokenType   string
+	Audience         string
+	Scope            string
+}
@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+// IdentityProvider is an upstream OpenID provider users of the app can sign in with.
+// Name identifies the provider within the app, e.g. google or keycloak.
+type IdentityProvider struct {
+	ID           int64  `db:"id"`
+	AppID        int64  `db:"app_id"`
+	Name         string `db:"name"`
+	Issuer       string `db:"issuer"`
+	ClientID     string `db:"client_id"`
+	ClientSecret string `db:"client_secret"`
+	// Scopes is the space separated scope requested from the provider.
+	Scopes    string    `db:"scopes"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// FederatedLogin holds the state of a login at an upstream provider between its begin and finish calls.
+type FederatedLogin struct {
+	ID           int64     `db:"id"`
+	StateHash    string    `db:"state_hash"`
+	ProviderID   int64     `db:"provider_id"`
+	RedirectURI  string    `db:"redirect_uri"`
+	Nonce        string    `db:"nonce"`
+	CodeVerifier string    `db:"code_verifier"`
+	ExpiresAt    time.Time `db:"expires_at"`
+	CreatedAt    time.Time `db:"created_at"`
+}
+
+// UserIdentity links an account at an upstream provider, identified by its subject, to a user.
+type UserIdentity struct {
+	ID         int64     `db:"id"`
+	UserID     int64     `db:"user_id"`
+	ProviderID int64     `db:"provider_id"`
+	Subject    string    `db:"subject"`
+	CreatedAt  time.Time `db:"created_at"`
+}
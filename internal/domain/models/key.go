@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// SigningKey is published in the JWKS from creation until RetiresAt,
+// and is used for signing from ActivatesAt until a newer key activates.
+type SigningKey struct {
+	ID          int64      `db:"id"`
+	Kid         string     `db:"kid"`
+	AppID       int64      `db:"app_id"`
+	Algorithm   string     `db:"algorithm"`
+	PrivateKey  string     `db:"private_key"`
+	PublicKey   string     `db:"public_key"`
+	CreatedAt   time.Time  `db:"created_at"`
+	ActivatesAt time.Time  `db:"activates_at"`
+	RetiresAt   *time.Time `db:"retires_at"`
+}
@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+// MFAChallenge is issued by Login when the user has a second factor
+// and is completed by VerifyMFA. PasswordExpired makes VerifyMFA return
+// a password change id instead of tokens.
+type MFAChallenge struct {
+	ID              int64      `db:"id"`
+	ChallengeHash   string     `db:"challenge_hash"`
+	UserID          int64      `db:"user_id"`
+	AppID           int64      `db:"app_id"`
+	Attempts        int        `db:"attempts"`
+	PasswordExpired bool       `db:"password_expired"`
+	ExpiresAt       time.Time  `db:"expires_at"`
+	UsedAt          *time.Time `db:"used_at"`
+	CreatedAt       time.Time  `db:"created_at"`
+}
+
+type LoginResult struct {
+	Tokens *TokenPair
+	// MFAChallengeID is set instead of Tokens when a second factor is required.
+	MFAChallengeID string
+	// PasswordChangeID is set instead of Tokens when the password expired.
+	PasswordChangeID string
+}
+
+type RecoveryCode struct {
+	ID        int64      `db:"id"`
+	UserID    int64      `db:"user_id"`
+	CodeHash  string     `db:"code_hash"`
+	UsedAt    *time.Time `db:"used_at"`
+	CreatedAt time.Time  `db:"created_at"`
+}
@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+// AuthorizationRequest holds the parameters of an OAuth 2.0 authorization request.
+type AuthorizationRequest struct {
+	ResponseType        string
+	ClientID            int
+	RedirectURI         string
+	Scope               string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+// AuthorizationCode is issued by the authorize endpoint and exchanged
+// for tokens at the token endpoint. FamilyID is the refresh token family
+// the exchange starts, so it can be revoked if the code is replayed.
+type AuthorizationCode struct {
+	ID                  int64      `db:"id"`
+	CodeHash            string     `db:"code_hash"`
+	FamilyID            string     `db:"family_id"`
+	UserID              int64      `db:"user_id"`
+	AppID               int64      `db:"app_id"`
+	RedirectURI         string     `db:"redirect_uri"`
+	Scope               string     `db:"scope"`
+	CodeChallenge       string     `db:"code_challenge"`
+	CodeChallengeMethod string     `db:"code_challenge_method"`
+	ExpiresAt           time.Time  `db:"expires_at"`
+	UsedAt              *time.Time `db:"used_at"`
+	CreatedAt           time.Time  `db:"created_at"`
+	Nonce               string     `db:"nonce"`
+	// AuthTime is when the user authenticated, reported in the ID token.
+	AuthTime time.Time `db:"auth_time"`
+}
+
+// UserInfo holds the OpenID Connect claims about the user released for the granted scopes.
+type UserInfo struct {
+	Subject           string
+	Email             string
+	EmailVerified     *bool
+	PreferredUsername string
+}
@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+type PasskeyCredential struct {
+	ID              int64      `db:"id"`
+	UserID          int64      `db:"user_id"`
+	CredentialID    []byte     `db:"credential_id"`
+	PublicKey       []byte     `db:"public_key"`
+	AttestationType string     `db:"attestation_type"`
+	AAGUID          []byte     `db:"aaguid"`
+	SignCount       int64      `db:"sign_count"`
+	Transports      string     `db:"transports"`
+	BackupEligible  bool       `db:"backup_eligible"`
+	BackupState     bool       `db:"backup_state"`
+	CreatedAt       time.Time  `db:"created_at"`
+	LastUsedAt      *time.Time `db:"last_used_at"`
+}
+
+// WebAuthnSession holds the state of a registration or login ceremony between its begin and finish calls.
+// UserID is set for registration, AppID for login.
+type WebAuthnSession struct {
+	ID          int64     `db:"id"`
+	SessionHash string    `db:"session_hash"`
+	UserID      *int64    `db:"user_id"`
+	AppID       *int64    `db:"app_id"`
+	Data        string    `db:"data"`
+	ExpiresAt   time.Time `db:"expires_at"`
+	CreatedAt   time.Time `db:"created_at"`
+}
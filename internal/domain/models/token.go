@@ -0,0 +1,76 @@
+package models
+
+import "time"
+
+type RefreshToken struct {
+	ID        int64      `db:"id"`
+	TokenHash string     `db:"token_hash"`
+	FamilyID  string     `db:"family_id"`
+	UserID    int64      `db:"user_id"`
+	AppID     int64      `db:"app_id"`
+	ExpiresAt time.Time  `db:"expires_at"`
+	UsedAt    *time.Time `db:"used_at"`
+	RevokedAt *time.Time `db:"revoked_at"`
+	CreatedAt time.Time  `db:"created_at"`
+	Scope     string     `db:"scope"`
+}
+
+type TokenPair struct {
+	AccessToken  string
+	RefreshToken string
+	// IDToken is set when the openid scope was granted.
+	IDToken string
+	// ExpiresIn is the lifetime of the access token.
+	ExpiresIn time.Duration
+	// Scope is the OAuth scope granted to the access token.
+	Scope string
+}
+
+// TokenIntrospection is the state of an access token (RFC 7662),
+// the other fields are set only when the token is active.
+type TokenIntrospection struct {
+	Active bool
+	ID     string
+	// UserID is zero for tokens issued to a client on its own behalf.
+	UserID   int64
+	Email    string
+	ClientID string
+	AppID    int64
+	Scope    string
+	// Actor is set for tokens issued by a token exchange.
+	Actor     string
+	ExpiresAt time.Time
+}
+
+type PasswordResetToken struct {
+	ID        int64      `db:"id"`
+	TokenHash string     `db:"token_hash"`
+	UserID    int64      `db:"user_id"`
+	ExpiresAt time.Time  `db:"expires_at"`
+	UsedAt    *time.Time `db:"used_at"`
+	CreatedAt time.Time  `db:"created_at"`
+}
+
+// EmailVerificationToken confirms that the user owns Email.
+type EmailVerificationToken struct {
+	ID        int64      `db:"id"`
+	TokenHash string     `db:"token_hash"`
+	UserID    int64      `db:"user_id"`
+	Email     string     `db:"email"`
+	ExpiresAt time.Time  `db:"expires_at"`
+	UsedAt    *time.Time `db:"used_at"`
+	CreatedAt time.Time  `db:"created_at"`
+}
+
+// EmailChangeToken confirms that the user owns NewEmail, it is valid only while OldEmail
+// is the email of the user.
+type EmailChangeToken struct {
+	ID        int64      `db:"id"`
+	TokenHash string     `db:"token_hash"`
+	UserID    int64      `db:"user_id"`
+	OldEmail  string     `db:"old_email"`
+	NewEmail  string     `db:"new_email"`
+	ExpiresAt time.Time  `db:"expires_at"`
+	UsedAt    *time.Time `db:"used_at"`
+	CreatedAt time.Time  `db:"created_at"`
+}
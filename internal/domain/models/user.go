@@ -1,8 +1,47 @@
 package models
 
+import "time"
+
 type User struct {
-	ID       int64  `db:"id"`
-	Email    string `db:"email"`
-	PassHash []byte `db:"pass_hash"`
-	IsAdmin  bool   `db:"is_admin"`
+	ID            int64  `db:"id"`
+	Email         string `db:"email"`
+	PassHash      []byte `db:"pass_hash"`
+	IsAdmin       bool   `db:"is_admin"`
+	EmailVerified bool   `db:"email_verified"`
+	// TOTPSecret is encrypted at rest.
+	TOTPSecret        *string   `db:"totp_secret"`
+	TOTPEnabled       bool      `db:"totp_enabled"`
+	TOTPLastStep      int64     `db:"totp_last_step"`
+	PasswordChangedAt time.Time `db:"password_changed_at"`
+	// TokensValidAfter rejects the access tokens of the user issued before it, in whole seconds.
+	TokensValidAfter *time.Time `db:"tokens_valid_after"`
+}
+
+// PasswordChangeTicket is issued by Login when the password of the user expired
+// and permits ChangePassword only.
+type PasswordChangeTicket struct {
+	ID         int64      `db:"id"`
+	TicketHash string     `db:"ticket_hash"`
+	UserID     int64      `db:"user_id"`
+	AppID      int64      `db:"app_id"`
+	ExpiresAt  time.Time  `db:"expires_at"`
+	UsedAt     *time.Time `db:"used_at"`
+	CreatedAt  time.Time  `db:"created_at"`
+}
+
+const (
+	// LoginScopeUser counts the failed logins of a login, whether or not it names a user.
+	LoginScopeUser = "user"
+	// LoginScopeIP counts the failed logins from a client address.
+	LoginScopeIP = "ip"
+)
+
+// LoginAttempts counts the failed logins of the subject in the current window.
+type LoginAttempts struct {
+	Scope           string     `db:"scope"`
+	Subject         string     `db:"subject"`
+	Failures        int        `db:"failures"`
+	WindowStartedAt time.Time  `db:"window_started_at"`
+	LastFailureAt   time.Time  `db:"last_failure_at"`
+	LockedUntil     *time.Time `db:"locked_until"`
 }
@@ -5,21 +5,72 @@ import (
 	"errors"
 	sso "github.com/Rasikrr/protobuff/protos/gen/go/sso"
 	"github.com/go-playground/validator/v10"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwk"
 	"sso/internal/services/auth"
 	"sso/internal/storage"
+	"strconv"
+	"time"
 )
 
 const (
 	emptyValue = 0
+
+	// errorDomain and the reasons are the ErrorInfo details of errors.
+	errorDomain           = "sso"
+	passwordPolicyReason  = "PASSWORD_POLICY_VIOLATION"
+	tooManyAttemptsReason = "TOO_MANY_LOGIN_ATTEMPTS"
+	accountLockedReason   = "ACCOUNT_LOCKED"
 )
 
 type Auth interface {
-	Login(ctx context.Context, email, password string, appID int) (token string, err error)
-	RegisterNewUser(ctx context.Context, email string, password []byte) (userID int64, err error)
+	Login(ctx context.Context, email, password string, appID int) (result *models.LoginResult, err error)
+	Refresh(ctx context.Context, refreshToken string) (tokens *models.TokenPair, err error)
+	Logout(ctx context.Context, accessToken, refreshToken string) error
+	IsTokenRevoked(ctx context.Context, accessToken string) (bool, error)
+	RegisterNewUser(ctx context.Context, email string, password []byte, appID int64) (userID int64, err error)
 	IsAdmin(ctx context.Context, userID int64) (bool, error)
+	JWKS(ctx context.Context) (*jwk.Set, error)
+	RequestPasswordReset(ctx context.Context, email string) error
+	ConfirmPasswordReset(ctx context.Context, token string, newPassword []byte) error
+	VerifyEmail(ctx context.Context, token string) error
+	ResendVerificationEmail(ctx context.Context, email string) error
+	EnrollTOTP(ctx context.Context, accessToken, password string) (secret string, uri string, err error)
+	ConfirmTOTP(ctx context.Context, accessToken, password, code string) (recoveryCodes []string, err error)
+	RegenerateRecoveryCodes(ctx context.Context, accessToken, code string) (recoveryCodes []string, err error)
+	VerifyMFA(ctx context.Context, challengeID, code string) (result *models.LoginResult, err error)
+	BeginPasskeyRegistration(
+		ctx context.Context,
+		accessToken string,
+		password string,
+		code string,
+	) (sessionID string, options []byte, err error)
+	FinishPasskeyRegistration(ctx context.Context, accessToken, sessionID string, credential []byte) error
+	BeginPasskeyLogin(ctx context.Context, appID int) (sessionID string, options []byte, err error)
+	FinishPasskeyLogin(ctx context.Context, sessionID string, credential []byte) (tokens *models.TokenPair, err error)
+	ClientCredentials(ctx context.Context, clientID, clientSecret, scope string) (tokens *models.TokenPair, err error)
+	ApproveDevice(ctx context.Context, accessToken, userCode string, approve bool) error
+	ValidateToken(ctx context.Context, accessToken string, appID int) (info *models.TokenIntrospection, err error)
+	ExchangeToken(
+		ctx context.Context,
+		clientID string,
+		clientSecret string,
+		req *models.TokenExchangeRequest,
+	) (tokens *models.TokenPair, err error)
+	BeginFederatedLogin(ctx context.Context, appID int, provider, redirectURI string) (authURL string, state string, err error)
+	FinishFederatedLogin(ctx context.Context, state, code string) (tokens *models.TokenPair, err error)
+	LinkIdentity(ctx context.Context, accessToken, password, state, code string) error
+	UnlinkIdentity(ctx context.Context, accessToken, password, provider string) error
+	LinkDirectory(ctx context.Context, accessToken, password string, appID int, directoryPassword string) error
+	ChangePassword(ctx context.Context, accessToken, changeID, currentPassword, newPassword string) (tokens *models.TokenPair, err error)
+	ChangeEmail(ctx context.Context, accessToken, password, newEmail string) error
+	ConfirmEmailChange(ctx context.Context, token string) error
+	UnlockUser(ctx context.Context, accessToken string, userID int64) error
 }
 
 type serverAPI struct {
@@ -45,16 +96,200 @@ func (s *serverAPI) Login(
 		return nil, err
 	}
 
-	token, err := s.auth.Login(ctx, req.GetEmail(), req.GetPassword(), int(req.GetAppId()))
+	result, err := s.auth.Login(ctx, req.GetEmail(), req.GetPassword(), int(req.GetAppId()))
 	if err != nil {
 		if errors.Is(err, auth.ErrInvalidCredentials) || errors.Is(err, auth.ErrInvalidAppId) {
 			return nil, status.Error(codes.InvalidArgument, "invalid credentials")
 		}
+		if errors.Is(err, auth.ErrEmailNotVerified) {
+			return nil, status.Error(codes.FailedPrecondition, "email not verified")
+		}
+		if errors.Is(err, auth.ErrDirectoryUnavailable) {
+			return nil, status.Error(codes.Unavailable, "directory unavailable")
+		}
+		if errors.Is(err, auth.ErrIdentityConflict) {
+			return nil, status.Error(codes.FailedPrecondition, "account already exists, link it to the directory")
+		}
+		var throttledErr *auth.LoginThrottledError
+		if errors.As(err, &throttledErr) {
+			return nil, loginThrottledStatus(throttledErr)
+		}
 		return nil, status.Error(codes.Internal, "internal error")
 	}
 
+	if result.PasswordChangeID != "" {
+		return &sso.LoginResponse{
+			PasswordExpired:  true,
+			PasswordChangeId: result.PasswordChangeID,
+		}, nil
+	}
+	if result.MFAChallengeID != "" {
+		return &sso.LoginResponse{
+			MfaRequired:    true,
+			MfaChallengeId: result.MFAChallengeID,
+		}, nil
+	}
+
 	return &sso.LoginResponse{
-		Token: token,
+		Token:        result.Tokens.AccessToken,
+		RefreshToken: result.Tokens.RefreshToken,
+	}, nil
+}
+
+func (s *serverAPI) VerifyMFA(
+	ctx context.Context,
+	req *sso.VerifyMFARequest,
+) (*sso.VerifyMFAResponse, error) {
+	if err := s.validateVerifyMFA(req); err != nil {
+		return nil, err
+	}
+	result, err := s.auth.VerifyMFA(ctx, req.GetChallengeId(), req.GetCode())
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidChallenge) {
+			return nil, status.Error(codes.Unauthenticated, "invalid or expired challenge")
+		}
+		if errors.Is(err, auth.ErrInvalidMFACode) {
+			return nil, status.Error(codes.InvalidArgument, "invalid code")
+		}
+		var throttledErr *auth.LoginThrottledError
+		if errors.As(err, &throttledErr) {
+			return nil, loginThrottledStatus(throttledErr)
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	if result.PasswordChangeID != "" {
+		return &sso.VerifyMFAResponse{
+			PasswordExpired:  true,
+			PasswordChangeId: result.PasswordChangeID,
+		}, nil
+	}
+	return &sso.VerifyMFAResponse{
+		Token:        result.Tokens.AccessToken,
+		RefreshToken: result.Tokens.RefreshToken,
+	}, nil
+}
+
+func (s *serverAPI) EnrollTOTP(
+	ctx context.Context,
+	req *sso.EnrollTOTPRequest,
+) (*sso.EnrollTOTPResponse, error) {
+	if err := s.validateTokenAndPassword(req.GetToken(), req.GetPassword()); err != nil {
+		return nil, err
+	}
+	secret, uri, err := s.auth.EnrollTOTP(ctx, req.GetToken(), req.GetPassword())
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidAccessToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		}
+		if errors.Is(err, auth.ErrInvalidCredentials) {
+			return nil, status.Error(codes.Unauthenticated, "invalid password")
+		}
+		var throttledErr *auth.LoginThrottledError
+		if errors.As(err, &throttledErr) {
+			return nil, loginThrottledStatus(throttledErr)
+		}
+		if errors.Is(err, auth.ErrDirectoryUnavailable) {
+			return nil, status.Error(codes.Unavailable, "directory unavailable")
+		}
+		if errors.Is(err, auth.ErrMFAAlreadyEnabled) {
+			return nil, status.Error(codes.FailedPrecondition, "mfa already enabled")
+		}
+		if errors.Is(err, auth.ErrTOTPDisabled) {
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &sso.EnrollTOTPResponse{
+		Secret: secret,
+		Uri:    uri,
+	}, nil
+}
+
+func (s *serverAPI) ConfirmTOTP(
+	ctx context.Context,
+	req *sso.ConfirmTOTPRequest,
+) (*sso.ConfirmTOTPResponse, error) {
+	if err := s.validateConfirmTOTP(req); err != nil {
+		return nil, err
+	}
+	recoveryCodes, err := s.auth.ConfirmTOTP(ctx, req.GetToken(), req.GetPassword(), req.GetCode())
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidAccessToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		}
+		if errors.Is(err, auth.ErrInvalidCredentials) {
+			return nil, status.Error(codes.Unauthenticated, "invalid password")
+		}
+		var throttledErr *auth.LoginThrottledError
+		if errors.As(err, &throttledErr) {
+			return nil, loginThrottledStatus(throttledErr)
+		}
+		if errors.Is(err, auth.ErrDirectoryUnavailable) {
+			return nil, status.Error(codes.Unavailable, "directory unavailable")
+		}
+		if errors.Is(err, auth.ErrMFAAlreadyEnabled) || errors.Is(err, auth.ErrMFANotEnrolled) ||
+			errors.Is(err, auth.ErrTOTPDisabled) {
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
+		if errors.Is(err, auth.ErrInvalidMFACode) {
+			return nil, status.Error(codes.InvalidArgument, "invalid code")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &sso.ConfirmTOTPResponse{
+		RecoveryCodes: recoveryCodes,
+	}, nil
+}
+
+func (s *serverAPI) RegenerateRecoveryCodes(
+	ctx context.Context,
+	req *sso.RegenerateRecoveryCodesRequest,
+) (*sso.RegenerateRecoveryCodesResponse, error) {
+	if err := s.validateTokenAndCode(req.GetToken(), req.GetCode()); err != nil {
+		return nil, err
+	}
+	recoveryCodes, err := s.auth.RegenerateRecoveryCodes(ctx, req.GetToken(), req.GetCode())
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidAccessToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		}
+		var throttledErr *auth.LoginThrottledError
+		if errors.As(err, &throttledErr) {
+			return nil, loginThrottledStatus(throttledErr)
+		}
+		if errors.Is(err, auth.ErrMFANotEnrolled) {
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
+		if errors.Is(err, auth.ErrInvalidMFACode) {
+			return nil, status.Error(codes.InvalidArgument, "invalid code")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &sso.RegenerateRecoveryCodesResponse{
+		RecoveryCodes: recoveryCodes,
+	}, nil
+}
+
+func (s *serverAPI) Refresh(
+	ctx context.Context,
+	req *sso.RefreshRequest,
+) (*sso.RefreshResponse, error) {
+	if err := s.validateRefresh(req); err != nil {
+		return nil, err
+	}
+	tokens, err := s.auth.Refresh(ctx, req.GetRefreshToken())
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidToken) || errors.Is(err, auth.ErrTokenReused) {
+			return nil, status.Error(codes.Unauthenticated, "invalid refresh token")
+		}
+		if errors.Is(err, auth.ErrPasswordExpired) {
+			return nil, status.Error(codes.FailedPrecondition, "password expired")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &sso.RefreshResponse{
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
 	}, nil
 }
 
@@ -65,11 +300,18 @@ func (s *serverAPI) Register(
 	if err := s.validateRegister(req); err != nil {
 		return nil, err
 	}
-	userID, err := s.auth.RegisterNewUser(ctx, req.GetEmail(), []byte(req.GetPassword()))
+	userID, err := s.auth.RegisterNewUser(ctx, req.GetEmail(), []byte(req.GetPassword()), int64(req.GetAppId()))
 	if err != nil {
 		if errors.Is(err, auth.ErrUserExists) {
 			return nil, status.Error(codes.InvalidArgument, "invalid credentials")
 		}
+		if errors.Is(err, auth.ErrInvalidAppId) {
+			return nil, status.Error(codes.InvalidArgument, "invalid app_id")
+		}
+		var policyErr *auth.PasswordPolicyError
+		if errors.As(err, &policyErr) {
+			return nil, passwordPolicyStatus("password", policyErr)
+		}
 		return nil, status.Error(codes.Internal, "internal error")
 	}
 	return &sso.RegisterResponse{
@@ -77,6 +319,45 @@ func (s *serverAPI) Register(
 	}, nil
 }
 
+func (s *serverAPI) Logout(
+	ctx context.Context,
+	req *sso.LogoutRequest,
+) (*sso.LogoutResponse, error) {
+	if err := s.validateLogout(req); err != nil {
+		return nil, err
+	}
+	err := s.auth.Logout(ctx, req.GetToken(), req.GetRefreshToken())
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidAccessToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		}
+		if errors.Is(err, auth.ErrInvalidToken) {
+			return nil, status.Error(codes.InvalidArgument, "invalid refresh token")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &sso.LogoutResponse{}, nil
+}
+
+func (s *serverAPI) IsTokenRevoked(
+	ctx context.Context,
+	req *sso.IsTokenRevokedRequest,
+) (*sso.IsTokenRevokedResponse, error) {
+	if err := s.validator.Var(req.GetToken(), "required"); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "token is required")
+	}
+	revoked, err := s.auth.IsTokenRevoked(ctx, req.GetToken())
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidAccessToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &sso.IsTokenRevokedResponse{
+		Revoked: revoked,
+	}, nil
+}
+
 func (s *serverAPI) IsAdmin(
 	ctx context.Context,
 	req *sso.IsAdminRequest,
@@ -96,6 +377,594 @@ func (s *serverAPI) IsAdmin(
 	}, nil
 }
 
+func (s *serverAPI) RequestPasswordReset(
+	ctx context.Context,
+	req *sso.RequestPasswordResetRequest,
+) (*sso.RequestPasswordResetResponse, error) {
+	if err := s.validator.Var(req.GetEmail(), "required,email"); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid email")
+	}
+	if err := s.auth.RequestPasswordReset(ctx, req.GetEmail()); err != nil {
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &sso.RequestPasswordResetResponse{}, nil
+}
+
+func (s *serverAPI) ConfirmPasswordReset(
+	ctx context.Context,
+	req *sso.ConfirmPasswordResetRequest,
+) (*sso.ConfirmPasswordResetResponse, error) {
+	if err := s.validateConfirmPasswordReset(req); err != nil {
+		return nil, err
+	}
+	err := s.auth.ConfirmPasswordReset(ctx, req.GetToken(), []byte(req.GetNewPassword()))
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidResetToken) {
+			return nil, status.Error(codes.InvalidArgument, "invalid or expired token")
+		}
+		var policyErr *auth.PasswordPolicyError
+		if errors.As(err, &policyErr) {
+			return nil, passwordPolicyStatus("new_password", policyErr)
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &sso.ConfirmPasswordResetResponse{}, nil
+}
+
+func (s *serverAPI) VerifyEmail(
+	ctx context.Context,
+	req *sso.VerifyEmailRequest,
+) (*sso.VerifyEmailResponse, error) {
+	if err := s.validator.Var(req.GetToken(), "required"); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "token is required")
+	}
+	if err := s.auth.VerifyEmail(ctx, req.GetToken()); err != nil {
+		if errors.Is(err, auth.ErrInvalidVerificationToken) {
+			return nil, status.Error(codes.InvalidArgument, "invalid or expired token")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &sso.VerifyEmailResponse{}, nil
+}
+
+func (s *serverAPI) ResendVerificationEmail(
+	ctx context.Context,
+	req *sso.ResendVerificationEmailRequest,
+) (*sso.ResendVerificationEmailResponse, error) {
+	if err := s.validator.Var(req.GetEmail(), "required,email"); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid email")
+	}
+	if err := s.auth.ResendVerificationEmail(ctx, req.GetEmail()); err != nil {
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &sso.ResendVerificationEmailResponse{}, nil
+}
+
+func (s *serverAPI) BeginPasskeyRegistration(
+	ctx context.Context,
+	req *sso.BeginPasskeyRegistrationRequest,
+) (*sso.BeginPasskeyRegistrationResponse, error) {
+	if err := s.validateBeginPasskeyRegistration(req); err != nil {
+		return nil, err
+	}
+	sessionID, options, err := s.auth.BeginPasskeyRegistration(ctx, req.GetToken(), req.GetPassword(), req.GetCode())
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidAccessToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		}
+		if errors.Is(err, auth.ErrInvalidCredentials) {
+			return nil, status.Error(codes.Unauthenticated, "invalid password")
+		}
+		var throttledErr *auth.LoginThrottledError
+		if errors.As(err, &throttledErr) {
+			return nil, loginThrottledStatus(throttledErr)
+		}
+		if errors.Is(err, auth.ErrDirectoryUnavailable) {
+			return nil, status.Error(codes.Unavailable, "directory unavailable")
+		}
+		if errors.Is(err, auth.ErrInvalidMFACode) {
+			return nil, status.Error(codes.Unauthenticated, "invalid code")
+		}
+		if errors.Is(err, auth.ErrMFANotEnrolled) {
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &sso.BeginPasskeyRegistrationResponse{
+		SessionId: sessionID,
+		Options:   string(options),
+	}, nil
+}
+
+func (s *serverAPI) FinishPasskeyRegistration(
+	ctx context.Context,
+	req *sso.FinishPasskeyRegistrationRequest,
+) (*sso.FinishPasskeyRegistrationResponse, error) {
+	if err := s.validateFinishPasskeyRegistration(req); err != nil {
+		return nil, err
+	}
+	err := s.auth.FinishPasskeyRegistration(ctx, req.GetToken(), req.GetSessionId(), []byte(req.GetCredential()))
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidAccessToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		}
+		if errors.Is(err, auth.ErrInvalidPasskeySession) {
+			return nil, status.Error(codes.InvalidArgument, "invalid or expired session")
+		}
+		if errors.Is(err, auth.ErrInvalidPasskey) {
+			return nil, status.Error(codes.InvalidArgument, "invalid credential")
+		}
+		if errors.Is(err, auth.ErrPasskeyExists) {
+			return nil, status.Error(codes.AlreadyExists, "passkey already registered")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &sso.FinishPasskeyRegistrationResponse{}, nil
+}
+
+func (s *serverAPI) BeginPasskeyLogin(
+	ctx context.Context,
+	req *sso.BeginPasskeyLoginRequest,
+) (*sso.BeginPasskeyLoginResponse, error) {
+	if req.GetAppId() == emptyValue {
+		return nil, status.Error(codes.InvalidArgument, "app_id is required")
+	}
+	sessionID, options, err := s.auth.BeginPasskeyLogin(ctx, int(req.GetAppId()))
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidAppId) {
+			return nil, status.Error(codes.InvalidArgument, "invalid app_id")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &sso.BeginPasskeyLoginResponse{
+		SessionId: sessionID,
+		Options:   string(options),
+	}, nil
+}
+
+func (s *serverAPI) FinishPasskeyLogin(
+	ctx context.Context,
+	req *sso.FinishPasskeyLoginRequest,
+) (*sso.FinishPasskeyLoginResponse, error) {
+	if err := s.validateFinishPasskeyLogin(req); err != nil {
+		return nil, err
+	}
+	tokens, err := s.auth.FinishPasskeyLogin(ctx, req.GetSessionId(), []byte(req.GetCredential()))
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidPasskeySession) {
+			return nil, status.Error(codes.InvalidArgument, "invalid or expired session")
+		}
+		if errors.Is(err, auth.ErrInvalidPasskey) {
+			return nil, status.Error(codes.Unauthenticated, "invalid credential")
+		}
+		if errors.Is(err, auth.ErrEmailNotVerified) {
+			return nil, status.Error(codes.FailedPrecondition, "email not verified")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &sso.FinishPasskeyLoginResponse{
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+	}, nil
+}
+
+func (s *serverAPI) BeginFederatedLogin(
+	ctx context.Context,
+	req *sso.BeginFederatedLoginRequest,
+) (*sso.BeginFederatedLoginResponse, error) {
+	if err := s.validateBeginFederatedLogin(req); err != nil {
+		return nil, err
+	}
+	authURL, state, err := s.auth.BeginFederatedLogin(ctx, int(req.GetAppId()), req.GetProvider(), req.GetRedirectUri())
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidAppId) {
+			return nil, status.Error(codes.InvalidArgument, "invalid app_id")
+		}
+		if errors.Is(err, auth.ErrInvalidRedirectURI) {
+			return nil, status.Error(codes.InvalidArgument, "invalid redirect_uri")
+		}
+		if errors.Is(err, auth.ErrUnknownProvider) {
+			return nil, status.Error(codes.InvalidArgument, "unknown provider")
+		}
+		if errors.Is(err, auth.ErrFederationFailed) {
+			return nil, status.Error(codes.Unavailable, "provider unavailable")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &sso.BeginFederatedLoginResponse{
+		AuthorizationUrl: authURL,
+		State:            state,
+	}, nil
+}
+
+func (s *serverAPI) FinishFederatedLogin(
+	ctx context.Context,
+	req *sso.FinishFederatedLoginRequest,
+) (*sso.FinishFederatedLoginResponse, error) {
+	if err := s.validateFinishFederatedLogin(req); err != nil {
+		return nil, err
+	}
+	tokens, err := s.auth.FinishFederatedLogin(ctx, req.GetState(), req.GetCode())
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidFederatedLogin) {
+			return nil, status.Error(codes.InvalidArgument, "invalid or expired state")
+		}
+		if errors.Is(err, auth.ErrFederationFailed) {
+			return nil, status.Error(codes.Unauthenticated, "upstream authentication failed")
+		}
+		if errors.Is(err, auth.ErrEmailNotVerified) {
+			return nil, status.Error(codes.FailedPrecondition, "email not verified")
+		}
+		if errors.Is(err, auth.ErrIdentityConflict) {
+			return nil, status.Error(codes.FailedPrecondition, "account already exists, sign in to link the identity")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &sso.FinishFederatedLoginResponse{
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+	}, nil
+}
+
+func (s *serverAPI) LinkIdentity(
+	ctx context.Context,
+	req *sso.LinkIdentityRequest,
+) (*sso.LinkIdentityResponse, error) {
+	if err := s.validateLinkIdentity(req); err != nil {
+		return nil, err
+	}
+	err := s.auth.LinkIdentity(ctx, req.GetToken(), req.GetPassword(), req.GetState(), req.GetCode())
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidAccessToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		}
+		if errors.Is(err, auth.ErrInvalidCredentials) {
+			return nil, status.Error(codes.Unauthenticated, "invalid password")
+		}
+		var throttledErr *auth.LoginThrottledError
+		if errors.As(err, &throttledErr) {
+			return nil, loginThrottledStatus(throttledErr)
+		}
+		if errors.Is(err, auth.ErrDirectoryUnavailable) {
+			return nil, status.Error(codes.Unavailable, "directory unavailable")
+		}
+		if errors.Is(err, auth.ErrInvalidFederatedLogin) {
+			return nil, status.Error(codes.InvalidArgument, "invalid or expired state")
+		}
+		if errors.Is(err, auth.ErrFederationFailed) {
+			return nil, status.Error(codes.Unauthenticated, "upstream authentication failed")
+		}
+		if errors.Is(err, auth.ErrIdentityLinked) {
+			return nil, status.Error(codes.AlreadyExists, "identity already linked")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &sso.LinkIdentityResponse{}, nil
+}
+
+func (s *serverAPI) UnlinkIdentity(
+	ctx context.Context,
+	req *sso.UnlinkIdentityRequest,
+) (*sso.UnlinkIdentityResponse, error) {
+	if err := s.validateUnlinkIdentity(req); err != nil {
+		return nil, err
+	}
+	if err := s.auth.UnlinkIdentity(ctx, req.GetToken(), req.GetPassword(), req.GetProvider()); err != nil {
+		if errors.Is(err, auth.ErrInvalidAccessToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		}
+		if errors.Is(err, auth.ErrInvalidCredentials) {
+			return nil, status.Error(codes.Unauthenticated, "invalid password")
+		}
+		var throttledErr *auth.LoginThrottledError
+		if errors.As(err, &throttledErr) {
+			return nil, loginThrottledStatus(throttledErr)
+		}
+		if errors.Is(err, auth.ErrDirectoryUnavailable) {
+			return nil, status.Error(codes.Unavailable, "directory unavailable")
+		}
+		if errors.Is(err, auth.ErrUnknownProvider) {
+			return nil, status.Error(codes.InvalidArgument, "unknown provider")
+		}
+		if errors.Is(err, auth.ErrIdentityNotLinked) {
+			return nil, status.Error(codes.NotFound, "identity not linked")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &sso.UnlinkIdentityResponse{}, nil
+}
+
+func (s *serverAPI) LinkDirectory(
+	ctx context.Context,
+	req *sso.LinkDirectoryRequest,
+) (*sso.LinkDirectoryResponse, error) {
+	if err := s.validateLinkDirectory(req); err != nil {
+		return nil, err
+	}
+	err := s.auth.LinkDirectory(ctx, req.GetToken(), req.GetPassword(), int(req.GetAppId()), req.GetDirectoryPassword())
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidAccessToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		}
+		if errors.Is(err, auth.ErrInvalidCredentials) {
+			return nil, status.Error(codes.Unauthenticated, "invalid password")
+		}
+		var throttledErr *auth.LoginThrottledError
+		if errors.As(err, &throttledErr) {
+			return nil, loginThrottledStatus(throttledErr)
+		}
+		if errors.Is(err, auth.ErrInvalidDirectoryCredentials) {
+			return nil, status.Error(codes.Unauthenticated, "invalid directory credentials")
+		}
+		if errors.Is(err, auth.ErrUnknownDirectory) {
+			return nil, status.Error(codes.InvalidArgument, "app has no directory")
+		}
+		if errors.Is(err, auth.ErrDirectoryUnavailable) {
+			return nil, status.Error(codes.Unavailable, "directory unavailable")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &sso.LinkDirectoryResponse{}, nil
+}
+
+func (s *serverAPI) ChangePassword(
+	ctx context.Context,
+	req *sso.ChangePasswordRequest,
+) (*sso.ChangePasswordResponse, error) {
+	if err := s.validateChangePassword(req); err != nil {
+		return nil, err
+	}
+	tokens, err := s.auth.ChangePassword(ctx, req.GetToken(), req.GetPasswordChangeId(), req.GetCurrentPassword(), req.GetNewPassword())
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidAccessToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		}
+		if errors.Is(err, auth.ErrInvalidPasswordChange) {
+			return nil, status.Error(codes.InvalidArgument, "invalid or expired password change")
+		}
+		if errors.Is(err, auth.ErrInvalidCredentials) {
+			return nil, status.Error(codes.Unauthenticated, "invalid password")
+		}
+		var throttledErr *auth.LoginThrottledError
+		if errors.As(err, &throttledErr) {
+			return nil, loginThrottledStatus(throttledErr)
+		}
+		if errors.Is(err, auth.ErrDirectoryUnavailable) {
+			return nil, status.Error(codes.Unavailable, "directory unavailable")
+		}
+		var policyErr *auth.PasswordPolicyError
+		if errors.As(err, &policyErr) {
+			return nil, passwordPolicyStatus("new_password", policyErr)
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	if tokens == nil {
+		return &sso.ChangePasswordResponse{}, nil
+	}
+	return &sso.ChangePasswordResponse{
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+	}, nil
+}
+
+func (s *serverAPI) ChangeEmail(
+	ctx context.Context,
+	req *sso.ChangeEmailRequest,
+) (*sso.ChangeEmailResponse, error) {
+	if err := s.validateChangeEmail(req); err != nil {
+		return nil, err
+	}
+	if err := s.auth.ChangeEmail(ctx, req.GetToken(), req.GetPassword(), req.GetNewEmail()); err != nil {
+		if errors.Is(err, auth.ErrInvalidAccessToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		}
+		if errors.Is(err, auth.ErrInvalidCredentials) {
+			return nil, status.Error(codes.Unauthenticated, "invalid password")
+		}
+		var throttledErr *auth.LoginThrottledError
+		if errors.As(err, &throttledErr) {
+			return nil, loginThrottledStatus(throttledErr)
+		}
+		if errors.Is(err, auth.ErrDirectoryUnavailable) {
+			return nil, status.Error(codes.Unavailable, "directory unavailable")
+		}
+		if errors.Is(err, auth.ErrEmailUnchanged) {
+			return nil, status.Error(codes.InvalidArgument, "new_email is the current email")
+		}
+		if errors.Is(err, auth.ErrUserExists) {
+			return nil, status.Error(codes.AlreadyExists, "email already in use")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &sso.ChangeEmailResponse{}, nil
+}
+
+func (s *serverAPI) ConfirmEmailChange(
+	ctx context.Context,
+	req *sso.ConfirmEmailChangeRequest,
+) (*sso.ConfirmEmailChangeResponse, error) {
+	if err := s.validator.Var(req.GetToken(), "required"); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "token is required")
+	}
+	if err := s.auth.ConfirmEmailChange(ctx, req.GetToken()); err != nil {
+		if errors.Is(err, auth.ErrInvalidEmailChangeToken) {
+			return nil, status.Error(codes.InvalidArgument, "invalid or expired token")
+		}
+		if errors.Is(err, auth.ErrUserExists) {
+			return nil, status.Error(codes.AlreadyExists, "email already in use")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &sso.ConfirmEmailChangeResponse{}, nil
+}
+
+func (s *serverAPI) UnlockUser(
+	ctx context.Context,
+	req *sso.UnlockUserRequest,
+) (*sso.UnlockUserResponse, error) {
+	if err := s.validateUnlockUser(req); err != nil {
+		return nil, err
+	}
+	if err := s.auth.UnlockUser(ctx, req.GetToken(), req.GetUserId()); err != nil {
+		if errors.Is(err, auth.ErrInvalidAccessToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		}
+		if errors.Is(err, auth.ErrPermissionDenied) {
+			return nil, status.Error(codes.PermissionDenied, "admin required")
+		}
+		if errors.Is(err, auth.ErrUserNotFound) {
+			return nil, status.Error(codes.NotFound, "user not found")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &sso.UnlockUserResponse{}, nil
+}
+
+func (s *serverAPI) ClientCredentials(
+	ctx context.Context,
+	req *sso.ClientCredentialsRequest,
+) (*sso.ClientCredentialsResponse, error) {
+	if err := s.validateClientCredentials(req); err != nil {
+		return nil, err
+	}
+	tokens, err := s.auth.ClientCredentials(ctx, req.GetClientId(), req.GetClientSecret(), req.GetScope())
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidClient) {
+			return nil, status.Error(codes.Unauthenticated, "invalid client credentials")
+		}
+		if errors.Is(err, auth.ErrInvalidScope) {
+			return nil, status.Error(codes.PermissionDenied, "scope not allowed")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &sso.ClientCredentialsResponse{
+		Token:     tokens.AccessToken,
+		ExpiresIn: int64(tokens.ExpiresIn.Seconds()),
+		Scope:     tokens.Scope,
+	}, nil
+}
+
+func (s *serverAPI) ApproveDevice(
+	ctx context.Context,
+	req *sso.ApproveDeviceRequest,
+) (*sso.ApproveDeviceResponse, error) {
+	if err := s.validateApproveDevice(req); err != nil {
+		return nil, err
+	}
+	if err := s.auth.ApproveDevice(ctx, req.GetToken(), req.GetUserCode(), req.GetApprove()); err != nil {
+		if errors.Is(err, auth.ErrInvalidAccessToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		}
+		if errors.Is(err, auth.ErrInvalidUserCode) {
+			return nil, status.Error(codes.InvalidArgument, "invalid or expired user code")
+		}
+		if errors.Is(err, auth.ErrEmailNotVerified) {
+			return nil, status.Error(codes.FailedPrecondition, "email not verified")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &sso.ApproveDeviceResponse{}, nil
+}
+
+func (s *serverAPI) ValidateToken(
+	ctx context.Context,
+	req *sso.ValidateTokenRequest,
+) (*sso.ValidateTokenResponse, error) {
+	if err := s.validateValidateToken(req); err != nil {
+		return nil, err
+	}
+	info, err := s.auth.ValidateToken(ctx, req.GetToken(), int(req.GetAppId()))
+	if err != nil {
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	if !info.Active {
+		return &sso.ValidateTokenResponse{}, nil
+	}
+	return &sso.ValidateTokenResponse{
+		Active:    true,
+		TokenId:   info.ID,
+		UserId:    info.UserID,
+		Email:     info.Email,
+		ClientId:  info.ClientID,
+		AppId:     info.AppID,
+		Scope:     info.Scope,
+		ExpiresAt: info.ExpiresAt.Unix(),
+		Actor:     info.Actor,
+	}, nil
+}
+
+func (s *serverAPI) ExchangeToken(
+	ctx context.Context,
+	req *sso.ExchangeTokenRequest,
+) (*sso.ExchangeTokenResponse, error) {
+	if err := s.validateExchangeToken(req); err != nil {
+		return nil, err
+	}
+	tokens, err := s.auth.ExchangeToken(ctx, req.GetClientId(), req.GetClientSecret(), &models.TokenExchangeRequest{
+		SubjectToken:     req.GetSubjectToken(),
+		SubjectTokenType: req.GetSubjectTokenType(),
+		ActorToken:       req.GetActorToken(),
+		ActorTokenType:   req.GetActorTokenType(),
+		Audience:         req.GetAudience(),
+		Scope:            req.GetScope(),
+	})
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidClient) {
+			return nil, status.Error(codes.Unauthenticated, "invalid client credentials")
+		}
+		if errors.Is(err, auth.ErrInvalidTarget) {
+			return nil, status.Error(codes.PermissionDenied, "audience not allowed")
+		}
+		if errors.Is(err, auth.ErrInvalidScope) {
+			return nil, status.Error(codes.PermissionDenied, "scope not allowed")
+		}
+		if errors.Is(err, auth.ErrImpersonationNotAllowed) {
+			return nil, status.Error(codes.PermissionDenied, "impersonation not allowed")
+		}
+		if errors.Is(err, auth.ErrUnsupportedTokenType) {
+			return nil, status.Error(codes.InvalidArgument, "unsupported token type")
+		}
+		if errors.Is(err, auth.ErrInvalidGrant) {
+			return nil, status.Error(codes.InvalidArgument, "invalid subject or actor token")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &sso.ExchangeTokenResponse{
+		Token:           tokens.AccessToken,
+		ExpiresIn:       int64(tokens.ExpiresIn.Seconds()),
+		Scope:           tokens.Scope,
+		IssuedTokenType: auth.TokenTypeAccessToken,
+	}, nil
+}
+
+func (s *serverAPI) GetJWKS(
+	ctx context.Context,
+	req *sso.GetJWKSRequest,
+) (*sso.GetJWKSResponse, error) {
+	set, err := s.auth.JWKS(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+
+	keys := make([]*sso.JsonWebKey, 0, len(set.Keys))
+	for _, k := range set.Keys {
+		keys = append(keys, &sso.JsonWebKey{
+			Kty: k.Kty,
+			Kid: k.Kid,
+			Use: k.Use,
+			Alg: k.Alg,
+			N:   k.N,
+			E:   k.E,
+			Crv: k.Crv,
+			X:   k.X,
+			Y:   k.Y,
+		})
+	}
+	return &sso.GetJWKSResponse{
+		Keys: keys,
+	}, nil
+}
+
 func (s *serverAPI) validateLogin(req *sso.LoginRequest) error {
 	if err := s.validator.Var(req.GetEmail(), "required,email"); err != nil {
 		return status.Error(codes.InvalidArgument, "invalid email")
@@ -117,19 +986,308 @@ func (s *serverAPI) validateRegister(req *sso.RegisterRequest) error {
 	if err := s.validator.Var(req.GetEmail(), "required,email"); err != nil {
 		return status.Error(codes.InvalidArgument, "invalid email")
 	}
-	if err := s.validator.Var(
-		req.GetPassword(),
-		"required,min=8,contains_uppercase,contains_special"); err != nil {
-		return status.Error(
-			codes.InvalidArgument,
-			"password must contain at least one upper, one digit and one spec.symbol")
+	if err := s.validator.Var(req.GetPassword(), "required"); err != nil {
+		return status.Error(codes.InvalidArgument, "password is required")
 	}
 	return nil
 }
 
+// passwordPolicyStatus lists the broken rules of the password policy in the details,
+// a BadRequest with a violation of the field per rule and an ErrorInfo keyed by the rules.
+func passwordPolicyStatus(field string, policyErr *auth.PasswordPolicyError) error {
+	badRequest := &errdetails.BadRequest{}
+	info := &errdetails.ErrorInfo{
+		Reason:   passwordPolicyReason,
+		Domain:   errorDomain,
+		Metadata: make(map[string]string, len(policyErr.Violations)),
+	}
+	for _, v := range policyErr.Violations {
+		badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       field,
+			Description: v.Message,
+		})
+		info.Metadata[v.Rule] = v.Message
+	}
+
+	st := status.New(codes.InvalidArgument, policyErr.Error())
+	detailed, err := st.WithDetails(badRequest, info)
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+// loginThrottledStatus tells the client when to retry, in a RetryInfo and in the metadata
+// of an ErrorInfo in seconds. A locked account is PermissionDenied, a delayed login ResourceExhausted.
+func loginThrottledStatus(throttledErr *auth.LoginThrottledError) error {
+	code, reason := codes.ResourceExhausted, tooManyAttemptsReason
+	if errors.Is(throttledErr, auth.ErrAccountLocked) {
+		code, reason = codes.PermissionDenied, accountLockedReason
+	}
+	retryAfter := throttledErr.RetryAfter.Round(time.Second)
+	if retryAfter < time.Second {
+		retryAfter = time.Second
+	}
+
+	st := status.New(code, throttledErr.Error())
+	detailed, err := st.WithDetails(
+		&errdetails.RetryInfo{RetryDelay: durationpb.New(retryAfter)},
+		&errdetails.ErrorInfo{
+			Reason:   reason,
+			Domain:   errorDomain,
+			Metadata: map[string]string{"retry_after": strconv.Itoa(int(retryAfter.Seconds()))},
+		},
+	)
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
 func (s *serverAPI) validateIsAdmin(req *sso.IsAdminRequest) error {
 	if req.GetUserId() == emptyValue {
 		return status.Error(codes.InvalidArgument, "invalid userID")
 	}
 	return nil
 }
+
+func (s *serverAPI) validateRefresh(req *sso.RefreshRequest) error {
+	if err := s.validator.Var(req.GetRefreshToken(), "required"); err != nil {
+		return status.Error(codes.InvalidArgument, "refresh_token is required")
+	}
+	return nil
+}
+
+func (s *serverAPI) validateLogout(req *sso.LogoutRequest) error {
+	if err := s.validator.Var(req.GetToken(), "required"); err != nil {
+		return status.Error(codes.InvalidArgument, "token is required")
+	}
+	return nil
+}
+
+func (s *serverAPI) validateConfirmPasswordReset(req *sso.ConfirmPasswordResetRequest) error {
+	if err := s.validator.Var(req.GetToken(), "required"); err != nil {
+		return status.Error(codes.InvalidArgument, "token is required")
+	}
+	if err := s.validator.Var(req.GetNewPassword(), "required"); err != nil {
+		return status.Error(codes.InvalidArgument, "new_password is required")
+	}
+	return nil
+}
+
+func (s *serverAPI) validateVerifyMFA(req *sso.VerifyMFARequest) error {
+	if err := s.validator.Var(req.GetChallengeId(), "required"); err != nil {
+		return status.Error(codes.InvalidArgument, "challenge_id is required")
+	}
+	if err := s.validator.Var(req.GetCode(), "required"); err != nil {
+		return status.Error(codes.InvalidArgument, "code is required")
+	}
+	return nil
+}
+
+func (s *serverAPI) validateConfirmTOTP(req *sso.ConfirmTOTPRequest) error {
+	if err := s.validateTokenAndPassword(req.GetToken(), req.GetPassword()); err != nil {
+		return err
+	}
+	if err := s.validator.Var(req.GetCode(), "required,numeric,len=6"); err != nil {
+		return status.Error(codes.InvalidArgument, "invalid code")
+	}
+	return nil
+}
+
+func (s *serverAPI) validateBeginFederatedLogin(req *sso.BeginFederatedLoginRequest) error {
+	if req.GetAppId() == emptyValue {
+		return status.Error(codes.InvalidArgument, "app_id is required")
+	}
+	if err := s.validator.Var(req.GetProvider(), "required"); err != nil {
+		return status.Error(codes.InvalidArgument, "provider is required")
+	}
+	if err := s.validator.Var(req.GetRedirectUri(), "required,url"); err != nil {
+		return status.Error(codes.InvalidArgument, "invalid redirect_uri")
+	}
+	return nil
+}
+
+func (s *serverAPI) validateFinishFederatedLogin(req *sso.FinishFederatedLoginRequest) error {
+	if err := s.validator.Var(req.GetState(), "required"); err != nil {
+		return status.Error(codes.InvalidArgument, "state is required")
+	}
+	if err := s.validator.Var(req.GetCode(), "required"); err != nil {
+		return status.Error(codes.InvalidArgument, "code is required")
+	}
+	return nil
+}
+
+func (s *serverAPI) validateLinkIdentity(req *sso.LinkIdentityRequest) error {
+	if err := s.validateTokenAndPassword(req.GetToken(), req.GetPassword()); err != nil {
+		return err
+	}
+	if err := s.validator.Var(req.GetState(), "required"); err != nil {
+		return status.Error(codes.InvalidArgument, "state is required")
+	}
+	if err := s.validator.Var(req.GetCode(), "required"); err != nil {
+		return status.Error(codes.InvalidArgument, "code is required")
+	}
+	return nil
+}
+
+func (s *serverAPI) validateUnlinkIdentity(req *sso.UnlinkIdentityRequest) error {
+	if err := s.validateTokenAndPassword(req.GetToken(), req.GetPassword()); err != nil {
+		return err
+	}
+	if err := s.validator.Var(req.GetProvider(), "required"); err != nil {
+		return status.Error(codes.InvalidArgument, "provider is required")
+	}
+	return nil
+}
+
+func (s *serverAPI) validateLinkDirectory(req *sso.LinkDirectoryRequest) error {
+	if err := s.validateTokenAndPassword(req.GetToken(), req.GetPassword()); err != nil {
+		return err
+	}
+	if req.GetAppId() == emptyValue {
+		return status.Error(codes.InvalidArgument, "app_id is required")
+	}
+	if err := s.validator.Var(req.GetDirectoryPassword(), "required"); err != nil {
+		return status.Error(codes.InvalidArgument, "directory_password is required")
+	}
+	return nil
+}
+
+func (s *serverAPI) validateChangePassword(req *sso.ChangePasswordRequest) error {
+	if (req.GetToken() == "") == (req.GetPasswordChangeId() == "") {
+		return status.Error(codes.InvalidArgument, "either token or password_change_id is required")
+	}
+	if err := s.validator.Var(req.GetCurrentPassword(), "required"); err != nil {
+		return status.Error(codes.InvalidArgument, "current_password is required")
+	}
+	if err := s.validator.Var(req.GetNewPassword(), "required"); err != nil {
+		return status.Error(codes.InvalidArgument, "new_password is required")
+	}
+	return nil
+}
+
+func (s *serverAPI) validateChangeEmail(req *sso.ChangeEmailRequest) error {
+	if err := s.validateTokenAndPassword(req.GetToken(), req.GetPassword()); err != nil {
+		return err
+	}
+	if err := s.validator.Var(req.GetNewEmail(), "required,email"); err != nil {
+		return status.Error(codes.InvalidArgument, "invalid new_email")
+	}
+	return nil
+}
+
+func (s *serverAPI) validateUnlockUser(req *sso.UnlockUserRequest) error {
+	if err := s.validator.Var(req.GetToken(), "required"); err != nil {
+		return status.Error(codes.InvalidArgument, "token is required")
+	}
+	if req.GetUserId() == emptyValue {
+		return status.Error(codes.InvalidArgument, "user_id is required")
+	}
+	return nil
+}
+
+// validateBeginPasskeyRegistration requires the token and either the password or a TOTP code.
+func (s *serverAPI) validateBeginPasskeyRegistration(req *sso.BeginPasskeyRegistrationRequest) error {
+	if err := s.validator.Var(req.GetToken(), "required"); err != nil {
+		return status.Error(codes.InvalidArgument, "token is required")
+	}
+	if req.GetPassword() == "" && req.GetCode() == "" {
+		return status.Error(codes.InvalidArgument, "password or code is required")
+	}
+	return nil
+}
+
+// validateTokenAndPassword validates requests authenticated by an access token and the current password.
+func (s *serverAPI) validateTokenAndPassword(token, password string) error {
+	if err := s.validator.Var(token, "required"); err != nil {
+		return status.Error(codes.InvalidArgument, "token is required")
+	}
+	if err := s.validator.Var(password, "required"); err != nil {
+		return status.Error(codes.InvalidArgument, "password is required")
+	}
+	return nil
+}
+
+func (s *serverAPI) validateClientCredentials(req *sso.ClientCredentialsRequest) error {
+	if err := s.validator.Var(req.GetClientId(), "required"); err != nil {
+		return status.Error(codes.InvalidArgument, "client_id is required")
+	}
+	if err := s.validator.Var(req.GetClientSecret(), "required"); err != nil {
+		return status.Error(codes.InvalidArgument, "client_secret is required")
+	}
+	return nil
+}
+
+func (s *serverAPI) validateExchangeToken(req *sso.ExchangeTokenRequest) error {
+	if err := s.validator.Var(req.GetClientId(), "required"); err != nil {
+		return status.Error(codes.InvalidArgument, "client_id is required")
+	}
+	if err := s.validator.Var(req.GetClientSecret(), "required"); err != nil {
+		return status.Error(codes.InvalidArgument, "client_secret is required")
+	}
+	if err := s.validator.Var(req.GetSubjectToken(), "required"); err != nil {
+		return status.Error(codes.InvalidArgument, "subject_token is required")
+	}
+	if err := s.validator.Var(req.GetSubjectTokenType(), "required"); err != nil {
+		return status.Error(codes.InvalidArgument, "subject_token_type is required")
+	}
+	if err := s.validator.Var(req.GetAudience(), "required"); err != nil {
+		return status.Error(codes.InvalidArgument, "audience is required")
+	}
+	return nil
+}
+
+func (s *serverAPI) validateValidateToken(req *sso.ValidateTokenRequest) error {
+	if err := s.validator.Var(req.GetToken(), "required"); err != nil {
+		return status.Error(codes.InvalidArgument, "token is required")
+	}
+	if req.GetAppId() == emptyValue {
+		return status.Error(codes.InvalidArgument, "app_id is required")
+	}
+	return nil
+}
+
+func (s *serverAPI) validateApproveDevice(req *sso.ApproveDeviceRequest) error {
+	if err := s.validator.Var(req.GetToken(), "required"); err != nil {
+		return status.Error(codes.InvalidArgument, "token is required")
+	}
+	if err := s.validator.Var(req.GetUserCode(), "required"); err != nil {
+		return status.Error(codes.InvalidArgument, "user_code is required")
+	}
+	return nil
+}
+
+// validateTokenAndCode validates requests authenticated by an access token and a TOTP code.
+func (s *serverAPI) validateTokenAndCode(token, code string) error {
+	if err := s.validator.Var(token, "required"); err != nil {
+		return status.Error(codes.InvalidArgument, "token is required")
+	}
+	if err := s.validator.Var(code, "required,numeric,len=6"); err != nil {
+		return status.Error(codes.InvalidArgument, "invalid code")
+	}
+	return nil
+}
+
+func (s *serverAPI) validateFinishPasskeyRegistration(req *sso.FinishPasskeyRegistrationRequest) error {
+	if err := s.validator.Var(req.GetToken(), "required"); err != nil {
+		return status.Error(codes.InvalidArgument, "token is required")
+	}
+	if err := s.validator.Var(req.GetSessionId(), "required"); err != nil {
+		return status.Error(codes.InvalidArgument, "session_id is required")
+	}
+	if err := s.validator.Var(req.GetCredential(), "required,json"); err != nil {
+		return status.Error(codes.InvalidArgument, "invalid credential")
+	}
+	return nil
+}
+
+func (s *serverAPI) validateFinishPasskeyLogin(req *sso.FinishPasskeyLoginRequest) error {
+	if err := s.validator.Var(req.GetSessionId(), "required"); err != nil {
+		return status.Error(codes.InvalidArgument, "session_id is required")
+	}
+	if err := s.validator.Var(req.GetCredential(), "required,json"); err != nil {
+		return status.Error(codes.InvalidArgument, "invalid credential")
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"errors"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sso/internal/services/auth"
+	"strconv"
+)
+
+var devicePage = template.Must(template.New("device").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Connect a device</title></head>
+<body>
+<h1>Connect a device</h1>
+{{if .Error}}<p role="alert">{{.Error}}</p>{{end}}
+{{if .Done}}<p>{{.Done}}</p>{{else}}
+<form method="post" action="` + deviceVerificationPath + `">
+<label>Code shown on the device <input type="text" name="user_code" value="{{.UserCode}}" autocomplete="off" required></label>
+<label>Email <input type="email" name="email" value="{{.Email}}" autocomplete="username" required></label>
+<label>Password <input type="password" name="password" autocomplete="current-password" required></label>
+{{if .MFARequired}}<label>Authentication code <input type="text" name="mfa_code" autocomplete="one-time-code" required></label>{{end}}
+<button type="submit" name="action" value="approve">Approve</button>
+<button type="submit" name="action" value="deny">Deny</button>
+</form>
+{{end}}
+</body>
+</html>
+`))
+
+type devicePageData struct {
+	UserCode    string
+	Email       string
+	MFARequired bool
+	Error       string
+	Done        string
+}
+
+type deviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+// deviceAuthorization is the device authorization endpoint (RFC 8628 section 3.1).
+func (h *handler) deviceAuthorization(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	if r.Method != http.MethodPost {
+		writeOAuthError(w, http.StatusMethodNotAllowed, errInvalidRequest, "method not allowed")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, errInvalidRequest, "malformed request")
+		return
+	}
+
+	clientID, err := strconv.Atoi(r.PostForm.Get("client_id"))
+	if err != nil {
+		writeOAuthError(w, http.StatusUnauthorized, errInvalidClient, "client_id is required")
+		return
+	}
+
+	authorization, err := h.auth.StartDeviceAuthorization(r.Context(), clientID, r.PostForm.Get("scope"))
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidAppId) {
+			writeOAuthError(w, http.StatusUnauthorized, errInvalidClient, "")
+			return
+		}
+		h.log.Error("failed to start device authorization", slog.String("error", err.Error()))
+		writeOAuthError(w, http.StatusInternalServerError, errServerError, "")
+		return
+	}
+
+	verificationURI := h.issuer + deviceVerificationPath
+	writeJSON(w, http.StatusOK, deviceAuthorizationResponse{
+		DeviceCode:              authorization.DeviceCode,
+		UserCode:                authorization.UserCode,
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: verificationURI + "?" + url.Values{"user_code": {authorization.UserCode}}.Encode(),
+		ExpiresIn:               int64(authorization.ExpiresIn.Seconds()),
+		Interval:                int64(authorization.Interval.Seconds()),
+	})
+}
+
+// deviceVerification is the page where the user approves or denies the device.
+func (h *handler) deviceVerification(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		renderError(w, http.StatusBadRequest, "malformed request")
+		return
+	}
+
+	page := &devicePageData{
+		UserCode: r.Form.Get("user_code"),
+	}
+	if r.Method == http.MethodGet {
+		renderDevice(w, page)
+		return
+	}
+
+	page.Email = r.PostForm.Get("email")
+	approve := r.PostForm.Get("action") == "approve"
+	err := h.auth.VerifyDeviceCode(r.Context(), page.UserCode, page.Email,
+		r.PostForm.Get("password"), r.PostForm.Get("mfa_code"), approve)
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrInvalidUserCode):
+			page.Error = "The code is invalid or expired"
+		case errors.Is(err, auth.ErrInvalidCredentials):
+			page.Error = "Invalid email or password"
+		case errors.Is(err, auth.ErrMFARequired):
+			page.MFARequired = true
+		case errors.Is(err, auth.ErrInvalidMFACode):
+			page.MFARequired = true
+			page.Error = "Invalid authentication code"
+		case errors.Is(err, auth.ErrEmailNotVerified):
+			page.Error = "Verify your email first"
+		case errors.Is(err, auth.ErrIdentityConflict):
+			page.Error = "An account with your email exists, link it to the directory first"
+		case errors.Is(err, auth.ErrPasswordExpired):
+			page.Error = "Your password expired, change it before signing in"
+		case errors.Is(err, auth.ErrTooManyAttempts), errors.Is(err, auth.ErrAccountLocked):
+			page.Error = "Too many failed attempts, try again later"
+		default:
+			h.log.Error("failed to verify device code", slog.String("error", err.Error()))
+			renderError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		renderDevice(w, page)
+		return
+	}
+
+	page.Done = "The device was denied access."
+	if approve {
+		page.Done = "The device is connected, you can return to it."
+	}
+	renderDevice(w, page)
+}
+
+func renderDevice(w http.ResponseWriter, page *devicePageData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	w.WriteHeader(http.StatusOK)
+	_ = devicePage.Execute(w, page)
+}
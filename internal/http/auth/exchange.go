@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"sso/internal/domain/models"
+	"sso/internal/services/auth"
+)
+
+// tokenExchange is the token exchange grant (RFC 8693), the client authenticates with its secret.
+func (h *handler) tokenExchange(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, basic := clientAuthentication(r)
+	if clientID == "" || clientSecret == "" {
+		writeOAuthError(w, http.StatusUnauthorized, errInvalidClient, "client authentication is required")
+		return
+	}
+
+	req := &models.TokenExchangeRequest{
+		SubjectToken:     r.PostForm.Get("subject_token"),
+		SubjectTokenType: r.PostForm.Get("subject_token_type"),
+		ActorToken:       r.PostForm.Get("actor_token"),
+		ActorTokenType:   r.PostForm.Get("actor_token_type"),
+		Audience:         r.PostForm.Get("audience"),
+		Scope:            r.PostForm.Get("scope"),
+	}
+	if req.SubjectToken == "" || req.SubjectTokenType == "" || req.Audience == "" {
+		writeOAuthError(w, http.StatusBadRequest, errInvalidRequest, "subject_token, subject_token_type and audience are required")
+		return
+	}
+	if req.ActorToken != "" && req.ActorTokenType == "" {
+		writeOAuthError(w, http.StatusBadRequest, errInvalidRequest, "actor_token_type is required")
+		return
+	}
+
+	pair, err := h.auth.ExchangeToken(r.Context(), clientID, clientSecret, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrInvalidClient):
+			writeInvalidClient(w, basic)
+		case errors.Is(err, auth.ErrInvalidTarget):
+			writeOAuthError(w, http.StatusBadRequest, errInvalidTarget, "")
+		case errors.Is(err, auth.ErrInvalidScope):
+			writeOAuthError(w, http.StatusBadRequest, errInvalidScope, "")
+		case errors.Is(err, auth.ErrUnsupportedTokenType):
+			writeOAuthError(w, http.StatusBadRequest, errInvalidRequest, "unsupported token type")
+		case errors.Is(err, auth.ErrImpersonationNotAllowed):
+			writeOAuthError(w, http.StatusBadRequest, errUnauthorizedClient, "impersonation is not allowed")
+		case errors.Is(err, auth.ErrInvalidGrant):
+			writeOAuthError(w, http.StatusBadRequest, errInvalidGrant, "")
+		default:
+			h.log.Error("failed to exchange token", slog.String("error", err.Error()))
+			writeOAuthError(w, http.StatusInternalServerError, errServerError, "")
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tokenResponse{
+		AccessToken:     pair.AccessToken,
+		TokenType:       "Bearer",
+		ExpiresIn:       int64(pair.ExpiresIn.Seconds()),
+		Scope:           pair.Scope,
+		IssuedTokenType: auth.TokenTypeAccessToken,
+	})
+}
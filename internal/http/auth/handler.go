@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwk"
+	"strings"
+)
+
+const (
+	jwksPath       = "/.well-known/jwks.json"
+	discoveryPath  = "/.well-known/openid-configuration"
+	authorizePath  = "/oauth/authorize"
+	tokenPath      = "/oauth/token"
+	userInfoPath   = "/oauth/userinfo"
+	introspectPath = "/oauth/introspect"
+
+	deviceAuthorizationPath = "/oauth/device_authorization"
+	deviceVerificationPath  = "/oauth/device"
+)
+
+type Auth interface {
+	JWKS(ctx context.Context) (*jwk.Set, error)
+	ValidateAuthorizationRequest(ctx context.Context, req *models.AuthorizationRequest) (*models.App, error)
+	Authorize(ctx context.Context, req *models.AuthorizationRequest, email, password, mfaCode string) (string, error)
+	ExchangeAuthorizationCode(
+		ctx context.Context,
+		code string,
+		clientID int,
+		redirectURI string,
+		codeVerifier string,
+	) (*models.TokenPair, error)
+	RefreshForClient(ctx context.Context, refreshToken string, clientID int) (*models.TokenPair, error)
+	UserInfo(ctx context.Context, accessToken string) (*models.UserInfo, error)
+	ClientCredentials(ctx context.Context, clientID, clientSecret, scope string) (*models.TokenPair, error)
+	StartDeviceAuthorization(ctx context.Context, clientID int, scope string) (*models.DeviceAuthorization, error)
+	VerifyDeviceCode(ctx context.Context, userCode, email, password, mfaCode string, approve bool) error
+	ExchangeDeviceCode(ctx context.Context, deviceCode string, clientID int) (*models.TokenPair, error)
+	IntrospectToken(ctx context.Context, clientID, clientSecret, accessToken string) (*models.TokenIntrospection, error)
+	ExchangeToken(ctx context.Context, clientID, clientSecret string, req *models.TokenExchangeRequest) (*models.TokenPair, error)
+}
+
+type handler struct {
+	log    *slog.Logger
+	auth   Auth
+	issuer string
+}
+
+// Register registers the handlers on the mux. issuer is the public base url
+// of the server, the OpenID Connect endpoints are advertised relative to it.
+func Register(mux *http.ServeMux, log *slog.Logger, auth Auth, issuer string) {
+	h := &handler{
+		log:    log,
+		auth:   auth,
+		issuer: strings.TrimSuffix(issuer, "/"),
+	}
+
+	mux.HandleFunc(jwksPath, h.jwks)
+	mux.HandleFunc(authorizePath, h.authorize)
+	mux.HandleFunc(tokenPath, h.token)
+	mux.HandleFunc(discoveryPath, h.discovery)
+	mux.HandleFunc(userInfoPath, h.userInfo)
+	mux.HandleFunc(introspectPath, h.introspect)
+	mux.HandleFunc(deviceAuthorizationPath, h.deviceAuthorization)
+	mux.HandleFunc(deviceVerificationPath, h.deviceVerification)
+}
+
+func (h *handler) jwks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	set, err := h.auth.JWKS(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeJSON(w, http.StatusOK, set)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, map[string]string{"error": msg})
+}
@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"sso/internal/services/auth"
+	"strconv"
+)
+
+type introspectionResponse struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	Audience  string `json:"aud,omitempty"`
+	Issuer    string `json:"iss,omitempty"`
+	JWTID     string `json:"jti,omitempty"`
+	Actor     *actor `json:"act,omitempty"`
+}
+
+// actor is the act claim (RFC 8693 section 4.1).
+type actor struct {
+	Subject string `json:"sub"`
+}
+
+// introspect is the token introspection endpoint (RFC 7662). The caller
+// authenticates as a confidential client, only access tokens are introspected.
+func (h *handler) introspect(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	if r.Method != http.MethodPost {
+		writeOAuthError(w, http.StatusMethodNotAllowed, errInvalidRequest, "method not allowed")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, errInvalidRequest, "malformed request")
+		return
+	}
+
+	clientID, clientSecret, basic := clientAuthentication(r)
+	if clientID == "" || clientSecret == "" {
+		writeOAuthError(w, http.StatusUnauthorized, errInvalidClient, "client authentication is required")
+		return
+	}
+
+	token := r.PostForm.Get("token")
+	if token == "" {
+		writeOAuthError(w, http.StatusBadRequest, errInvalidRequest, "token is required")
+		return
+	}
+
+	info, err := h.auth.IntrospectToken(r.Context(), clientID, clientSecret, token)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidClient) {
+			writeInvalidClient(w, basic)
+			return
+		}
+		h.log.Error("failed to introspect token", slog.String("error", err.Error()))
+		writeOAuthError(w, http.StatusInternalServerError, errServerError, "")
+		return
+	}
+
+	if !info.Active {
+		writeJSON(w, http.StatusOK, introspectionResponse{})
+		return
+	}
+
+	resp := introspectionResponse{
+		Active:    true,
+		Scope:     info.Scope,
+		ClientID:  info.ClientID,
+		Username:  info.Email,
+		TokenType: "Bearer",
+		ExpiresAt: info.ExpiresAt.Unix(),
+		Subject:   info.ClientID,
+		Audience:  strconv.FormatInt(info.AppID, 10),
+		Issuer:    h.issuer,
+		JWTID:     info.ID,
+	}
+	if info.Actor != "" {
+		resp.Actor = &actor{Subject: info.Actor}
+	}
+	if info.UserID != 0 {
+		resp.Subject = strconv.FormatInt(info.UserID, 10)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
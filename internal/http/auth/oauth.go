@@ -0,0 +1,395 @@
+package auth
+
+import (
+	"errors"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sso/internal/domain/models"
+	"sso/internal/services/auth"
+	"strconv"
+)
+
+const (
+	grantTypeAuthorizationCode = "authorization_code"
+	grantTypeRefreshToken      = "refresh_token"
+	grantTypeClientCredentials = "client_credentials"
+	grantTypeDeviceCode        = "urn:ietf:params:oauth:grant-type:device_code"
+	grantTypeTokenExchange     = "urn:ietf:params:oauth:grant-type:token-exchange"
+)
+
+// OAuth 2.0 error codes, RFC 6749 section 4.1.2.1 and 5.2, RFC 8628 section 3.5,
+// RFC 8693 section 2.2.2.
+const (
+	errInvalidRequest          = "invalid_request"
+	errInvalidClient           = "invalid_client"
+	errInvalidGrant            = "invalid_grant"
+	errInvalidScope            = "invalid_scope"
+	errUnsupportedGrantType    = "unsupported_grant_type"
+	errUnsupportedResponseType = "unsupported_response_type"
+	errAccessDenied            = "access_denied"
+	errServerError             = "server_error"
+	errAuthorizationPending    = "authorization_pending"
+	errSlowDown                = "slow_down"
+	errExpiredToken            = "expired_token"
+	errInvalidTarget           = "invalid_target"
+	errUnauthorizedClient      = "unauthorized_client"
+)
+
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Sign in to {{.AppName}}</title></head>
+<body>
+<h1>Sign in to {{.AppName}}</h1>
+{{if .Error}}<p role="alert">{{.Error}}</p>{{end}}
+<form method="post" action="` + authorizePath + `">
+<input type="hidden" name="response_type" value="{{.Request.ResponseType}}">
+<input type="hidden" name="client_id" value="{{.Request.ClientID}}">
+<input type="hidden" name="redirect_uri" value="{{.Request.RedirectURI}}">
+<input type="hidden" name="scope" value="{{.Request.Scope}}">
+<input type="hidden" name="state" value="{{.Request.State}}">
+<input type="hidden" name="nonce" value="{{.Request.Nonce}}">
+<input type="hidden" name="code_challenge" value="{{.Request.CodeChallenge}}">
+<input type="hidden" name="code_challenge_method" value="{{.Request.CodeChallengeMethod}}">
+<label>Email <input type="email" name="email" value="{{.Email}}" autocomplete="username" required></label>
+<label>Password <input type="password" name="password" autocomplete="current-password" required></label>
+{{if .MFARequired}}<label>Authentication code <input type="text" name="mfa_code" autocomplete="one-time-code" required></label>{{end}}
+<button type="submit">Sign in</button>
+</form>
+</body>
+</html>
+`))
+
+var errorPage = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Authorization error</title></head>
+<body><h1>Authorization error</h1><p>{{.}}</p></body>
+</html>
+`))
+
+type loginPageData struct {
+	AppName     string
+	Request     *models.AuthorizationRequest
+	Email       string
+	MFARequired bool
+	Error       string
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	// IssuedTokenType is set for token exchange responses.
+	IssuedTokenType string `json:"issued_token_type,omitempty"`
+}
+
+type oauthError struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// authorize is the authorization endpoint of the authorization code grant.
+// GET renders the login form, POST checks the credentials and redirects
+// back to the client with an authorization code.
+func (h *handler) authorize(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		renderError(w, http.StatusBadRequest, "malformed request")
+		return
+	}
+
+	clientID, err := strconv.Atoi(r.Form.Get("client_id"))
+	if err != nil {
+		renderError(w, http.StatusBadRequest, "client_id is required")
+		return
+	}
+	req := &models.AuthorizationRequest{
+		ResponseType:        r.Form.Get("response_type"),
+		ClientID:            clientID,
+		RedirectURI:         r.Form.Get("redirect_uri"),
+		Scope:               r.Form.Get("scope"),
+		State:               r.Form.Get("state"),
+		Nonce:               r.Form.Get("nonce"),
+		CodeChallenge:       r.Form.Get("code_challenge"),
+		CodeChallengeMethod: r.Form.Get("code_challenge_method"),
+	}
+
+	app, err := h.auth.ValidateAuthorizationRequest(r.Context(), req)
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrInvalidAppId):
+			renderError(w, http.StatusBadRequest, "unknown client")
+		case errors.Is(err, auth.ErrInvalidRedirectURI):
+			renderError(w, http.StatusBadRequest, "redirect_uri is not registered for the client")
+		case errors.Is(err, auth.ErrUnsupportedResponseType):
+			redirectError(w, r, req, errUnsupportedResponseType, "only the code response type is supported")
+		case errors.Is(err, auth.ErrInvalidPKCE):
+			redirectError(w, r, req, errInvalidRequest, "code_challenge with the S256 method is required")
+		default:
+			h.log.Error("failed to validate authorization request", slog.String("error", err.Error()))
+			redirectError(w, r, req, errServerError, "")
+		}
+		return
+	}
+
+	page := &loginPageData{
+		AppName: app.Name,
+		Request: req,
+	}
+	if r.Method == http.MethodGet {
+		renderLogin(w, http.StatusOK, page)
+		return
+	}
+
+	page.Email = r.PostForm.Get("email")
+	code, err := h.auth.Authorize(r.Context(), req, page.Email, r.PostForm.Get("password"), r.PostForm.Get("mfa_code"))
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrInvalidCredentials):
+			page.Error = "Invalid email or password"
+			renderLogin(w, http.StatusOK, page)
+		case errors.Is(err, auth.ErrMFARequired):
+			page.MFARequired = true
+			renderLogin(w, http.StatusOK, page)
+		case errors.Is(err, auth.ErrInvalidMFACode):
+			page.MFARequired = true
+			page.Error = "Invalid authentication code"
+			renderLogin(w, http.StatusOK, page)
+		case errors.Is(err, auth.ErrEmailNotVerified):
+			redirectError(w, r, req, errAccessDenied, "email not verified")
+		case errors.Is(err, auth.ErrIdentityConflict):
+			page.Error = "An account with your email exists, link it to the directory first"
+			renderLogin(w, http.StatusOK, page)
+		case errors.Is(err, auth.ErrPasswordExpired):
+			page.Error = "Your password expired, change it before signing in"
+			renderLogin(w, http.StatusOK, page)
+		case errors.Is(err, auth.ErrTooManyAttempts), errors.Is(err, auth.ErrAccountLocked):
+			page.Error = "Too many failed attempts, try again later"
+			renderLogin(w, http.StatusOK, page)
+		default:
+			h.log.Error("failed to authorize", slog.String("error", err.Error()))
+			redirectError(w, r, req, errServerError, "")
+		}
+		return
+	}
+
+	redirect(w, r, req, url.Values{"code": {code}})
+}
+
+// token is the token endpoint. For the authorization_code and refresh_token grants
+// clients are public and the code is bound to the client by PKCE, the
+// client_credentials grant is for confidential clients authenticated with a secret.
+func (h *handler) token(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method != http.MethodPost {
+		writeOAuthError(w, http.StatusMethodNotAllowed, errInvalidRequest, "method not allowed")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, errInvalidRequest, "malformed request")
+		return
+	}
+
+	// confidential clients authenticate with their secret instead of a numeric client_id
+	switch r.PostForm.Get("grant_type") {
+	case grantTypeClientCredentials:
+		h.clientCredentials(w, r)
+		return
+	case grantTypeTokenExchange:
+		h.tokenExchange(w, r)
+		return
+	}
+
+	clientID, err := strconv.Atoi(r.PostForm.Get("client_id"))
+	if err != nil {
+		writeOAuthError(w, http.StatusUnauthorized, errInvalidClient, "client_id is required")
+		return
+	}
+
+	var pair *models.TokenPair
+	switch grantType := r.PostForm.Get("grant_type"); grantType {
+	case grantTypeAuthorizationCode:
+		code := r.PostForm.Get("code")
+		redirectURI := r.PostForm.Get("redirect_uri")
+		verifier := r.PostForm.Get("code_verifier")
+		if code == "" || redirectURI == "" || verifier == "" {
+			writeOAuthError(w, http.StatusBadRequest, errInvalidRequest, "code, redirect_uri and code_verifier are required")
+			return
+		}
+		pair, err = h.auth.ExchangeAuthorizationCode(r.Context(), code, clientID, redirectURI, verifier)
+	case grantTypeRefreshToken:
+		refreshToken := r.PostForm.Get("refresh_token")
+		if refreshToken == "" {
+			writeOAuthError(w, http.StatusBadRequest, errInvalidRequest, "refresh_token is required")
+			return
+		}
+		pair, err = h.auth.RefreshForClient(r.Context(), refreshToken, clientID)
+	case grantTypeDeviceCode:
+		deviceCode := r.PostForm.Get("device_code")
+		if deviceCode == "" {
+			writeOAuthError(w, http.StatusBadRequest, errInvalidRequest, "device_code is required")
+			return
+		}
+		pair, err = h.auth.ExchangeDeviceCode(r.Context(), deviceCode, clientID)
+	case "":
+		writeOAuthError(w, http.StatusBadRequest, errInvalidRequest, "grant_type is required")
+		return
+	default:
+		writeOAuthError(w, http.StatusBadRequest, errUnsupportedGrantType, grantType)
+		return
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrInvalidGrant),
+			errors.Is(err, auth.ErrInvalidToken),
+			errors.Is(err, auth.ErrTokenReused):
+			writeOAuthError(w, http.StatusBadRequest, errInvalidGrant, "")
+		case errors.Is(err, auth.ErrPasswordExpired):
+			writeOAuthError(w, http.StatusBadRequest, errInvalidGrant, "password expired")
+		case errors.Is(err, auth.ErrAuthorizationPending):
+			writeOAuthError(w, http.StatusBadRequest, errAuthorizationPending, "")
+		case errors.Is(err, auth.ErrSlowDown):
+			writeOAuthError(w, http.StatusBadRequest, errSlowDown, "")
+		case errors.Is(err, auth.ErrAccessDenied):
+			writeOAuthError(w, http.StatusBadRequest, errAccessDenied, "")
+		case errors.Is(err, auth.ErrExpiredToken):
+			writeOAuthError(w, http.StatusBadRequest, errExpiredToken, "")
+		default:
+			h.log.Error("failed to issue tokens", slog.String("error", err.Error()))
+			writeOAuthError(w, http.StatusInternalServerError, errServerError, "")
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tokenResponse{
+		AccessToken:  pair.AccessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(pair.ExpiresIn.Seconds()),
+		RefreshToken: pair.RefreshToken,
+		IDToken:      pair.IDToken,
+	})
+}
+
+func (h *handler) clientCredentials(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, basic := clientAuthentication(r)
+	if clientID == "" || clientSecret == "" {
+		writeOAuthError(w, http.StatusUnauthorized, errInvalidClient, "client authentication is required")
+		return
+	}
+
+	pair, err := h.auth.ClientCredentials(r.Context(), clientID, clientSecret, r.PostForm.Get("scope"))
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrInvalidClient):
+			writeInvalidClient(w, basic)
+		case errors.Is(err, auth.ErrInvalidScope):
+			writeOAuthError(w, http.StatusBadRequest, errInvalidScope, "")
+		default:
+			h.log.Error("failed to issue client token", slog.String("error", err.Error()))
+			writeOAuthError(w, http.StatusInternalServerError, errServerError, "")
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tokenResponse{
+		AccessToken: pair.AccessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(pair.ExpiresIn.Seconds()),
+		Scope:       pair.Scope,
+	})
+}
+
+// clientAuthentication returns the credentials of a confidential client, sent either
+// in the Basic authorization header or in the form (client_secret_post).
+func clientAuthentication(r *http.Request) (clientID, clientSecret string, basic bool) {
+	clientID, clientSecret, basic = clientSecretBasic(r)
+	if !basic {
+		clientID = r.PostForm.Get("client_id")
+		clientSecret = r.PostForm.Get("client_secret")
+	}
+	return clientID, clientSecret, basic
+}
+
+// clientSecretBasic returns the client credentials of the HTTP Basic authorization header,
+// which are form-urlencoded before being base64 encoded (RFC 6749 section 2.3.1).
+func clientSecretBasic(r *http.Request) (clientID, clientSecret string, ok bool) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return "", "", false
+	}
+	clientID, err := url.QueryUnescape(username)
+	if err != nil {
+		return "", "", false
+	}
+	clientSecret, err = url.QueryUnescape(password)
+	if err != nil {
+		return "", "", false
+	}
+	return clientID, clientSecret, true
+}
+
+// redirect sends the user agent back to the validated redirect uri of the request.
+func redirect(w http.ResponseWriter, r *http.Request, req *models.AuthorizationRequest, params url.Values) {
+	u, err := url.Parse(req.RedirectURI)
+	if err != nil {
+		renderError(w, http.StatusBadRequest, "invalid redirect_uri")
+		return
+	}
+	q := u.Query()
+	for k, v := range params {
+		q[k] = v
+	}
+	if req.State != "" {
+		q.Set("state", req.State)
+	}
+	u.RawQuery = q.Encode()
+
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+func redirectError(w http.ResponseWriter, r *http.Request, req *models.AuthorizationRequest, code, description string) {
+	params := url.Values{"error": {code}}
+	if description != "" {
+		params.Set("error_description", description)
+	}
+	redirect(w, r, req, params)
+}
+
+func renderLogin(w http.ResponseWriter, code int, page *loginPageData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	// the login form must not be framed by other sites
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	w.WriteHeader(code)
+	_ = loginPage.Execute(w, page)
+}
+
+func renderError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	_ = errorPage.Execute(w, msg)
+}
+
+func writeInvalidClient(w http.ResponseWriter, basic bool) {
+	if basic {
+		w.Header().Set("WWW-Authenticate", `Basic realm="sso"`)
+	}
+	writeOAuthError(w, http.StatusUnauthorized, errInvalidClient, "")
+}
+
+func writeOAuthError(w http.ResponseWriter, code int, errCode, description string) {
+	writeJSON(w, code, oauthError{
+		Error:            errCode,
+		ErrorDescription: description,
+	})
+}
@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"sso/internal/lib/jwk"
+	"sso/internal/services/auth"
+	"strings"
+)
+
+type discoveryDocument struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	DeviceAuthorizationEndpoint       string   `json:"device_authorization_endpoint"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+type userInfoResponse struct {
+	Subject           string `json:"sub"`
+	Email             string `json:"email,omitempty"`
+	EmailVerified     *bool  `json:"email_verified,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+}
+
+// discovery serves the OpenID Connect discovery document.
+func (h *handler) discovery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	writeJSON(w, http.StatusOK, discoveryDocument{
+		Issuer:                      h.issuer,
+		AuthorizationEndpoint:       h.issuer + authorizePath,
+		TokenEndpoint:               h.issuer + tokenPath,
+		UserInfoEndpoint:            h.issuer + userInfoPath,
+		DeviceAuthorizationEndpoint: h.issuer + deviceAuthorizationPath,
+		IntrospectionEndpoint:       h.issuer + introspectPath,
+		JWKSURI:                     h.issuer + jwksPath,
+		ScopesSupported:             []string{"openid", "email", "profile"},
+		ResponseTypesSupported:      []string{"code"},
+		GrantTypesSupported: []string{
+			grantTypeAuthorizationCode,
+			grantTypeRefreshToken,
+			grantTypeClientCredentials,
+			grantTypeDeviceCode,
+			grantTypeTokenExchange,
+		},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{jwk.AlgRS256, jwk.AlgES256, jwk.AlgEdDSA, jwk.AlgHS256},
+		TokenEndpointAuthMethodsSupported: []string{"none", "client_secret_basic", "client_secret_post"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		ClaimsSupported: []string{
+			"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce",
+			"email", "email_verified", "preferred_username",
+		},
+	})
+}
+
+// userInfo is the OpenID Connect UserInfo endpoint, the access token
+// is passed as a bearer token (RFC 6750).
+func (h *handler) userInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	token, ok := bearerToken(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer`)
+		writeError(w, http.StatusUnauthorized, "missing bearer token")
+		return
+	}
+
+	info, err := h.auth.UserInfo(r.Context(), token)
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrInvalidAccessToken):
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			writeError(w, http.StatusUnauthorized, "invalid_token")
+		case errors.Is(err, auth.ErrInsufficientScope):
+			w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="openid"`)
+			writeError(w, http.StatusForbidden, "insufficient_scope")
+		default:
+			h.log.Error("failed to get user info", slog.String("error", err.Error()))
+			writeError(w, http.StatusInternalServerError, "internal error")
+		}
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, userInfoResponse{
+		Subject:           info.Subject,
+		Email:             info.Email,
+		EmailVerified:     info.EmailVerified,
+		PreferredUsername: info.PreferredUsername,
+	})
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	const prefix = "Bearer "
+
+	header := r.Header.Get("Authorization")
+	if len(header) <= len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", false
+	}
+	return header[len(prefix):], true
+}
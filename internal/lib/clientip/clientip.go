@@ -0,0 +1,30 @@
+// Package clientip carries the address of the client a request came from through the context,
+// so the services can tell clients apart whichever server received the request.
+package clientip
+
+import (
+	"context"
+	"net"
+)
+
+type ctxKey struct{}
+
+// NewContext returns a context carrying the host of addr, an address in the host:port form.
+func NewContext(ctx context.Context, addr string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, Host(addr))
+}
+
+// FromContext returns the client address, empty if the context carries none.
+func FromContext(ctx context.Context) string {
+	ip, _ := ctx.Value(ctxKey{}).(string)
+	return ip
+}
+
+// Host returns the host of the host:port address, or the address if it has no port.
+func Host(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
@@ -0,0 +1,172 @@
+// Package hibp looks passwords up in a local copy of the Pwned Passwords corpus.
+// The corpus is the single file of the downloader ordered by hash, one "SHA1:COUNT" line
+// per password with upper case hex hashes, so a password is found by binary search.
+package hibp
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	hashLen = sha1.Size * 2
+	// prefixLen hex digits of the hash select a shard of the index.
+	prefixLen = 3
+	shards    = 1 << (prefixLen * 4)
+	// window is the size of the ranges which are scanned instead of bisected,
+	// it has to be larger than the longest line.
+	window = 4096
+)
+
+var ErrInvalidCorpus = errors.New("invalid pwned passwords corpus")
+
+// Corpus is an opened corpus file. Its index holds the offset of every shard,
+// so a lookup only bisects the range of the shard of the hash.
+type Corpus struct {
+	f     *os.File
+	index [shards + 1]int64
+}
+
+// Open opens the corpus and indexes its shards.
+func Open(path string) (*Corpus, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	c := &Corpus{f: f}
+	c.index[shards] = info.Size()
+	for i := shards - 1; i >= 0; i-- {
+		prefix := fmt.Sprintf("%0*X", prefixLen, i)
+		c.index[i], err = c.lowerBound(prefix, 0, c.index[i+1])
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *Corpus) Close() error {
+	return c.f.Close()
+}
+
+// Count returns how often the password appears in the corpus, zero if it does not.
+func (c *Corpus) Count(password string) (int, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	shard, err := strconv.ParseUint(hash[:prefixLen], 16, 32)
+	if err != nil {
+		return 0, err
+	}
+	lo, hi := c.index[shard], c.index[shard+1]
+	off, err := c.lowerBound(hash, lo, hi)
+	if err != nil || off >= hi {
+		return 0, err
+	}
+
+	l, err := c.lineAt(off)
+	if err != nil || l.hash != hash {
+		return 0, err
+	}
+	return l.count, nil
+}
+
+type line struct {
+	start int64
+	next  int64
+	hash  string
+	count int
+}
+
+// lowerBound returns the offset of the first line in [lo, hi) whose hash is not less than
+// the target, or hi. Both lo and hi are offsets of line starts.
+func (c *Corpus) lowerBound(target string, lo, hi int64) (int64, error) {
+	for hi-lo > window {
+		// a line starts between mid and hi as the window is larger than a line
+		l, err := c.lineFrom(lo + (hi-lo)/2)
+		if err != nil {
+			return 0, err
+		}
+		if l.hash < target {
+			lo = l.next
+		} else {
+			hi = l.start
+		}
+	}
+
+	for lo < hi {
+		l, err := c.lineAt(lo)
+		if err != nil {
+			return 0, err
+		}
+		if l.hash >= target {
+			return lo, nil
+		}
+		lo = l.next
+	}
+	return hi, nil
+}
+
+// lineFrom returns the first line starting at or after the offset.
+func (c *Corpus) lineFrom(off int64) (*line, error) {
+	if off == 0 {
+		return c.lineAt(0)
+	}
+	buf, err := c.read(off-1, window)
+	if err != nil {
+		return nil, err
+	}
+	i := bytes.IndexByte(buf, '\n')
+	if i < 0 {
+		return nil, ErrInvalidCorpus
+	}
+	return c.lineAt(off + int64(i))
+}
+
+// lineAt parses the line starting at the offset.
+func (c *Corpus) lineAt(off int64) (*line, error) {
+	buf, err := c.read(off, window)
+	if err != nil {
+		return nil, err
+	}
+	end := bytes.IndexByte(buf, '\n')
+	next := off + int64(end) + 1
+	if end < 0 {
+		// the last line may lack a newline
+		end = len(buf)
+		next = off + int64(end)
+	}
+
+	hash, count, ok := strings.Cut(strings.TrimSpace(string(buf[:end])), ":")
+	if !ok || len(hash) != hashLen {
+		return nil, ErrInvalidCorpus
+	}
+	n, err := strconv.Atoi(count)
+	if err != nil {
+		return nil, ErrInvalidCorpus
+	}
+	return &line{start: off, next: next, hash: strings.ToUpper(hash), count: n}, nil
+}
+
+func (c *Corpus) read(off int64, n int) ([]byte, error) {
+	buf := make([]byte, n)
+	read, err := c.f.ReadAt(buf, off)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return buf[:read], nil
+}
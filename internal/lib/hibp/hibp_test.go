@@ -0,0 +1,174 @@
+package hibp
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// shardLines is how many lines the populated shards get, they span several windows.
+const shardLines = 300
+
+func hashOf(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// passwordInShard returns the first generated password whose hash starts with the prefix.
+func passwordInShard(prefix string, skip string) string {
+	for i := 0; ; i++ {
+		password := fmt.Sprintf("password-%s-%d", prefix, i)
+		if password != skip && strings.HasPrefix(hashOf(password), prefix) {
+			return password
+		}
+	}
+}
+
+// fillerHash returns a random hash in the shard of the prefix.
+func fillerHash(rnd *rand.Rand, prefix string) string {
+	const digits = "0123456789ABCDEF"
+	var b strings.Builder
+	b.WriteString(prefix)
+	for b.Len() < hashLen {
+		b.WriteByte(digits[rnd.Intn(len(digits))])
+	}
+	return b.String()
+}
+
+// writeCorpus writes the counts of the hashes ordered by hash, the last line without
+// a newline if trailingNewline is false.
+func writeCorpus(t *testing.T, counts map[string]int, newline string, trailingNewline bool) string {
+	t.Helper()
+
+	hashes := make([]string, 0, len(counts))
+	for hash := range counts {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+
+	var b strings.Builder
+	for i, hash := range hashes {
+		fmt.Fprintf(&b, "%s:%d", hash, counts[hash])
+		if i < len(hashes)-1 || trailingNewline {
+			b.WriteString(newline)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "pwned-passwords.txt")
+	require.NoError(t, os.WriteFile(path, []byte(b.String()), 0o600))
+	return path
+}
+
+func TestCorpus_Count(t *testing.T) {
+	first := passwordInShard("000", "")
+	last := passwordInShard("FFF", "")
+	middle := "correct horse battery staple"
+	missingFirst := passwordInShard("000", first)
+	missingLast := passwordInShard("FFF", last)
+	missingMiddle := passwordInShard(hashOf(middle)[:prefixLen], middle)
+
+	// the shards of the passwords are populated densely, the first and the last password
+	// are the first and the last line of the corpus
+	rnd := rand.New(rand.NewSource(1))
+	counts := map[string]int{
+		hashOf(first):  1,
+		hashOf(last):   42,
+		hashOf(middle): 3861493,
+	}
+	for _, prefix := range []string{"000", "FFF", hashOf(middle)[:prefixLen]} {
+		for i := 0; i < shardLines; i++ {
+			hash := fillerHash(rnd, prefix)
+			if hash > hashOf(first) && hash < hashOf(last) && hash != hashOf(missingFirst) &&
+				hash != hashOf(missingLast) && hash != hashOf(missingMiddle) {
+				counts[hash] = rnd.Intn(1000) + 1
+			}
+		}
+	}
+	for i := 0; i < 2000; i++ {
+		hash := fillerHash(rnd, fmt.Sprintf("%0*X", prefixLen, rnd.Intn(shards-2)+1))
+		counts[hash] = rnd.Intn(1000) + 1
+	}
+
+	corpora := []struct {
+		name            string
+		newline         string
+		trailingNewline bool
+	}{
+		{name: "LF", newline: "\n", trailingNewline: true},
+		{name: "CRLF", newline: "\r\n", trailingNewline: true},
+		{name: "No trailing newline", newline: "\n", trailingNewline: false},
+		{name: "CRLF without trailing newline", newline: "\r\n", trailingNewline: false},
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		expected int
+	}{
+		{name: "First shard", password: first, expected: 1},
+		{name: "Last shard", password: last, expected: 42},
+		{name: "Middle shard", password: middle, expected: 3861493},
+		{name: "Missing in the first shard", password: missingFirst, expected: 0},
+		{name: "Missing in the last shard", password: missingLast, expected: 0},
+		{name: "Missing in the middle shard", password: missingMiddle, expected: 0},
+	}
+
+	for _, corpus := range corpora {
+		t.Run(corpus.name, func(t *testing.T) {
+			path := writeCorpus(t, counts, corpus.newline, corpus.trailingNewline)
+			info, err := os.Stat(path)
+			require.NoError(t, err)
+			require.Greater(t, info.Size(), int64(10*window))
+
+			c, err := Open(path)
+			require.NoError(t, err)
+			t.Cleanup(func() { c.Close() })
+
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					count, err := c.Count(tt.password)
+					require.NoError(t, err)
+					require.Equal(t, tt.expected, count)
+				})
+			}
+
+			// every line of the corpus is found in its shard, not only the ones of known passwords
+			for hash, expected := range counts {
+				shard, err := strconv.ParseUint(hash[:prefixLen], 16, 32)
+				require.NoError(t, err)
+				off, err := c.lowerBound(hash, c.index[shard], c.index[shard+1])
+				require.NoError(t, err)
+				l, err := c.lineAt(off)
+				require.NoError(t, err)
+				require.Equal(t, hash, l.hash)
+				require.Equal(t, expected, l.count)
+			}
+		})
+	}
+}
+
+func TestCorpus_Empty(t *testing.T) {
+	c, err := Open(writeCorpus(t, nil, "\n", true))
+	require.NoError(t, err)
+	defer c.Close()
+
+	count, err := c.Count("password")
+	require.NoError(t, err)
+	require.Zero(t, count)
+}
+
+func TestOpen_InvalidCorpus(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pwned-passwords.txt")
+	require.NoError(t, os.WriteFile(path, []byte(strings.Repeat("not a hash\n", 1000)), 0o600))
+
+	_, err := Open(path)
+	require.ErrorIs(t, err, ErrInvalidCorpus)
+}
@@ -1,24 +1,265 @@
 package jwt
 
 import (
+	"errors"
+	"fmt"
 	"github.com/golang-jwt/jwt/v5"
 	"sso/internal/domain/models"
+	"sso/internal/lib/jwk"
+	"sso/internal/lib/opaque"
+	"strconv"
 	"time"
 )
 
-func NewToken(user *models.User, app *models.App, duration time.Duration) (string, error) {
-	token := jwt.New(jwt.SigningMethodHS256)
+var (
+	ErrInvalidToken = errors.New("invalid token")
+)
+
+// Claims are the claims of an access token issued by NewToken or NewClientToken.
+type Claims struct {
+	ID    string
+	UID   int64
+	Email string
+	// ClientID is set instead of UID and Email for tokens issued to a client on its own behalf.
+	ClientID string
+	AppID    int64
+	// Scope is the space separated OAuth scope the token was granted, empty for Login tokens.
+	Scope string
+	// Actor is the subject of the act claim of a token issued by a token exchange.
+	Actor string
+	// IssuedAt is zero for tokens issued without the iat claim.
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// IDTokenParams are the OpenID Connect specific values of an ID token.
+type IDTokenParams struct {
+	Issuer   string
+	Nonce    string
+	AuthTime time.Time
+	// Email adds the email claims, it is set when the email scope was granted.
+	Email bool
+}
+
+// KeyProvider returns the algorithm and the verification key for a token.
+// kid is empty for tokens signed with the app secret.
+type KeyProvider func(appID int64, kid string) (alg string, key interface{}, err error)
+
+// NewToken issues an access token. If key is nil the token is signed
+// with HS256 using the app secret, otherwise with the given signing key.
+// roles are the roles of the user in the app, the roles claim is omitted if there are none.
+func NewToken(
+	user *models.User,
+	app *models.App,
+	key *models.SigningKey,
+	scope string,
+	roles []string,
+	duration time.Duration,
+) (string, error) {
+	token, _, err := newUserToken(user, app, key, scope, roles, "", duration)
+	return token, err
+}
+
+// NewDelegatedToken issues an access token of the user with an act claim identifying
+// the actor which acts on behalf of the user (RFC 8693 section 4.1).
+// The token id is returned as well, so the exchange can be audited.
+func NewDelegatedToken(
+	user *models.User,
+	app *models.App,
+	key *models.SigningKey,
+	scope string,
+	actor string,
+	duration time.Duration,
+) (token string, jti string, err error) {
+	return newUserToken(user, app, key, scope, nil, actor, duration)
+}
+
+// NewClientToken issues an access token to a confidential client acting on its own behalf.
+// The client id is the subject of the token, it is signed the same way as NewToken.
+func NewClientToken(client *models.Client, app *models.App, key *models.SigningKey, scope string, duration time.Duration) (string, error) {
+	jti, err := opaque.NewID()
+	if err != nil {
+		return "", err
+	}
+
+	method, signKey, err := signingParams(app, key)
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.New(method)
+	if key != nil {
+		token.Header["kid"] = key.Kid
+	}
 
 	claims := token.Claims.(jwt.MapClaims)
+	claims["jti"] = jti
+	claims["sub"] = client.ClientID
+	claims["client_id"] = client.ClientID
+	now := time.Now()
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(duration).Unix()
+	claims["app_id"] = app.ID
+	if scope != "" {
+		claims["scope"] = scope
+	}
+
+	return token.SignedString(signKey)
+}
+
+// NewIDToken issues an OpenID Connect ID token for the app, it is signed the same way as NewToken.
+func NewIDToken(
+	user *models.User,
+	app *models.App,
+	key *models.SigningKey,
+	params IDTokenParams,
+	duration time.Duration,
+) (string, error) {
+	method, signKey, err := signingParams(app, key)
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.New(method)
+	if key != nil {
+		token.Header["kid"] = key.Kid
+	}
+
+	now := time.Now()
+	claims := token.Claims.(jwt.MapClaims)
+	claims["iss"] = params.Issuer
+	claims["sub"] = strconv.FormatInt(user.ID, 10)
+	claims["aud"] = strconv.FormatInt(app.ID, 10)
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(duration).Unix()
+	claims["auth_time"] = params.AuthTime.Unix()
+	if params.Nonce != "" {
+		claims["nonce"] = params.Nonce
+	}
+	if params.Email {
+		claims["email"] = user.Email
+		claims["email_verified"] = user.EmailVerified
+	}
+
+	return token.SignedString(signKey)
+}
+
+func newUserToken(
+	user *models.User,
+	app *models.App,
+	key *models.SigningKey,
+	scope string,
+	roles []string,
+	actor string,
+	duration time.Duration,
+) (string, string, error) {
+	jti, err := opaque.NewID()
+	if err != nil {
+		return "", "", err
+	}
+
+	method, signKey, err := signingParams(app, key)
+	if err != nil {
+		return "", "", err
+	}
+
+	token := jwt.New(method)
+	if key != nil {
+		token.Header["kid"] = key.Kid
+	}
+
+	claims := token.Claims.(jwt.MapClaims)
+	claims["jti"] = jti
 	claims["uid"] = user.ID
 	claims["email"] = user.Email
-	claims["exp"] = time.Now().Add(duration).Unix()
+	now := time.Now()
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(duration).Unix()
 	claims["app_id"] = app.ID
+	if scope != "" {
+		claims["scope"] = scope
+	}
+	if len(roles) > 0 {
+		claims["roles"] = roles
+	}
+	if actor != "" {
+		claims["act"] = map[string]interface{}{"sub": actor}
+	}
+
+	tokenString, err := token.SignedString(signKey)
+	if err != nil {
+		return "", "", err
+	}
+	return tokenString, jti, nil
+}
 
-	tokenString, err := token.SignedString([]byte(app.Secret))
+// Parse verifies the token signature and expiry and returns its claims.
+func Parse(tokenString string, keys KeyProvider) (*Claims, error) {
+	mapClaims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, mapClaims, func(t *jwt.Token) (interface{}, error) {
+		appID, ok := mapClaims["app_id"].(float64)
+		if !ok {
+			return nil, fmt.Errorf("app_id claim is missing")
+		}
+		kid, _ := t.Header["kid"].(string)
+		alg, key, err := keys(int64(appID), kid)
+		if err != nil {
+			return nil, err
+		}
+		// the algorithm is bound to the key, never trust the header alone
+		if t.Method.Alg() != alg {
+			return nil, fmt.Errorf("unexpected signing method %s", t.Method.Alg())
+		}
+		return key, nil
+	},
+		jwt.WithValidMethods([]string{jwk.AlgHS256, jwk.AlgRS256, jwk.AlgES256, jwk.AlgEdDSA}),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
 
+	claims := &Claims{}
+	claims.ID, _ = mapClaims["jti"].(string)
+	claims.Email, _ = mapClaims["email"].(string)
+	claims.Scope, _ = mapClaims["scope"].(string)
+	claims.ClientID, _ = mapClaims["client_id"].(string)
+	if uid, ok := mapClaims["uid"].(float64); ok {
+		claims.UID = int64(uid)
+	}
+	if appID, ok := mapClaims["app_id"].(float64); ok {
+		claims.AppID = int64(appID)
+	}
+	if act, ok := mapClaims["act"].(map[string]interface{}); ok {
+		claims.Actor, _ = act["sub"].(string)
+	}
+	exp, err := mapClaims.GetExpirationTime()
 	if err != nil {
-		return "", err
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+	claims.ExpiresAt = exp.Time
+	if iat, err := mapClaims.GetIssuedAt(); err == nil && iat != nil {
+		claims.IssuedAt = iat.Time
+	}
+	if claims.ID == "" {
+		return nil, fmt.Errorf("%w: jti claim is missing", ErrInvalidToken)
+	}
+
+	return claims, nil
+}
+
+func signingParams(app *models.App, key *models.SigningKey) (jwt.SigningMethod, interface{}, error) {
+	if key == nil {
+		return jwt.SigningMethodHS256, []byte(app.Secret), nil
+	}
+
+	method := jwt.GetSigningMethod(key.Algorithm)
+	if method == nil || !jwk.IsAsymmetric(key.Algorithm) {
+		return nil, nil, fmt.Errorf("%w: %s", jwk.ErrUnsupportedAlg, key.Algorithm)
+	}
+	signer, err := jwk.ParsePrivateKey(key.PrivateKey)
+	if err != nil {
+		return nil, nil, err
 	}
-	return tokenString, nil
+	return method, signer, nil
 }
@@ -0,0 +1,128 @@
+// Package ldap authenticates users against an LDAP or Active Directory server.
+package ldap
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/go-ldap/ldap/v3"
+	"net"
+	"net/url"
+	"sso/internal/domain/models"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUserNotFound       = errors.New("user not found in the directory")
+	ErrInvalidCredentials = errors.New("invalid directory credentials")
+)
+
+// Client binds to directories, a connection is opened for every authentication.
+type Client struct {
+	timeout time.Duration
+}
+
+func New(timeout time.Duration) *Client {
+	return &Client{timeout: timeout}
+}
+
+// Authenticate searches the directory for the single entry matching the login with the
+// service account and verifies the password by binding as that entry.
+func (c *Client) Authenticate(
+	ctx context.Context,
+	dir *models.LDAPDirectory,
+	login string,
+	password string,
+) (*models.DirectoryEntry, error) {
+	// a simple bind without a password is an anonymous bind, which always succeeds
+	if login == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := c.dial(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// the operations of the library are not cancellable, closing the connection aborts them
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	if err := conn.Bind(dir.BindDN, dir.BindPassword); err != nil {
+		return nil, fmt.Errorf("service account bind: %w", err)
+	}
+
+	res, err := conn.Search(ldap.NewSearchRequest(
+		dir.BaseDN,
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		2,
+		int(c.timeout.Seconds()),
+		false,
+		strings.ReplaceAll(dir.UserFilter, "%s", ldap.EscapeFilter(login)),
+		[]string{dir.EmailAttribute, dir.GroupAttribute},
+		nil,
+	))
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+			return nil, fmt.Errorf("login %q matches several entries", login)
+		}
+		return nil, fmt.Errorf("search: %w", err)
+	}
+	switch len(res.Entries) {
+	case 0:
+		return nil, ErrUserNotFound
+	case 1:
+	default:
+		return nil, fmt.Errorf("login %q matches several entries", login)
+	}
+	entry := res.Entries[0]
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("user bind: %w", err)
+	}
+
+	return &models.DirectoryEntry{
+		DN:     entry.DN,
+		Email:  entry.GetEqualFoldAttributeValue(dir.EmailAttribute),
+		Groups: entry.GetEqualFoldAttributeValues(dir.GroupAttribute),
+	}, nil
+}
+
+func (c *Client) dial(ctx context.Context, dir *models.LDAPDirectory) (*ldap.Conn, error) {
+	u, err := url.Parse(dir.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid directory url: %w", err)
+	}
+
+	dialer := &net.Dialer{Timeout: c.timeout}
+	if deadline, ok := ctx.Deadline(); ok {
+		dialer.Deadline = deadline
+	}
+	conn, err := ldap.DialURL(dir.URL, ldap.DialWithDialer(dialer))
+	if err != nil {
+		return nil, fmt.Errorf("dial: %w", err)
+	}
+	conn.SetTimeout(c.timeout)
+
+	if dir.StartTLS {
+		if err := conn.StartTLS(&tls.Config{ServerName: u.Hostname()}); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("start tls: %w", err)
+		}
+	}
+	return conn, nil
+}
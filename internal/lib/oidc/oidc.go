@@ -0,0 +1,198 @@
+// Package oidc is a minimal OpenID Connect relying party for the authorization code flow.
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"io"
+	"net/http"
+	"net/url"
+	"sso/internal/lib/jwk"
+	"sso/internal/lib/pkce"
+	"strings"
+)
+
+// maxResponseSize limits the documents read from a provider.
+const maxResponseSize = 1 << 20
+
+var (
+	ErrInvalidIDToken = errors.New("invalid id token")
+	ErrProvider       = errors.New("provider error")
+)
+
+// Provider is the discovered metadata of an OpenID provider.
+type Provider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Client is registered at the provider, its secret authenticates at the token endpoint.
+type Client struct {
+	ID     string
+	Secret string
+}
+
+// Claims are the claims of a verified ID token.
+type Claims struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+type tokenResponse struct {
+	IDToken string `json:"id_token"`
+}
+
+type errorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Discover fetches the metadata of the provider with the issuer identifier.
+func Discover(ctx context.Context, client *http.Client, issuer string) (*Provider, error) {
+	u := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := new(Provider)
+	if err := do(client, req, provider); err != nil {
+		return nil, err
+	}
+	// the issuer must match exactly, otherwise tokens of another issuer would be accepted
+	if provider.Issuer != issuer {
+		return nil, fmt.Errorf("%w: issuer %q does not match %q", ErrProvider, provider.Issuer, issuer)
+	}
+	if provider.AuthorizationEndpoint == "" || provider.TokenEndpoint == "" || provider.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: incomplete metadata", ErrProvider)
+	}
+	return provider, nil
+}
+
+// AuthCodeURL returns the authorization endpoint url the user is sent to.
+// The code challenge is derived from codeVerifier with the S256 method.
+func (p *Provider) AuthCodeURL(client Client, redirectURI, scope, state, nonce, codeVerifier string) string {
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {client.ID},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {scope},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {pkce.Challenge(codeVerifier)},
+		"code_challenge_method": {pkce.MethodS256},
+	}
+
+	sep := "?"
+	if strings.Contains(p.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return p.AuthorizationEndpoint + sep + params.Encode()
+}
+
+// Exchange redeems the authorization code at the token endpoint and returns the raw ID token.
+func (p *Provider) Exchange(ctx context.Context, httpClient *http.Client, client Client, code, redirectURI, codeVerifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {codeVerifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(client.ID), url.QueryEscape(client.Secret))
+
+	var resp tokenResponse
+	if err := do(httpClient, req, &resp); err != nil {
+		return "", err
+	}
+	if resp.IDToken == "" {
+		return "", fmt.Errorf("%w: no id token in the token response", ErrProvider)
+	}
+	return resp.IDToken, nil
+}
+
+// VerifyIDToken verifies the signature of the ID token with the provider key set
+// and its iss, aud, exp and nonce claims.
+func (p *Provider) VerifyIDToken(ctx context.Context, httpClient *http.Client, client Client, rawIDToken, nonce string) (*Claims, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	var set jwk.Set
+	if err := do(httpClient, req, &set); err != nil {
+		return nil, err
+	}
+
+	mapClaims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(rawIDToken, mapClaims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		for _, key := range set.Keys {
+			if key.Kid != kid || (key.Use != "" && key.Use != "sig") {
+				continue
+			}
+			// the algorithm is bound to the key, never trust the header alone
+			if key.Alg != "" && key.Alg != t.Method.Alg() {
+				return nil, fmt.Errorf("unexpected signing method %s", t.Method.Alg())
+			}
+			return key.PublicKey()
+		}
+		return nil, fmt.Errorf("unknown key %q", kid)
+	},
+		jwt.WithValidMethods([]string{jwk.AlgRS256, jwk.AlgES256, jwk.AlgEdDSA}),
+		jwt.WithIssuer(p.Issuer),
+		jwt.WithAudience(client.ID),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidIDToken, err)
+	}
+
+	if got, _ := mapClaims["nonce"].(string); got != nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	}
+
+	claims := &Claims{}
+	claims.Subject, _ = mapClaims["sub"].(string)
+	claims.Email, _ = mapClaims["email"].(string)
+	claims.EmailVerified, _ = mapClaims["email_verified"].(bool)
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: sub claim is missing", ErrInvalidIDToken)
+	}
+	return claims, nil
+}
+
+func do(client *http.Client, req *http.Request, v interface{}) error {
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrProvider, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrProvider, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e errorResponse
+		if json.Unmarshal(body, &e) == nil && e.Error != "" {
+			return fmt.Errorf("%w: %s: %s %s", ErrProvider, req.URL.Path, e.Error, e.ErrorDescription)
+		}
+		return fmt.Errorf("%w: %s: status %d", ErrProvider, req.URL.Path, resp.StatusCode)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("%w: %w", ErrProvider, err)
+	}
+	return nil
+}
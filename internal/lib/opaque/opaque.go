@@ -0,0 +1,39 @@
+package opaque
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+const (
+	defaultSize = 32
+	idSize      = 16
+)
+
+// New generates a random url-safe token and returns it with its hash.
+// Only the hash should ever be persisted.
+func New() (token string, hash string, err error) {
+	b := make([]byte, defaultSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, Hash(token), nil
+}
+
+// NewID generates a random hex encoded identifier.
+func NewID() (string, error) {
+	b := make([]byte, idSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Hash returns hex encoded sha256 of the token.
+func Hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
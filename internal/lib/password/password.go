@@ -0,0 +1,293 @@
+// Package password hashes passwords with bcrypt, argon2id or scrypt.
+// Argon2id and scrypt hashes are PHC strings, bcrypt hashes keep their modular crypt format,
+// so every hash names its algorithm and parameters and can be verified on its own.
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+	"math/bits"
+	"strings"
+)
+
+const (
+	AlgorithmBcrypt   = "bcrypt"
+	AlgorithmArgon2id = "argon2id"
+	AlgorithmScrypt   = "scrypt"
+
+	saltLen = 16
+	keyLen  = 32
+	// maxScryptLogN, maxScryptR and maxScryptP bound the work of verifying a scrypt hash.
+	maxScryptLogN = 24
+	maxScryptR    = 32
+	maxScryptP    = 16
+	// maxArgon2Memory, maxArgon2Iterations and maxArgon2Parallelism bound the work of verifying
+	// an argon2id hash, the memory is in KiB.
+	maxArgon2Memory      = 1 << 20
+	maxArgon2Iterations  = 16
+	maxArgon2Parallelism = 16
+)
+
+var ErrUnknownFormat = errors.New("unknown password hash format")
+
+var b64 = base64.RawStdEncoding
+
+// Algorithm hashes passwords with its parameters.
+type Algorithm interface {
+	Hash(password []byte) (string, error)
+	// Current reports whether the hash was made by the algorithm with its parameters.
+	Current(hash string) bool
+}
+
+// Hasher hashes new passwords with its algorithm and verifies hashes of every algorithm.
+type Hasher struct {
+	alg Algorithm
+}
+
+func New(alg Algorithm) *Hasher {
+	return &Hasher{alg: alg}
+}
+
+func (h *Hasher) Hash(password []byte) (string, error) {
+	return h.alg.Hash(password)
+}
+
+// Verify reports whether the password matches the hash, whichever algorithm made it.
+func (h *Hasher) Verify(hash string, password []byte) (bool, error) {
+	switch {
+	case isBcrypt(hash):
+		err := bcrypt.CompareHashAndPassword([]byte(hash), password)
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return err == nil, err
+	case strings.HasPrefix(hash, "$"+AlgorithmArgon2id+"$"):
+		return verifyArgon2id(hash, password)
+	case strings.HasPrefix(hash, "$"+AlgorithmScrypt+"$"):
+		return verifyScrypt(hash, password)
+	}
+	return false, ErrUnknownFormat
+}
+
+// NeedsRehash reports whether the hash was made by another algorithm or with other parameters.
+func (h *Hasher) NeedsRehash(hash string) bool {
+	return !h.alg.Current(hash)
+}
+
+type Bcrypt struct {
+	Cost int
+}
+
+func (b Bcrypt) Hash(password []byte) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword(password, b.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (b Bcrypt) Current(hash string) bool {
+	if !isBcrypt(hash) {
+		return false
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err == nil && cost == b.Cost
+}
+
+func isBcrypt(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+// Argon2id hashes with argon2id, Memory is in KiB.
+type Argon2id struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+}
+
+type argon2Hash struct {
+	Argon2id
+	version int
+	salt    []byte
+	key     []byte
+}
+
+func (a Argon2id) Hash(password []byte) (string, error) {
+	salt, err := newSalt()
+	if err != nil {
+		return "", err
+	}
+	key := argon2.IDKey(password, salt, a.Iterations, a.Memory, a.Parallelism, keyLen)
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		AlgorithmArgon2id,
+		argon2.Version,
+		a.Memory,
+		a.Iterations,
+		a.Parallelism,
+		b64.EncodeToString(salt),
+		b64.EncodeToString(key),
+	), nil
+}
+
+// Validate rejects parameters which are out of the bounds of verification,
+// the hashes made with them could not be verified.
+func (a Argon2id) Validate() error {
+	if a.Memory == 0 || a.Memory > maxArgon2Memory {
+		return fmt.Errorf("argon2 memory must be in (0, %d] KiB: %d", maxArgon2Memory, a.Memory)
+	}
+	if a.Iterations == 0 || a.Iterations > maxArgon2Iterations {
+		return fmt.Errorf("argon2 iterations must be in (0, %d]: %d", maxArgon2Iterations, a.Iterations)
+	}
+	if a.Parallelism == 0 || a.Parallelism > maxArgon2Parallelism {
+		return fmt.Errorf("argon2 parallelism must be in (0, %d]: %d", maxArgon2Parallelism, a.Parallelism)
+	}
+	return nil
+}
+
+func (a Argon2id) Current(hash string) bool {
+	h, err := parseArgon2id(hash)
+	return err == nil && h.version == argon2.Version && h.Argon2id == a && len(h.key) == keyLen
+}
+
+func verifyArgon2id(hash string, password []byte) (bool, error) {
+	h, err := parseArgon2id(hash)
+	if err != nil {
+		return false, err
+	}
+	if h.version != argon2.Version {
+		return false, fmt.Errorf("unsupported argon2 version %d", h.version)
+	}
+	key := argon2.IDKey(password, h.salt, h.Iterations, h.Memory, h.Parallelism, uint32(len(h.key)))
+	return subtle.ConstantTimeCompare(key, h.key) == 1, nil
+}
+
+// parseArgon2id parses $argon2id$v=19$m=19456,t=2,p=1$salt$key.
+func parseArgon2id(hash string) (*argon2Hash, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != AlgorithmArgon2id {
+		return nil, ErrUnknownFormat
+	}
+
+	h := new(argon2Hash)
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &h.version); err != nil {
+		return nil, ErrUnknownFormat
+	}
+	_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.Memory, &h.Iterations, &h.Parallelism)
+	if err != nil || h.Iterations == 0 || h.Parallelism == 0 {
+		return nil, ErrUnknownFormat
+	}
+	if h.Memory > maxArgon2Memory || h.Iterations > maxArgon2Iterations || h.Parallelism > maxArgon2Parallelism {
+		return nil, ErrUnknownFormat
+	}
+	if h.salt, err = b64.DecodeString(parts[4]); err != nil {
+		return nil, ErrUnknownFormat
+	}
+	if h.key, err = b64.DecodeString(parts[5]); err != nil || len(h.key) == 0 {
+		return nil, ErrUnknownFormat
+	}
+	return h, nil
+}
+
+// Scrypt hashes with scrypt, N is the CPU/memory cost and must be a power of two.
+type Scrypt struct {
+	N int
+	R int
+	P int
+}
+
+type scryptHash struct {
+	Scrypt
+	salt []byte
+	key  []byte
+}
+
+func (s Scrypt) Hash(password []byte) (string, error) {
+	salt, err := newSalt()
+	if err != nil {
+		return "", err
+	}
+	key, err := scrypt.Key(password, salt, s.N, s.R, s.P, keyLen)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("$%s$ln=%d,r=%d,p=%d$%s$%s",
+		AlgorithmScrypt,
+		bits.TrailingZeros(uint(s.N)),
+		s.R,
+		s.P,
+		b64.EncodeToString(salt),
+		b64.EncodeToString(key),
+	), nil
+}
+
+// Validate rejects parameters which are out of the bounds of verification,
+// the hashes made with them could not be verified.
+func (s Scrypt) Validate() error {
+	if s.N < 2 || s.N&(s.N-1) != 0 || s.N > 1<<maxScryptLogN {
+		return fmt.Errorf("scrypt n must be a power of two in [2, %d]: %d", 1<<maxScryptLogN, s.N)
+	}
+	if s.R <= 0 || s.R > maxScryptR {
+		return fmt.Errorf("scrypt r must be in (0, %d]: %d", maxScryptR, s.R)
+	}
+	if s.P <= 0 || s.P > maxScryptP {
+		return fmt.Errorf("scrypt p must be in (0, %d]: %d", maxScryptP, s.P)
+	}
+	return nil
+}
+
+func (s Scrypt) Current(hash string) bool {
+	h, err := parseScrypt(hash)
+	return err == nil && h.Scrypt == s && len(h.key) == keyLen
+}
+
+func verifyScrypt(hash string, password []byte) (bool, error) {
+	h, err := parseScrypt(hash)
+	if err != nil {
+		return false, err
+	}
+	key, err := scrypt.Key(password, h.salt, h.N, h.R, h.P, len(h.key))
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(key, h.key) == 1, nil
+}
+
+// parseScrypt parses $scrypt$ln=15,r=8,p=1$salt$key, N is 2^ln.
+func parseScrypt(hash string) (*scryptHash, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 5 || parts[1] != AlgorithmScrypt {
+		return nil, ErrUnknownFormat
+	}
+
+	h := new(scryptHash)
+	var logN int
+	_, err := fmt.Sscanf(parts[2], "ln=%d,r=%d,p=%d", &logN, &h.R, &h.P)
+	if err != nil || logN < 1 || logN > maxScryptLogN {
+		return nil, ErrUnknownFormat
+	}
+	if h.R <= 0 || h.R > maxScryptR || h.P <= 0 || h.P > maxScryptP {
+		return nil, ErrUnknownFormat
+	}
+	h.N = 1 << logN
+	if h.salt, err = b64.DecodeString(parts[3]); err != nil {
+		return nil, ErrUnknownFormat
+	}
+	if h.key, err = b64.DecodeString(parts[4]); err != nil || len(h.key) == 0 {
+		return nil, ErrUnknownFormat
+	}
+	return h, nil
+}
+
+func newSalt() ([]byte, error) {
+	salt := make([]byte, saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
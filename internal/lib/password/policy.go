@@ -0,0 +1,136 @@
+package password
+
+import (
+	"fmt"
+	"sso/internal/domain/models"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Rules of a password policy.
+const (
+	RuleMinLength       = "min_length"
+	RuleMaxLength       = "max_length"
+	RuleUpper           = "uppercase"
+	RuleLower           = "lowercase"
+	RuleDigit           = "digit"
+	RuleSpecial         = "special"
+	RuleBannedWord      = "banned_word"
+	RuleEmailSimilarity = "email_similarity"
+	// RuleBreached and RuleHistory are checked by the caller against a corpus
+	// of breached passwords and the previous passwords of the user.
+	RuleBreached = "breached"
+	RuleHistory  = "history"
+)
+
+// minEmailLocalLen is the shortest local part of an email passwords are compared with.
+const minEmailLocalLen = 3
+
+// Violation is a rule of the policy a password breaks.
+type Violation struct {
+	Rule    string
+	Message string
+}
+
+// CheckPolicy returns the rules of the policy the password of the user with the email breaks.
+func CheckPolicy(policy *models.PasswordPolicy, password, email string) []Violation {
+	var violations []Violation
+
+	length := utf8.RuneCountInString(password)
+	if length < policy.MinLength {
+		violations = append(violations, Violation{
+			Rule:    RuleMinLength,
+			Message: fmt.Sprintf("must be at least %d characters long", policy.MinLength),
+		})
+	}
+	if policy.MaxLength > 0 && length > policy.MaxLength {
+		violations = append(violations, Violation{
+			Rule:    RuleMaxLength,
+			Message: fmt.Sprintf("must be at most %d characters long", policy.MaxLength),
+		})
+	}
+
+	var upper, lower, digit, special bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case !unicode.IsLetter(r):
+			special = true
+		}
+	}
+	if policy.RequireUpper && !upper {
+		violations = append(violations, Violation{Rule: RuleUpper, Message: "must contain an upper case letter"})
+	}
+	if policy.RequireLower && !lower {
+		violations = append(violations, Violation{Rule: RuleLower, Message: "must contain a lower case letter"})
+	}
+	if policy.RequireDigit && !digit {
+		violations = append(violations, Violation{Rule: RuleDigit, Message: "must contain a digit"})
+	}
+	if policy.RequireSpecial && !special {
+		violations = append(violations, Violation{Rule: RuleSpecial, Message: "must contain a special character"})
+	}
+
+	normalized := strings.ToLower(password)
+	for _, word := range strings.Fields(strings.ToLower(policy.BannedWords)) {
+		if strings.Contains(normalized, word) {
+			violations = append(violations, Violation{Rule: RuleBannedWord, Message: "must not contain common words"})
+			break
+		}
+	}
+
+	if policy.EmailSimilarity > 0 && similarToEmail(normalized, email, policy.EmailSimilarity) {
+		violations = append(violations, Violation{Rule: RuleEmailSimilarity, Message: "must not resemble the email"})
+	}
+
+	return violations
+}
+
+// similarToEmail reports whether the lower case password contains the local part of the email
+// or is at least as similar to it as the threshold.
+func similarToEmail(password, email string, threshold float64) bool {
+	local, _, _ := strings.Cut(strings.ToLower(email), "@")
+	if utf8.RuneCountInString(local) < minEmailLocalLen {
+		return false
+	}
+	if strings.Contains(password, local) {
+		return true
+	}
+	return similarity(password, local) >= threshold
+}
+
+// similarity is one minus the edit distance of the strings relative to the longer one.
+func similarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	longest := max(len(ra), len(rb))
+	if longest == 0 {
+		return 1
+	}
+	return 1 - float64(levenshtein(ra, rb))/float64(longest)
+}
+
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
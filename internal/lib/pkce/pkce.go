@@ -0,0 +1,36 @@
+// Package pkce implements Proof Key for Code Exchange (RFC 7636).
+package pkce
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"regexp"
+)
+
+// MethodS256 is the only supported challenge method, "plain" is not accepted.
+const MethodS256 = "S256"
+
+var (
+	verifierRe  = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+	challengeRe = regexp.MustCompile(`^[A-Za-z0-9\-_]{43}$`)
+)
+
+// ValidChallenge reports whether challenge is a well-formed S256 code challenge.
+func ValidChallenge(challenge, method string) bool {
+	return method == MethodS256 && challengeRe.MatchString(challenge)
+}
+
+// Challenge returns the S256 code challenge of the verifier.
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// Verify reports whether the verifier matches the S256 challenge.
+func Verify(verifier, challenge string) bool {
+	if !verifierRe.MatchString(verifier) {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(Challenge(verifier)), []byte(challenge)) == 1
+}
@@ -0,0 +1,72 @@
+// Package ratelimit keeps a token bucket per key.
+package ratelimit
+
+import (
+	"golang.org/x/time/rate"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often the buckets which refilled completely are dropped,
+// they are indistinguishable from new buckets.
+const sweepInterval = time.Minute
+
+// Limit is the rate per second tokens are added to a bucket at and the size of the bucket.
+// A zero rate disables the limit.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+type Limiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*rate.Limiter
+	lastSweep time.Time
+}
+
+func New() *Limiter {
+	return &Limiter{
+		buckets:   make(map[string]*rate.Limiter),
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow takes a token from the bucket of the key. If the bucket is empty,
+// it reports how long it takes until the next token is added.
+// The limit of a key is fixed by its first call.
+func (l *Limiter) Allow(key string, limit Limit) (bool, time.Duration) {
+	if limit.Rate <= 0 {
+		return true, 0
+	}
+
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		l.sweep(now)
+	}
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = rate.NewLimiter(rate.Limit(limit.Rate), max(limit.Burst, 1))
+		l.buckets[key] = bucket
+	}
+
+	r := bucket.ReserveN(now, 1)
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+func (l *Limiter) sweep(now time.Time) {
+	for key, bucket := range l.buckets {
+		if bucket.TokensAt(now) >= float64(bucket.Burst()) {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
@@ -4,8 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/go-webauthn/webauthn/webauthn"
 	"log/slog"
+	"net/http"
+	"sso/internal/config"
 	"sso/internal/domain/models"
 	"sso/internal/lib/jwt"
 	"sso/internal/storage"
@@ -13,50 +15,291 @@ import (
 )
 
 type Auth struct {
-	log          *slog.Logger
-	userSaver    UserSaver
-	userProvider UserProvider
-	appProvider  AppProvider
-	tokenTTL     time.Duration
+	log                 *slog.Logger
+	userSaver           UserSaver
+	userProvider        UserProvider
+	appProvider         AppProvider
+	tokenStorage        TokenStorage
+	revocation          RevocationStorage
+	keyStorage          KeyStorage
+	resetStorage        ResetTokenStorage
+	verificationStorage VerificationStorage
+	mfaStorage          MFAStorage
+	secrets             SecretBox
+	passkeyStorage      PasskeyStorage
+	passkeys            *webauthn.WebAuthn
+	oauthStorage        OAuthStorage
+	clientStorage       ClientStorage
+	deviceStorage       DeviceStorage
+	exchangeStorage     ExchangeStorage
+	federationStorage   FederationStorage
+	federationClient    *http.Client
+	directoryStorage    DirectoryStorage
+	roleStorage         RoleStorage
+	passwordStorage     PasswordStorage
+	attemptStorage      AttemptStorage
+	directory           Directory
+	passwords           PasswordHasher
+	breaches            BreachChecker
+	mailer              Mailer
+	cfg                 *config.Config
 }
 
 var (
 	ErrInvalidCredentials = errors.New("invalid email or password")
 	ErrInvalidAppId       = errors.New("invalid app id")
 	ErrUserExists         = errors.New("user already exists")
+	ErrInvalidToken       = errors.New("invalid refresh token")
+	ErrTokenReused        = errors.New("refresh token reused")
+	ErrInvalidAccessToken = errors.New("invalid access token")
 )
 
 type UserSaver interface {
 	SaveUser(ctx context.Context, email, passHash string) (uid int64, err error)
+	UpdatePassword(ctx context.Context, userID int64, passHash string) error
+	SetEmailVerified(ctx context.Context, userID int64, email string) error
+	ChangeEmail(ctx context.Context, userID int64, oldEmail, newEmail string) error
 }
 
 type UserProvider interface {
 	User(ctx context.Context, email string) (*models.User, error)
+	UserByID(ctx context.Context, userID int64) (*models.User, error)
 	IsAdmin(ctx context.Context, userID int64) (bool, error)
 }
 
 type AppProvider interface {
 	App(ctx context.Context, appID int) (*models.App, error)
+	Apps(ctx context.Context) ([]models.App, error)
+	PasswordPolicy(ctx context.Context, appID int64) (*models.PasswordPolicy, error)
+	MaxPasswordHistorySize(ctx context.Context) (int, error)
+}
+
+type TokenStorage interface {
+	SaveRefreshToken(ctx context.Context, token *models.RefreshToken) error
+	RefreshToken(ctx context.Context, tokenHash string) (*models.RefreshToken, error)
+	UseRefreshToken(ctx context.Context, tokenHash string) (*models.RefreshToken, error)
+	RevokeRefreshTokenFamily(ctx context.Context, familyID string) error
+	RevokeUserTokens(ctx context.Context, userID int64) error
+}
+
+type RevocationStorage interface {
+	RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error
+	IsTokenRevoked(ctx context.Context, jti string) (bool, error)
+	DeleteExpiredRevokedTokens(ctx context.Context) (int64, error)
+}
+
+type KeyStorage interface {
+	SaveSigningKey(ctx context.Context, key *models.SigningKey) error
+	SaveFirstSigningKey(ctx context.Context, key *models.SigningKey) (*models.SigningKey, error)
+	SigningKey(ctx context.Context, appID int64) (*models.SigningKey, error)
+	SigningKeyByKid(ctx context.Context, kid string) (*models.SigningKey, error)
+	SigningKeys(ctx context.Context) ([]models.SigningKey, error)
+	RetireSigningKeys(ctx context.Context, appID int64, exceptKid string, retiresAt time.Time) error
+}
+
+type ResetTokenStorage interface {
+	SavePasswordResetToken(ctx context.Context, token *models.PasswordResetToken) error
+	PasswordResetToken(ctx context.Context, tokenHash string) (*models.PasswordResetToken, error)
+	UsePasswordResetToken(ctx context.Context, tokenHash string) (*models.PasswordResetToken, error)
+}
+
+type VerificationStorage interface {
+	SaveEmailVerificationToken(ctx context.Context, token *models.EmailVerificationToken) error
+	UseEmailVerificationToken(ctx context.Context, tokenHash string) (*models.EmailVerificationToken, error)
+	LastEmailVerificationToken(ctx context.Context, userID int64) (*models.EmailVerificationToken, error)
+	SaveEmailChangeToken(ctx context.Context, token *models.EmailChangeToken) error
+	UseEmailChangeToken(ctx context.Context, tokenHash string) (*models.EmailChangeToken, error)
+}
+
+type MFAStorage interface {
+	SaveTOTPSecret(ctx context.Context, userID int64, secret string) error
+	EnableTOTP(ctx context.Context, userID int64) error
+	UseTOTPStep(ctx context.Context, userID int64, step int64) error
+	SaveMFAChallenge(ctx context.Context, challenge *models.MFAChallenge) error
+	MFAChallenge(ctx context.Context, challengeHash string, maxAttempts int) (*models.MFAChallenge, error)
+	AddMFAChallengeAttempt(ctx context.Context, challengeID int64) error
+	UseMFAChallenge(ctx context.Context, challengeID int64) error
+	ReplaceRecoveryCodes(ctx context.Context, userID int64, codeHashes []string) error
+	UseRecoveryCode(ctx context.Context, userID int64, codeHash string) error
+}
+
+type PasskeyStorage interface {
+	SavePasskeyCredential(ctx context.Context, cred *models.PasskeyCredential) error
+	PasskeyCredentials(ctx context.Context, userID int64) ([]models.PasskeyCredential, error)
+	UpdatePasskeyUsage(ctx context.Context, credentialID []byte, signCount int64, backupState bool) error
+	SaveWebAuthnSession(ctx context.Context, session *models.WebAuthnSession) error
+	TakeWebAuthnSession(ctx context.Context, sessionHash string) (*models.WebAuthnSession, error)
+}
+
+type OAuthStorage interface {
+	RedirectURIs(ctx context.Context, appID int64) ([]string, error)
+	SaveAuthorizationCode(ctx context.Context, code *models.AuthorizationCode) error
+	UseAuthorizationCode(ctx context.Context, codeHash string) (*models.AuthorizationCode, error)
+}
+
+type ClientStorage interface {
+	SaveClient(ctx context.Context, client *models.Client) error
+	Client(ctx context.Context, clientID string) (*models.Client, error)
+}
+
+type DeviceStorage interface {
+	SaveDeviceCode(ctx context.Context, code *models.DeviceCode) error
+	DeviceCode(ctx context.Context, deviceCodeHash string) (*models.DeviceCode, error)
+	PollDeviceCode(ctx context.Context, id int64, slowDown bool) error
+	PendingDeviceCode(ctx context.Context, userCode string) (*models.DeviceCode, error)
+	DecideDeviceCode(ctx context.Context, id int64, userID int64, status string) error
+	UseDeviceCode(ctx context.Context, id int64) error
+}
+
+type ExchangeStorage interface {
+	TokenExchangePolicy(ctx context.Context, clientID string, audienceAppID int64) (*models.TokenExchangePolicy, error)
+	SaveTokenExchangeAudit(ctx context.Context, entry *models.TokenExchangeAudit) error
+}
+
+type FederationStorage interface {
+	IdentityProvider(ctx context.Context, appID int64, name string) (*models.IdentityProvider, error)
+	IdentityProviderByID(ctx context.Context, id int64) (*models.IdentityProvider, error)
+	SaveFederatedLogin(ctx context.Context, login *models.FederatedLogin) error
+	TakeFederatedLogin(ctx context.Context, stateHash string) (*models.FederatedLogin, error)
+	UserIdentity(ctx context.Context, providerID int64, subject string) (*models.UserIdentity, error)
+	SaveUserIdentity(ctx context.Context, identity *models.UserIdentity) error
+	SaveFederatedUser(ctx context.Context, email, passHash string, providerID int64, subject string) (int64, error)
+	DeleteUserIdentity(ctx context.Context, userID, providerID int64) error
+}
+
+type DirectoryStorage interface {
+	LDAPDirectory(ctx context.Context, appID int64) (*models.LDAPDirectory, error)
+	LDAPGroupRoles(ctx context.Context, directoryID int64) ([]models.LDAPGroupRole, error)
+	IsLDAPUser(ctx context.Context, directoryID, userID int64) (bool, error)
+	SaveLDAPUser(ctx context.Context, directoryID, userID int64) error
+	SaveLDAPLogin(ctx context.Context, directoryID, userID int64, login string) error
+	LDAPLogins(ctx context.Context, userID int64) ([]string, error)
+}
+
+type RoleStorage interface {
+	UserRoles(ctx context.Context, userID, appID int64) ([]string, error)
+	ReplaceUserRoles(ctx context.Context, userID, appID int64, roles []string) error
+}
+
+type PasswordStorage interface {
+	SetPassword(ctx context.Context, userID int64, passHash string, keep int) error
+	PasswordHistory(ctx context.Context, userID int64, limit int) ([]string, error)
+	SavePasswordChangeTicket(ctx context.Context, ticket *models.PasswordChangeTicket) error
+	PasswordChangeTicket(ctx context.Context, ticketHash string) (*models.PasswordChangeTicket, error)
+	UsePasswordChangeTicket(ctx context.Context, ticketID int64) error
+}
+
+type AttemptStorage interface {
+	LoginAttempts(ctx context.Context, scope, subject string) (*models.LoginAttempts, error)
+	AddLoginFailure(ctx context.Context, scope, subject string, windowStart time.Time) (*models.LoginAttempts, error)
+	LockLogin(ctx context.Context, scope, subject string, until time.Time) error
+	DeleteLoginAttempts(ctx context.Context, scope, subject string) error
+}
+
+// Directory verifies credentials against an LDAP directory.
+type Directory interface {
+	Authenticate(ctx context.Context, dir *models.LDAPDirectory, login, password string) (*models.DirectoryEntry, error)
+}
+
+// PasswordHasher hashes passwords, the hashes name their algorithm and parameters.
+type PasswordHasher interface {
+	Hash(password []byte) (string, error)
+	Verify(hash string, password []byte) (bool, error)
+	// NeedsRehash reports whether the hash was made with an outdated algorithm or parameters.
+	NeedsRehash(hash string) bool
+}
+
+// BreachChecker counts the appearances of a password in known data breaches.
+type BreachChecker interface {
+	Count(password string) (int, error)
+}
+
+// SecretBox encrypts secrets stored at rest.
+type SecretBox interface {
+	Seal(plaintext string) (string, error)
+	Open(ciphertext string) (string, error)
+}
+
+type Mailer interface {
+	Send(ctx context.Context, to, subject, body string) error
+}
+
+// Storage is everything the auth service keeps, the postgres storage implements all of it.
+type Storage interface {
+	UserSaver
+	UserProvider
+	AppProvider
+	TokenStorage
+	RevocationStorage
+	KeyStorage
+	ResetTokenStorage
+	VerificationStorage
+	MFAStorage
+	PasskeyStorage
+	OAuthStorage
+	ClientStorage
+	DeviceStorage
+	ExchangeStorage
+	FederationStorage
+	DirectoryStorage
+	RoleStorage
+	PasswordStorage
+	AttemptStorage
+}
+
+// Deps are the dependencies of the auth service. Secrets, Passkeys, Directory, Passwords
+// and Breaches may be nil for tools which don't use the features needing them.
+type Deps struct {
+	Storage   Storage
+	Secrets   SecretBox
+	Passkeys  *webauthn.WebAuthn
+	Directory Directory
+	Passwords PasswordHasher
+	Breaches  BreachChecker
+	Mailer    Mailer
 }
 
 // New Return a new instance of auth service
-func New(
-	log *slog.Logger,
-	userSaver UserSaver,
-	userProvider UserProvider,
-	appProvider AppProvider,
-	tokenTTL time.Duration,
-) *Auth {
+func New(log *slog.Logger, deps Deps, cfg *config.Config) *Auth {
 	return &Auth{
-		log:          log,
-		userSaver:    userSaver,
-		userProvider: userProvider,
-		appProvider:  appProvider,
-		tokenTTL:     tokenTTL,
+		log:                 log,
+		userSaver:           deps.Storage,
+		userProvider:        deps.Storage,
+		appProvider:         deps.Storage,
+		tokenStorage:        deps.Storage,
+		revocation:          deps.Storage,
+		keyStorage:          deps.Storage,
+		resetStorage:        deps.Storage,
+		verificationStorage: deps.Storage,
+		mfaStorage:          deps.Storage,
+		secrets:             deps.Secrets,
+		passkeyStorage:      deps.Storage,
+		passkeys:            deps.Passkeys,
+		oauthStorage:        deps.Storage,
+		clientStorage:       deps.Storage,
+		deviceStorage:       deps.Storage,
+		exchangeStorage:     deps.Storage,
+		federationStorage:   deps.Storage,
+		federationClient:    &http.Client{Timeout: cfg.Federation.Timeout},
+		directoryStorage:    deps.Storage,
+		roleStorage:         deps.Storage,
+		passwordStorage:     deps.Storage,
+		attemptStorage:      deps.Storage,
+		directory:           deps.Directory,
+		passwords:           deps.Passwords,
+		breaches:            deps.Breaches,
+		mailer:              deps.Mailer,
+		cfg:                 cfg,
 	}
 }
 
-func (a *Auth) Login(ctx context.Context, email, password string, appID int) (string, error) {
+// Login checks user credentials and issues tokens. Apps with an LDAP directory
+// check the credentials against the directory.
+// If the user has a second factor, only an MFA challenge is returned,
+// which has to be completed with VerifyMFA.
+// If the password expired, only a password change id is returned, which permits ChangePassword,
+// for users with a second factor by VerifyMFA.
+func (a *Auth) Login(ctx context.Context, email, password string, appID int) (*models.LoginResult, error) {
 	const op = "auth.Login"
 	log := a.log.With(
 		slog.String("op", op),
@@ -65,37 +308,67 @@ func (a *Auth) Login(ctx context.Context, email, password string, appID int) (st
 
 	log.Info("login user")
 
-	user, err := a.userProvider.User(ctx, email)
+	app, err := a.appProvider.App(ctx, appID)
 	if err != nil {
-		if errors.Is(err, storage.ErrUserNotFound) {
-			log.Warn("user not found", slog.String("error", err.Error()))
-			return "", fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return nil, fmt.Errorf("%s: %w", op, ErrInvalidAppId)
 		}
-		log.Error("failed to get user", slog.String("error", err.Error()))
-		return "", fmt.Errorf("%s: %w", err)
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	user, local, err := a.authenticateThrottled(ctx, log, app, email, password)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	if err := bcrypt.CompareHashAndPassword(user.PassHash, []byte(password)); err != nil {
-		log.Info("invalid credentials", slog.String("error", err.Error()))
-		return "", fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+	if app.RequireVerifiedEmail && !user.EmailVerified {
+		log.Info("email not verified")
+		return nil, fmt.Errorf("%s: %w", op, ErrEmailNotVerified)
 	}
-	app, err := a.appProvider.App(ctx, appID)
-	if err != nil {
-		if errors.Is(err, storage.ErrAppNotFound) {
-			return "", fmt.Errorf("%s: %w", op, ErrInvalidAppId)
+
+	expired := false
+	if local {
+		expired, err = a.passwordExpired(ctx, app.ID, user)
+		if err != nil {
+			log.Error("failed to check password expiry", slog.String("error", err.Error()))
+			return nil, fmt.Errorf("%s: %w", op, err)
 		}
-		return "", fmt.Errorf("%s: %w", op, err)
 	}
 
-	token, err := jwt.NewToken(user, app, a.tokenTTL)
+	// the second factor comes first, the password alone must not change the password
+	if user.TOTPEnabled {
+		challengeID, err := a.createMFAChallenge(ctx, user, app, expired)
+		if err != nil {
+			log.Error("failed to create mfa challenge", slog.String("error", err.Error()))
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		log.Info("mfa required")
+		return &models.LoginResult{MFAChallengeID: challengeID}, nil
+	}
 
-	log.Info("user logged in successfully")
+	if expired {
+		changeID, err := a.createPasswordChangeTicket(ctx, user, app)
+		if err != nil {
+			log.Error("failed to create password change ticket", slog.String("error", err.Error()))
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		log.Info("password expired")
+		return &models.LoginResult{PasswordChangeID: changeID}, nil
+	}
+
+	pair, err := a.startSession(ctx, user, app, "")
+	if err != nil {
+		log.Error("failed to issue tokens", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
 
-	return token, nil
+	log.Info("user logged in successfully")
 
+	return &models.LoginResult{Tokens: pair}, nil
 }
 
-func (a *Auth) RegisterNewUser(ctx context.Context, email string, password []byte) (userID int64, err error) {
+// RegisterNewUser registers a user with the password policy of the app,
+// an app id of zero stands for the policy of the config.
+func (a *Auth) RegisterNewUser(ctx context.Context, email string, password []byte, appID int64) (userID int64, err error) {
 	const op = "auth.RegisterNewUser"
 	log := a.log.With(
 		slog.String("op", op),
@@ -103,13 +376,27 @@ func (a *Auth) RegisterNewUser(ctx context.Context, email string, password []byt
 	)
 	log.Info("registering user")
 
-	hashedPass, err := bcrypt.GenerateFromPassword(password, bcrypt.DefaultCost)
+	if appID != 0 {
+		if _, err := a.appProvider.App(ctx, int(appID)); err != nil {
+			if errors.Is(err, storage.ErrAppNotFound) {
+				log.Warn("app not found", slog.Int64("app_id", appID))
+				return 0, fmt.Errorf("%s: %w", op, ErrInvalidAppId)
+			}
+			log.Error("failed to get app", slog.String("error", err.Error()))
+			return 0, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+	if err := a.checkPasswordPolicy(ctx, log, appID, string(password), email, nil); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	hashedPass, err := a.passwords.Hash(password)
 	if err != nil {
 		log.Error("failed to hash password", slog.String("error", err.Error()))
 		return 0, fmt.Errorf("%s: %w", op, err)
 	}
 
-	userId, err := a.userSaver.SaveUser(ctx, email, string(hashedPass))
+	userId, err := a.userSaver.SaveUser(ctx, email, hashedPass)
 	if err != nil {
 		if errors.Is(err, storage.ErrUserExists) {
 			log.Warn("user already exists", slog.String("email", email))
@@ -119,6 +406,11 @@ func (a *Auth) RegisterNewUser(ctx context.Context, email string, password []byt
 		return 0, fmt.Errorf("%s: %w", op, err)
 	}
 
+	if err := a.sendEmailVerification(ctx, userId, email); err != nil {
+		// the user can request the email again
+		log.Error("failed to send verification email", slog.String("error", err.Error()))
+	}
+
 	log.Info("user registered")
 
 	return userId, nil
@@ -143,3 +435,117 @@ func (a *Auth) IsAdmin(ctx context.Context, userID int64) (bool, error) {
 
 	return isAdmin, nil
 }
+
+// checkPassword returns the user with the email if the password matches.
+func (a *Auth) checkPassword(ctx context.Context, log *slog.Logger, email, password string) (*models.User, error) {
+	user, err := a.userProvider.User(ctx, email)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Warn("user not found", slog.String("error", err.Error()))
+			return nil, ErrInvalidCredentials
+		}
+		log.Error("failed to get user", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	if err := a.verifyPassword(ctx, log, user, password); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// verifyPassword checks the password of the user. A hash made with an outdated algorithm
+// or parameters is replaced while the plaintext is at hand.
+func (a *Auth) verifyPassword(ctx context.Context, log *slog.Logger, user *models.User, password string) error {
+	ok, err := a.passwords.Verify(string(user.PassHash), []byte(password))
+	if err != nil {
+		log.Error("failed to verify password", slog.String("error", err.Error()))
+		return ErrInvalidCredentials
+	}
+	if !ok {
+		log.Info("invalid credentials")
+		return ErrInvalidCredentials
+	}
+
+	if a.passwords.NeedsRehash(string(user.PassHash)) {
+		// the stored hash still works, the user is not failed if it cannot be replaced
+		passHash, err := a.passwords.Hash([]byte(password))
+		if err != nil {
+			log.Error("failed to rehash password", slog.String("error", err.Error()))
+			return nil
+		}
+		if err := a.userSaver.UpdatePassword(ctx, user.ID, passHash); err != nil {
+			log.Error("failed to save rehashed password", slog.String("error", err.Error()))
+			return nil
+		}
+		user.PassHash = []byte(passHash)
+		log.Info("password rehashed")
+	}
+	return nil
+}
+
+// verifyAccountToken verifies an access token for changing the account of its user.
+// Tokens of a token exchange are rejected, an impersonating admin acts for the user
+// in the apps but does not get to add credentials or approve devices.
+func (a *Auth) verifyAccountToken(ctx context.Context, accessToken string) (*jwt.Claims, error) {
+	claims, err := a.verifyAccessToken(ctx, accessToken)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Actor != "" {
+		return nil, fmt.Errorf("%w: token of %s acting for the user", ErrInvalidAccessToken, claims.Actor)
+	}
+	return claims, nil
+}
+
+// authenticate returns the user the access token was issued to.
+func (a *Auth) authenticate(ctx context.Context, accessToken string) (*models.User, error) {
+	claims, err := a.verifyAccountToken(ctx, accessToken)
+	if err != nil {
+		return nil, err
+	}
+	user, err := a.userProvider.UserByID(ctx, claims.UID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return nil, ErrInvalidAccessToken
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+// verifyAccessToken returns the claims of a valid access token which is not revoked.
+func (a *Auth) verifyAccessToken(ctx context.Context, accessToken string) (*jwt.Claims, error) {
+	claims, err := a.parseToken(ctx, accessToken)
+	if err != nil {
+		return nil, ErrInvalidAccessToken
+	}
+	revoked, err := a.tokenRevoked(ctx, claims)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, ErrInvalidAccessToken
+	}
+	return claims, nil
+}
+
+// tokenRevoked reports whether the token was revoked by Logout, or with all tokens
+// of its user when the password changed.
+func (a *Auth) tokenRevoked(ctx context.Context, claims *jwt.Claims) (bool, error) {
+	revoked, err := a.revocation.IsTokenRevoked(ctx, claims.ID)
+	if err != nil || revoked {
+		return revoked, err
+	}
+	if claims.UID == 0 {
+		return false, nil
+	}
+	user, err := a.userProvider.UserByID(ctx, claims.UID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return true, nil
+		}
+		return false, err
+	}
+	return user.TokensValidAfter != nil && claims.IssuedAt.Before(*user.TokensValidAfter), nil
+}
@@ -0,0 +1,280 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"sso/internal/domain/models"
+	"sso/internal/lib/opaque"
+	"sso/internal/storage"
+	"strings"
+	"time"
+)
+
+const (
+	// userCodeAlphabet has no vowels, so user codes don't spell words (RFC 8628 section 6.1).
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength   = 8
+	// userCodeAttempts is how many times a colliding user code is regenerated.
+	userCodeAttempts = 3
+)
+
+var (
+	ErrAuthorizationPending = errors.New("authorization pending")
+	ErrSlowDown             = errors.New("polling too fast")
+	ErrAccessDenied         = errors.New("access denied")
+	ErrExpiredToken         = errors.New("device code expired")
+	ErrInvalidUserCode      = errors.New("invalid user code")
+)
+
+// StartDeviceAuthorization starts the device authorization grant for the client.
+// The device shows the user code and polls ExchangeDeviceCode with the device code.
+func (a *Auth) StartDeviceAuthorization(ctx context.Context, clientID int, scope string) (*models.DeviceAuthorization, error) {
+	const op = "auth.StartDeviceAuthorization"
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int("client_id", clientID),
+	)
+
+	app, err := a.appProvider.App(ctx, clientID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return nil, fmt.Errorf("%s: %w", op, ErrInvalidAppId)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	deviceCode, hash, err := opaque.New()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var userCode string
+	for i := 0; i < userCodeAttempts; i++ {
+		userCode, err = generateUserCode()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		err = a.deviceStorage.SaveDeviceCode(ctx, &models.DeviceCode{
+			DeviceCodeHash: hash,
+			UserCode:       userCode,
+			AppID:          app.ID,
+			Scope:          scope,
+			PollInterval:   int(a.cfg.OAuth.DevicePollInterval.Seconds()),
+			ExpiresAt:      time.Now().Add(a.cfg.OAuth.DeviceCodeTTL),
+		})
+		if !errors.Is(err, storage.ErrDeviceCodeExists) {
+			break
+		}
+	}
+	if err != nil {
+		log.Error("failed to save device code", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("device authorization started")
+
+	return &models.DeviceAuthorization{
+		DeviceCode: deviceCode,
+		UserCode:   formatUserCode(userCode),
+		ExpiresIn:  a.cfg.OAuth.DeviceCodeTTL,
+		Interval:   a.cfg.OAuth.DevicePollInterval,
+	}, nil
+}
+
+// VerifyDeviceCode approves or denies a device authorization on the verification page,
+// the user signs in with the same credentials as on the authorization page.
+func (a *Auth) VerifyDeviceCode(ctx context.Context, userCode, email, password, mfaCode string, approve bool) error {
+	const op = "auth.VerifyDeviceCode"
+	log := a.log.With(
+		slog.String("op", op),
+		slog.String("email", email),
+	)
+
+	code, app, err := a.pendingDeviceCode(ctx, userCode)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	user, err := a.signIn(ctx, log, app, email, password, mfaCode)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.decideDeviceCode(ctx, log, code, user, approve); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// ApproveDevice approves or denies a device authorization for the user the access token was issued to.
+func (a *Auth) ApproveDevice(ctx context.Context, accessToken, userCode string, approve bool) error {
+	const op = "auth.ApproveDevice"
+	log := a.log.With(slog.String("op", op))
+
+	user, err := a.authenticate(ctx, accessToken)
+	if err != nil {
+		log.Warn("failed to authenticate", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("uid", user.ID))
+
+	code, app, err := a.pendingDeviceCode(ctx, userCode)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if app.RequireVerifiedEmail && !user.EmailVerified {
+		return fmt.Errorf("%s: %w", op, ErrEmailNotVerified)
+	}
+
+	if err := a.decideDeviceCode(ctx, log, code, user, approve); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// ExchangeDeviceCode is polled by the device until the user decides.
+// It returns ErrAuthorizationPending while the user has not decided yet
+// and ErrSlowDown if the device polls faster than the poll interval,
+// which is increased by 5 seconds each time.
+func (a *Auth) ExchangeDeviceCode(ctx context.Context, deviceCode string, clientID int) (*models.TokenPair, error) {
+	const op = "auth.ExchangeDeviceCode"
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int("client_id", clientID),
+	)
+
+	code, err := a.deviceStorage.DeviceCode(ctx, opaque.Hash(deviceCode))
+	if err != nil {
+		if errors.Is(err, storage.ErrDeviceCodeNotFound) {
+			return nil, fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if code.AppID != int64(clientID) || code.UsedAt != nil {
+		return nil, fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+	}
+	if time.Now().After(code.ExpiresAt) {
+		return nil, fmt.Errorf("%s: %w", op, ErrExpiredToken)
+	}
+
+	interval := time.Duration(code.PollInterval) * time.Second
+	slowDown := code.LastPolledAt != nil && time.Since(*code.LastPolledAt) < interval
+	if err := a.deviceStorage.PollDeviceCode(ctx, code.ID, slowDown); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if slowDown {
+		log.Info("device polls too fast")
+		return nil, fmt.Errorf("%s: %w", op, ErrSlowDown)
+	}
+
+	switch code.Status {
+	case models.DeviceCodePending:
+		return nil, fmt.Errorf("%s: %w", op, ErrAuthorizationPending)
+	case models.DeviceCodeDenied:
+		return nil, fmt.Errorf("%s: %w", op, ErrAccessDenied)
+	}
+
+	if err := a.deviceStorage.UseDeviceCode(ctx, code.ID); err != nil {
+		if errors.Is(err, storage.ErrDeviceCodeNotFound) {
+			return nil, fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	user, err := a.userProvider.UserByID(ctx, *code.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return nil, fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	app, err := a.appProvider.App(ctx, clientID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	pair, err := a.startSession(ctx, user, app, code.Scope)
+	if err != nil {
+		log.Error("failed to issue tokens", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("device authorized", slog.Int64("uid", user.ID))
+
+	return pair, nil
+}
+
+func (a *Auth) pendingDeviceCode(ctx context.Context, userCode string) (*models.DeviceCode, *models.App, error) {
+	code, err := a.deviceStorage.PendingDeviceCode(ctx, normalizeUserCode(userCode))
+	if err != nil {
+		if errors.Is(err, storage.ErrDeviceCodeNotFound) {
+			return nil, nil, ErrInvalidUserCode
+		}
+		return nil, nil, err
+	}
+	app, err := a.appProvider.App(ctx, int(code.AppID))
+	if err != nil {
+		return nil, nil, err
+	}
+	return code, app, nil
+}
+
+func (a *Auth) decideDeviceCode(
+	ctx context.Context,
+	log *slog.Logger,
+	code *models.DeviceCode,
+	user *models.User,
+	approve bool,
+) error {
+	status := models.DeviceCodeDenied
+	if approve {
+		status = models.DeviceCodeApproved
+	}
+	if err := a.deviceStorage.DecideDeviceCode(ctx, code.ID, user.ID, status); err != nil {
+		if errors.Is(err, storage.ErrDeviceCodeNotFound) {
+			return ErrInvalidUserCode
+		}
+		log.Error("failed to save decision", slog.String("error", err.Error()))
+		return err
+	}
+
+	log.Info("device authorization decided", slog.String("status", status))
+
+	return nil
+}
+
+func generateUserCode() (string, error) {
+	size := big.NewInt(int64(len(userCodeAlphabet)))
+	b := make([]byte, userCodeLength)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, size)
+		if err != nil {
+			return "", err
+		}
+		b[i] = userCodeAlphabet[n.Int64()]
+	}
+	return string(b), nil
+}
+
+// formatUserCode splits the code in two halves for readability, e.g. WDJB-MJHT.
+func formatUserCode(code string) string {
+	return code[:userCodeLength/2] + "-" + code[userCodeLength/2:]
+}
+
+// normalizeUserCode drops separators and case the user may have typed.
+func normalizeUserCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' {
+			return r - 'a' + 'A'
+		}
+		if r >= 'A' && r <= 'Z' {
+			return r
+		}
+		return -1
+	}, code)
+}
@@ -0,0 +1,264 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/ldap"
+	"sso/internal/lib/opaque"
+	"sso/internal/storage"
+	"strings"
+)
+
+var (
+	ErrDirectoryUnavailable        = errors.New("directory unavailable")
+	ErrUnknownDirectory            = errors.New("app has no directory")
+	ErrInvalidDirectoryCredentials = errors.New("invalid directory credentials")
+)
+
+// LinkDirectory links the user of the access token to the directory of the app, the user signs
+// in to the app with the directory credentials from then on. The entry is found by the email
+// of the user and must carry the same email. The current password is required as for LinkIdentity,
+// it is checked for the app the token was issued for.
+func (a *Auth) LinkDirectory(
+	ctx context.Context,
+	accessToken string,
+	password string,
+	appID int,
+	directoryPassword string,
+) error {
+	const op = "auth.LinkDirectory"
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", appID),
+	)
+
+	user, _, err := a.reauthenticate(ctx, log, accessToken, password)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("uid", user.ID))
+
+	dir, err := a.directoryStorage.LDAPDirectory(ctx, int64(appID))
+	if err != nil {
+		if errors.Is(err, storage.ErrDirectoryNotFound) {
+			return fmt.Errorf("%s: %w", op, ErrUnknownDirectory)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("directory_id", dir.ID))
+
+	dirCtx, cancel := context.WithTimeout(ctx, a.cfg.LDAP.Timeout)
+	defer cancel()
+
+	entry, err := a.directory.Authenticate(dirCtx, dir, user.Email, directoryPassword)
+	if err != nil {
+		if errors.Is(err, ldap.ErrUserNotFound) || errors.Is(err, ldap.ErrInvalidCredentials) {
+			log.Info("invalid directory credentials")
+			return fmt.Errorf("%s: %w", op, ErrInvalidDirectoryCredentials)
+		}
+		log.Error("failed to authenticate against the directory", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, ErrDirectoryUnavailable)
+	}
+	if !strings.EqualFold(entry.Email, user.Email) {
+		log.Warn("directory entry has another email", slog.String("dn", entry.DN))
+		return fmt.Errorf("%s: %w", op, ErrInvalidDirectoryCredentials)
+	}
+
+	if err := a.directoryStorage.SaveLDAPUser(ctx, dir.ID, user.ID); err != nil {
+		log.Error("failed to save directory user", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("directory linked", slog.String("dn", entry.DN))
+
+	return nil
+}
+
+// authenticateUser verifies the credentials against the directory of the app if it has one,
+// otherwise against the local password. Users who are not in the directory, such as local
+// admins, still sign in with their local password. local reports whether the local password
+// was verified, the password policy of the app does not apply to directory passwords.
+func (a *Auth) authenticateUser(
+	ctx context.Context,
+	log *slog.Logger,
+	app *models.App,
+	login string,
+	password string,
+) (user *models.User, local bool, err error) {
+	dir, err := a.directoryStorage.LDAPDirectory(ctx, app.ID)
+	if err != nil {
+		if errors.Is(err, storage.ErrDirectoryNotFound) {
+			user, err = a.checkPassword(ctx, log, login, password)
+			return user, true, err
+		}
+		return nil, false, err
+	}
+
+	user, err = a.directoryUser(ctx, log, dir, login, password)
+	if errors.Is(err, ldap.ErrUserNotFound) {
+		user, err = a.checkPassword(ctx, log, login, password)
+		return user, true, err
+	}
+	return user, false, err
+}
+
+// directoryUser authenticates the user against the directory and provisions the local user
+// of the entry's email. The roles of the user in the app are replaced with the roles mapped
+// from the entry's groups on every sign in.
+func (a *Auth) directoryUser(
+	ctx context.Context,
+	log *slog.Logger,
+	dir *models.LDAPDirectory,
+	login string,
+	password string,
+) (*models.User, error) {
+	log = log.With(slog.Int64("directory_id", dir.ID))
+
+	dirCtx, cancel := context.WithTimeout(ctx, a.cfg.LDAP.Timeout)
+	defer cancel()
+
+	entry, err := a.directory.Authenticate(dirCtx, dir, login, password)
+	if err != nil {
+		if errors.Is(err, ldap.ErrUserNotFound) {
+			return nil, err
+		}
+		if errors.Is(err, ldap.ErrInvalidCredentials) {
+			log.Info("invalid directory credentials")
+			return nil, ErrInvalidCredentials
+		}
+		log.Error("failed to authenticate against the directory", slog.String("error", err.Error()))
+		return nil, ErrDirectoryUnavailable
+	}
+
+	log = log.With(slog.String("dn", entry.DN))
+
+	if entry.Email == "" {
+		log.Warn("directory entry has no email")
+		return nil, ErrInvalidCredentials
+	}
+
+	user, err := a.directoryAccount(ctx, log, dir, entry.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	// the failed logins under the login are forgotten by UnlockUser as well
+	if !strings.EqualFold(login, user.Email) {
+		if err := a.directoryStorage.SaveLDAPLogin(ctx, dir.ID, user.ID, strings.ToLower(login)); err != nil {
+			log.Error("failed to save directory login", slog.String("error", err.Error()))
+			return nil, err
+		}
+	}
+
+	groupRoles, err := a.directoryStorage.LDAPGroupRoles(ctx, dir.ID)
+	if err != nil {
+		return nil, err
+	}
+	roles := directoryRoles(entry.Groups, groupRoles)
+	if err := a.roleStorage.ReplaceUserRoles(ctx, user.ID, dir.AppID, roles); err != nil {
+		log.Error("failed to save roles", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	log.Info("user authenticated by directory",
+		slog.Int64("uid", user.ID),
+		slog.String("roles", strings.Join(roles, " ")),
+	)
+
+	return user, nil
+}
+
+// directoryAccount returns the local user of the directory with the email from the entry and
+// creates it just in time. An existing local account with the email is never taken over,
+// whoever can set the mail attribute of an entry would own it. Its owner links it with LinkDirectory.
+func (a *Auth) directoryAccount(
+	ctx context.Context,
+	log *slog.Logger,
+	dir *models.LDAPDirectory,
+	email string,
+) (*models.User, error) {
+	user, err := a.userProvider.User(ctx, email)
+	if err == nil {
+		return a.linkedDirectoryUser(ctx, log, dir, user)
+	}
+	if !errors.Is(err, storage.ErrUserNotFound) {
+		return nil, err
+	}
+
+	// the user signs in at the directory only, the local password is unusable
+	password, _, err := opaque.New()
+	if err != nil {
+		return nil, err
+	}
+	passHash, err := a.passwords.Hash([]byte(password))
+	if err != nil {
+		return nil, err
+	}
+
+	uid, err := a.userSaver.SaveUser(ctx, email, passHash)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserExists) {
+			// provisioned by a concurrent sign in or registered locally meanwhile
+			user, err := a.userProvider.User(ctx, email)
+			if err != nil {
+				return nil, err
+			}
+			return a.linkedDirectoryUser(ctx, log, dir, user)
+		}
+		log.Error("failed to provision user", slog.String("error", err.Error()))
+		return nil, err
+	}
+	if err := a.directoryStorage.SaveLDAPUser(ctx, dir.ID, uid); err != nil {
+		log.Error("failed to save directory user", slog.String("error", err.Error()))
+		return nil, err
+	}
+	if err := a.userSaver.SetEmailVerified(ctx, uid, email); err != nil {
+		return nil, err
+	}
+
+	log.Info("user provisioned", slog.Int64("uid", uid))
+
+	return a.userProvider.UserByID(ctx, uid)
+}
+
+// linkedDirectoryUser returns the existing local user if it belongs to the directory.
+func (a *Auth) linkedDirectoryUser(
+	ctx context.Context,
+	log *slog.Logger,
+	dir *models.LDAPDirectory,
+	user *models.User,
+) (*models.User, error) {
+	linked, err := a.directoryStorage.IsLDAPUser(ctx, dir.ID, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !linked {
+		log.Warn("local user of the directory entry is not linked", slog.Int64("uid", user.ID))
+		return nil, ErrIdentityConflict
+	}
+	return user, nil
+}
+
+// directoryRoles returns the roles mapped to the groups, group DNs are compared case-insensitively.
+func directoryRoles(groups []string, groupRoles []models.LDAPGroupRole) []string {
+	roles := []string{}
+	seen := make(map[string]bool)
+	for _, gr := range groupRoles {
+		if seen[gr.Role] {
+			continue
+		}
+		for _, group := range groups {
+			if strings.EqualFold(group, gr.GroupDN) {
+				roles = append(roles, gr.Role)
+				seen[gr.Role] = true
+				break
+			}
+		}
+	}
+	return roles
+}
@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/opaque"
+	"sso/internal/storage"
+	"strings"
+	"time"
+)
+
+var (
+	ErrEmailUnchanged          = errors.New("new email is the current email")
+	ErrInvalidEmailChangeToken = errors.New("invalid email change token")
+)
+
+const (
+	emailChangeSubject       = "Confirm your new email"
+	emailChangeNoticeSubject = "Your email is being changed"
+)
+
+// ChangeEmail starts changing the email of the user of the access token to newEmail.
+// The change takes effect when the token sent to the new email is confirmed
+// with ConfirmEmailChange, the current email is notified about the request.
+func (a *Auth) ChangeEmail(ctx context.Context, accessToken, password, newEmail string) error {
+	const op = "auth.ChangeEmail"
+	log := a.log.With(slog.String("op", op))
+
+	user, _, err := a.reauthenticate(ctx, log, accessToken, password)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("uid", user.ID))
+
+	if strings.EqualFold(user.Email, newEmail) {
+		log.Warn("new email is the current email")
+		return fmt.Errorf("%s: %w", op, ErrEmailUnchanged)
+	}
+	_, err = a.userProvider.User(ctx, newEmail)
+	if err == nil {
+		log.Warn("email already in use")
+		return fmt.Errorf("%s: %w", op, ErrUserExists)
+	}
+	if !errors.Is(err, storage.ErrUserNotFound) {
+		log.Error("failed to get user", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	token, hash, err := opaque.New()
+	if err != nil {
+		log.Error("failed to generate email change token", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	err = a.verificationStorage.SaveEmailChangeToken(ctx, &models.EmailChangeToken{
+		TokenHash: hash,
+		UserID:    user.ID,
+		OldEmail:  user.Email,
+		NewEmail:  newEmail,
+		ExpiresAt: time.Now().Add(a.cfg.EmailVerification.TTL),
+	})
+	if err != nil {
+		log.Error("failed to save email change token", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	body := fmt.Sprintf(
+		"Use the following token to confirm your new email: %s\n\nThe token expires in %s.",
+		token, a.cfg.EmailVerification.TTL,
+	)
+	if err := a.mailer.Send(ctx, newEmail, emailChangeSubject, body); err != nil {
+		log.Error("failed to send email change token", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	notice := fmt.Sprintf(
+		"A change of the email of your account to %s was requested. "+
+			"If it was not you, change your password.",
+		newEmail,
+	)
+	if err := a.mailer.Send(ctx, user.Email, emailChangeNoticeSubject, notice); err != nil {
+		log.Error("failed to notify the current email", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("email change requested")
+
+	return nil
+}
+
+// ConfirmEmailChange replaces the email of the user with the email the token was sent to,
+// unless the email of the user changed since the token was issued.
+func (a *Auth) ConfirmEmailChange(ctx context.Context, token string) error {
+	const op = "auth.ConfirmEmailChange"
+	log := a.log.With(slog.String("op", op))
+
+	change, err := a.verificationStorage.UseEmailChangeToken(ctx, opaque.Hash(token))
+	if err != nil {
+		if errors.Is(err, storage.ErrEmailChangeNotFound) {
+			log.Warn("email change token not found, used or expired")
+			return fmt.Errorf("%s: %w", op, ErrInvalidEmailChangeToken)
+		}
+		log.Error("failed to use email change token", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("uid", change.UserID))
+
+	if err := a.userSaver.ChangeEmail(ctx, change.UserID, change.OldEmail, change.NewEmail); err != nil {
+		if errors.Is(err, storage.ErrUserExists) {
+			log.Warn("email already in use")
+			return fmt.Errorf("%s: %w", op, ErrUserExists)
+		}
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Warn("email changed since the token was issued")
+			return fmt.Errorf("%s: %w", op, ErrInvalidEmailChangeToken)
+		}
+		log.Error("failed to change email", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("email changed")
+
+	return nil
+}
@@ -0,0 +1,220 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+	"sso/internal/storage"
+	"strconv"
+	"strings"
+)
+
+const (
+	TokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+	// TokenTypeUserID identifies the subject by user id, it is accepted only for impersonation.
+	TokenTypeUserID = "urn:sso:params:oauth:token-type:user_id"
+)
+
+var (
+	ErrInvalidTarget           = errors.New("audience not allowed")
+	ErrUnsupportedTokenType    = errors.New("unsupported token type")
+	ErrImpersonationNotAllowed = errors.New("impersonation not allowed")
+)
+
+// ExchangeToken exchanges a token for an access token of the audience app (RFC 8693).
+// The client must have a policy for the audience, which limits the granted scopes.
+//
+// For delegation the subject token is an access token the user obtained for the client's app
+// and the client is the actor. For impersonation the subject is a user id and the actor token
+// is an access token of an admin, the policy has to allow impersonation.
+// The act claim of the issued token identifies the actor, each exchange is audited.
+func (a *Auth) ExchangeToken(
+	ctx context.Context,
+	clientID string,
+	clientSecret string,
+	req *models.TokenExchangeRequest,
+) (*models.TokenPair, error) {
+	const op = "auth.ExchangeToken"
+	log := a.log.With(
+		slog.String("op", op),
+		slog.String("client_id", clientID),
+		slog.String("audience", req.Audience),
+	)
+
+	client, err := a.authenticateClient(ctx, log, clientID, clientSecret)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	audience, err := strconv.ParseInt(req.Audience, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, ErrInvalidTarget)
+	}
+	policy, err := a.exchangeStorage.TokenExchangePolicy(ctx, client.ClientID, audience)
+	if err != nil {
+		if errors.Is(err, storage.ErrPolicyNotFound) {
+			log.Warn("no token exchange policy for the audience")
+			return nil, fmt.Errorf("%s: %w", op, ErrInvalidTarget)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var (
+		subject      *models.User
+		subjectScope string
+		actor        = client.ClientID
+		actorUserID  *int64
+	)
+	switch req.SubjectTokenType {
+	case TokenTypeAccessToken:
+		if req.ActorToken != "" {
+			return nil, fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+		}
+		claims, err := a.userToken(ctx, req.SubjectToken, client.AppID)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		subject, err = a.userProvider.UserByID(ctx, claims.UID)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		subjectScope = claims.Scope
+	case TokenTypeUserID:
+		if !policy.AllowImpersonation {
+			log.Warn("impersonation is not allowed by the policy")
+			return nil, fmt.Errorf("%s: %w", op, ErrImpersonationNotAllowed)
+		}
+		if req.ActorTokenType != TokenTypeAccessToken {
+			return nil, fmt.Errorf("%s: %w", op, ErrUnsupportedTokenType)
+		}
+		admin, err := a.impersonator(ctx, log, req.ActorToken, client.AppID)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		uid, err := strconv.ParseInt(req.SubjectToken, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+		}
+		subject, err = a.userProvider.UserByID(ctx, uid)
+		if err != nil {
+			if errors.Is(err, storage.ErrUserNotFound) {
+				return nil, fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+			}
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		actor = strconv.FormatInt(admin.ID, 10)
+		actorUserID = &admin.ID
+	default:
+		return nil, fmt.Errorf("%s: %w", op, ErrUnsupportedTokenType)
+	}
+
+	log = log.With(
+		slog.Int64("uid", subject.ID),
+		slog.String("actor", actor),
+	)
+
+	granted, ok := exchangeScope(req.Scope, policy.Scopes, subjectScope)
+	if !ok {
+		log.Warn("scope not allowed", slog.String("scope", req.Scope))
+		return nil, fmt.Errorf("%s: %w", op, ErrInvalidScope)
+	}
+
+	app, err := a.appProvider.App(ctx, int(audience))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	key, err := a.signingKey(ctx, app)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	accessToken, jti, err := jwt.NewDelegatedToken(subject, app, key, granted, actor, a.cfg.TokenTTL)
+	if err != nil {
+		log.Error("failed to issue token", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// a token is never handed out without its audit entry
+	err = a.exchangeStorage.SaveTokenExchangeAudit(ctx, &models.TokenExchangeAudit{
+		TokenID:       jti,
+		ClientID:      client.ClientID,
+		SubjectUserID: subject.ID,
+		ActorUserID:   actorUserID,
+		AudienceAppID: app.ID,
+		Scope:         granted,
+	})
+	if err != nil {
+		log.Error("failed to save audit entry", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("token exchanged", slog.Bool("impersonation", actorUserID != nil))
+
+	return &models.TokenPair{
+		AccessToken: accessToken,
+		ExpiresIn:   a.cfg.TokenTTL,
+		Scope:       granted,
+	}, nil
+}
+
+// userToken returns the claims of a valid access token issued to a user for the app.
+// Tokens with an act claim are rejected, an exchanged token is neither exchanged again
+// nor does it act as an admin, see verifyAccountToken.
+func (a *Auth) userToken(ctx context.Context, accessToken string, appID int64) (*jwt.Claims, error) {
+	claims, err := a.verifyAccountToken(ctx, accessToken)
+	if err != nil {
+		if errors.Is(err, ErrInvalidAccessToken) {
+			return nil, ErrInvalidGrant
+		}
+		return nil, err
+	}
+	if claims.UID == 0 || claims.AppID != appID {
+		return nil, ErrInvalidGrant
+	}
+	return claims, nil
+}
+
+// impersonator returns the admin the actor token was issued to.
+func (a *Auth) impersonator(ctx context.Context, log *slog.Logger, actorToken string, appID int64) (*models.User, error) {
+	claims, err := a.userToken(ctx, actorToken, appID)
+	if err != nil {
+		return nil, err
+	}
+	admin, err := a.userProvider.UserByID(ctx, claims.UID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return nil, ErrInvalidGrant
+		}
+		return nil, err
+	}
+	if !admin.IsAdmin {
+		log.Warn("impersonation attempt by a non admin", slog.Int64("actor_uid", admin.ID))
+		return nil, ErrImpersonationNotAllowed
+	}
+	return admin, nil
+}
+
+// exchangeScope returns the requested scope, or every scope the policy allows if none
+// was requested, but never a broader scope than the one of the subject token.
+func exchangeScope(requested, allowed, subjectScope string) (string, bool) {
+	granted, ok := grantScope(requested, allowed)
+	if !ok || subjectScope == "" {
+		return granted, ok
+	}
+
+	var narrowed []string
+	for _, s := range strings.Fields(granted) {
+		if hasScope(subjectScope, s) {
+			narrowed = append(narrowed, s)
+		} else if requested != "" {
+			return "", false
+		}
+	}
+	if len(narrowed) == 0 {
+		return "", false
+	}
+	return strings.Join(narrowed, " "), true
+}
@@ -0,0 +1,233 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/oidc"
+	"sso/internal/lib/opaque"
+	"sso/internal/storage"
+	"time"
+)
+
+var (
+	ErrUnknownProvider       = errors.New("unknown identity provider")
+	ErrInvalidFederatedLogin = errors.New("invalid or expired federated login")
+	ErrFederationFailed      = errors.New("upstream authentication failed")
+	ErrIdentityConflict      = errors.New("account exists, sign in to link the identity")
+	ErrIdentityLinked        = errors.New("identity already linked")
+	ErrIdentityNotLinked     = errors.New("identity not linked")
+)
+
+// BeginFederatedLogin starts a login at an upstream OpenID provider of the app.
+// The user is sent to authURL, the provider redirects back to redirectURI with
+// a code and the state, which are passed to FinishFederatedLogin.
+func (a *Auth) BeginFederatedLogin(
+	ctx context.Context,
+	appID int,
+	providerName string,
+	redirectURI string,
+) (authURL string, state string, err error) {
+	const op = "auth.BeginFederatedLogin"
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", appID),
+		slog.String("provider", providerName),
+	)
+
+	app, err := a.appProvider.App(ctx, appID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return "", "", fmt.Errorf("%s: %w", op, ErrInvalidAppId)
+		}
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+	if err := a.checkRedirectURI(ctx, app, redirectURI); err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	provider, err := a.federationStorage.IdentityProvider(ctx, app.ID, providerName)
+	if err != nil {
+		if errors.Is(err, storage.ErrProviderNotFound) {
+			return "", "", fmt.Errorf("%s: %w", op, ErrUnknownProvider)
+		}
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	metadata, err := oidc.Discover(ctx, a.federationClient, provider.Issuer)
+	if err != nil {
+		log.Error("failed to discover provider", slog.String("error", err.Error()))
+		return "", "", fmt.Errorf("%s: %w", op, ErrFederationFailed)
+	}
+
+	state, stateHash, err := opaque.New()
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+	nonce, err := opaque.NewID()
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+	codeVerifier, _, err := opaque.New()
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	err = a.federationStorage.SaveFederatedLogin(ctx, &models.FederatedLogin{
+		StateHash:    stateHash,
+		ProviderID:   provider.ID,
+		RedirectURI:  redirectURI,
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		ExpiresAt:    time.Now().Add(a.cfg.Federation.LoginTTL),
+	})
+	if err != nil {
+		log.Error("failed to save federated login", slog.String("error", err.Error()))
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	authURL = metadata.AuthCodeURL(providerClient(provider), redirectURI, provider.Scopes, state, nonce, codeVerifier)
+	return authURL, state, nil
+}
+
+// FinishFederatedLogin redeems the upstream code, maps the upstream account to a local user
+// and issues the same tokens as Login. The upstream provider is responsible for the second factor.
+func (a *Auth) FinishFederatedLogin(ctx context.Context, state, code string) (*models.TokenPair, error) {
+	const op = "auth.FinishFederatedLogin"
+	log := a.log.With(slog.String("op", op))
+
+	login, err := a.federationStorage.TakeFederatedLogin(ctx, opaque.Hash(state))
+	if err != nil {
+		if errors.Is(err, storage.ErrFederatedLoginNotFound) {
+			return nil, fmt.Errorf("%s: %w", op, ErrInvalidFederatedLogin)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	provider, err := a.federationStorage.IdentityProviderByID(ctx, login.ProviderID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(
+		slog.Int64("app_id", provider.AppID),
+		slog.String("provider", provider.Name),
+	)
+
+	claims, err := a.upstreamClaims(ctx, provider, login, code)
+	if err != nil {
+		log.Warn("upstream authentication failed", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("%s: %w", op, ErrFederationFailed)
+	}
+
+	log = log.With(slog.String("subject", claims.Subject))
+
+	user, err := a.federatedUser(ctx, log, provider, claims)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	app, err := a.appProvider.App(ctx, int(provider.AppID))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	pair, err := a.startSession(ctx, user, app, "")
+	if err != nil {
+		log.Error("failed to issue tokens", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("user logged in with upstream provider", slog.Int64("uid", user.ID))
+
+	return pair, nil
+}
+
+func (a *Auth) upstreamClaims(
+	ctx context.Context,
+	provider *models.IdentityProvider,
+	login *models.FederatedLogin,
+	code string,
+) (*oidc.Claims, error) {
+	metadata, err := oidc.Discover(ctx, a.federationClient, provider.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	client := providerClient(provider)
+	rawIDToken, err := metadata.Exchange(ctx, a.federationClient, client, code, login.RedirectURI, login.CodeVerifier)
+	if err != nil {
+		return nil, err
+	}
+	return metadata.VerifyIDToken(ctx, a.federationClient, client, rawIDToken, login.Nonce)
+}
+
+// federatedUser returns the user the upstream account is linked to. The user is provisioned
+// just in time if nobody has the email of an account without a link yet. An existing user
+// is never linked by email, any provider of any app could take the account over with it,
+// so its owner has to sign in and link the identity.
+func (a *Auth) federatedUser(
+	ctx context.Context,
+	log *slog.Logger,
+	provider *models.IdentityProvider,
+	claims *oidc.Claims,
+) (*models.User, error) {
+	identity, err := a.federationStorage.UserIdentity(ctx, provider.ID, claims.Subject)
+	if err == nil {
+		return a.userProvider.UserByID(ctx, identity.UserID)
+	}
+	if !errors.Is(err, storage.ErrIdentityNotFound) {
+		return nil, err
+	}
+
+	// only an email the provider has verified is trusted, otherwise anyone could sign in
+	// to an existing account by registering its email upstream
+	if claims.Email == "" || !claims.EmailVerified {
+		log.Warn("upstream email is not verified")
+		return nil, ErrEmailNotVerified
+	}
+
+	user, err := a.userProvider.User(ctx, claims.Email)
+	if err == nil {
+		log.Warn("account with the email exists", slog.Int64("uid", user.ID))
+		return nil, ErrIdentityConflict
+	}
+	if !errors.Is(err, storage.ErrUserNotFound) {
+		return nil, err
+	}
+
+	// the user signs in upstream only, the local password is unusable
+	password, _, err := opaque.New()
+	if err != nil {
+		return nil, err
+	}
+	passHash, err := a.passwords.Hash([]byte(password))
+	if err != nil {
+		return nil, err
+	}
+
+	uid, err := a.federationStorage.SaveFederatedUser(ctx, claims.Email, passHash, provider.ID, claims.Subject)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserExists) || errors.Is(err, storage.ErrIdentityExists) {
+			// provisioned by a concurrent login
+			identity, err := a.federationStorage.UserIdentity(ctx, provider.ID, claims.Subject)
+			if err != nil {
+				return nil, ErrIdentityConflict
+			}
+			return a.userProvider.UserByID(ctx, identity.UserID)
+		}
+		log.Error("failed to provision user", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	log.Info("user provisioned", slog.Int64("uid", uid))
+
+	return a.userProvider.UserByID(ctx, uid)
+}
+
+func providerClient(provider *models.IdentityProvider) oidc.Client {
+	return oidc.Client{
+		ID:     provider.ClientID,
+		Secret: provider.ClientSecret,
+	}
+}
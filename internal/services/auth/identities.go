@@ -0,0 +1,209 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/opaque"
+	"sso/internal/storage"
+)
+
+// LinkIdentity links the upstream account of a federated login the user has begun
+// to the user of the access token, the login is completed with its state and code.
+// The current password is required, so a stolen access token alone is not enough
+// to attach another account for later sign-ins.
+func (a *Auth) LinkIdentity(ctx context.Context, accessToken, password, state, code string) error {
+	const op = "auth.LinkIdentity"
+	log := a.log.With(slog.String("op", op))
+
+	user, _, err := a.reauthenticate(ctx, log, accessToken, password)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("uid", user.ID))
+
+	login, err := a.federationStorage.TakeFederatedLogin(ctx, opaque.Hash(state))
+	if err != nil {
+		if errors.Is(err, storage.ErrFederatedLoginNotFound) {
+			return fmt.Errorf("%s: %w", op, ErrInvalidFederatedLogin)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	provider, err := a.federationStorage.IdentityProviderByID(ctx, login.ProviderID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.String("provider", provider.Name))
+
+	claims, err := a.upstreamClaims(ctx, provider, login, code)
+	if err != nil {
+		log.Warn("upstream authentication failed", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, ErrFederationFailed)
+	}
+
+	err = a.federationStorage.SaveUserIdentity(ctx, &models.UserIdentity{
+		UserID:     user.ID,
+		ProviderID: provider.ID,
+		Subject:    claims.Subject,
+	})
+	if err != nil {
+		if !errors.Is(err, storage.ErrIdentityExists) {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		identity, err := a.federationStorage.UserIdentity(ctx, provider.ID, claims.Subject)
+		if err == nil && identity.UserID == user.ID {
+			return nil
+		}
+		// the account is linked to another user or the user has linked another account
+		log.Warn("identity conflicts with an existing link", slog.String("subject", claims.Subject))
+		return fmt.Errorf("%s: %w", op, ErrIdentityLinked)
+	}
+
+	log.Info("identity linked", slog.String("subject", claims.Subject))
+
+	return nil
+}
+
+// UnlinkIdentity removes the link to the account at the provider of the app the access token
+// was issued for. The current password is required, it also remains to sign in with.
+func (a *Auth) UnlinkIdentity(ctx context.Context, accessToken, password, providerName string) error {
+	const op = "auth.UnlinkIdentity"
+	log := a.log.With(
+		slog.String("op", op),
+		slog.String("provider", providerName),
+	)
+
+	user, claims, err := a.reauthenticate(ctx, log, accessToken, password)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("uid", user.ID))
+
+	provider, err := a.federationStorage.IdentityProvider(ctx, claims.AppID, providerName)
+	if err != nil {
+		if errors.Is(err, storage.ErrProviderNotFound) {
+			return fmt.Errorf("%s: %w", op, ErrUnknownProvider)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.federationStorage.DeleteUserIdentity(ctx, user.ID, provider.ID); err != nil {
+		if errors.Is(err, storage.ErrIdentityNotFound) {
+			return fmt.Errorf("%s: %w", op, ErrIdentityNotLinked)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("identity unlinked")
+
+	return nil
+}
+
+// reauthenticate returns the user of the access token and its claims if the password
+// is the current password of the user, see confirmPassword.
+func (a *Auth) reauthenticate(
+	ctx context.Context,
+	log *slog.Logger,
+	accessToken string,
+	password string,
+) (*models.User, *jwt.Claims, error) {
+	user, claims, err := a.tokenUser(ctx, log, accessToken)
+	if err != nil {
+		return nil, nil, err
+	}
+	log = log.With(slog.Int64("uid", user.ID))
+
+	app, err := a.appProvider.App(ctx, int(claims.AppID))
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return nil, nil, ErrInvalidAccessToken
+		}
+		return nil, nil, err
+	}
+	user, err = a.confirmPassword(ctx, log, app, user, password)
+	if err != nil {
+		return nil, nil, err
+	}
+	return user, claims, nil
+}
+
+// confirmPassword checks the password of the user like the sign in to the app does,
+// against the directory for users of the directory of the app. Failures count as failed
+// logins, so the password is guessed no faster than at the sign in.
+func (a *Auth) confirmPassword(
+	ctx context.Context,
+	log *slog.Logger,
+	app *models.App,
+	user *models.User,
+	password string,
+) (*models.User, error) {
+	authenticated, _, err := a.authenticateThrottled(ctx, log, app, user.Email, password)
+	if err != nil {
+		return nil, err
+	}
+	if authenticated.ID != user.ID {
+		log.Warn("password authenticates another user", slog.Int64("other_uid", authenticated.ID))
+		return nil, ErrInvalidCredentials
+	}
+	return authenticated, nil
+}
+
+// reauthenticateFactor is reauthenticate, but users with TOTP enabled may confirm
+// with a TOTP code instead of the password.
+func (a *Auth) reauthenticateFactor(
+	ctx context.Context,
+	log *slog.Logger,
+	accessToken string,
+	password string,
+	code string,
+) (*models.User, *jwt.Claims, error) {
+	if code == "" {
+		return a.reauthenticate(ctx, log, accessToken, password)
+	}
+
+	user, claims, err := a.tokenUser(ctx, log, accessToken)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !user.TOTPEnabled {
+		return nil, nil, ErrMFANotEnrolled
+	}
+	if err := a.checkMFAAttempt(ctx, log, user.Email); err != nil {
+		return nil, nil, err
+	}
+	ok, err := a.verifyTOTP(ctx, user, code)
+	if err != nil {
+		log.Error("failed to verify totp code", slog.String("error", err.Error()))
+		return nil, nil, err
+	}
+	if !ok {
+		log.Info("invalid totp code", slog.Int64("uid", user.ID))
+		a.mfaFailed(ctx, log, user.Email)
+		return nil, nil, ErrInvalidMFACode
+	}
+	a.mfaPassed(ctx, log, user.Email)
+	return user, claims, nil
+}
+
+// tokenUser returns the user of the access token and its claims, see verifyAccountToken.
+func (a *Auth) tokenUser(ctx context.Context, log *slog.Logger, accessToken string) (*models.User, *jwt.Claims, error) {
+	claims, err := a.verifyAccountToken(ctx, accessToken)
+	if err != nil {
+		log.Warn("failed to authenticate", slog.String("error", err.Error()))
+		return nil, nil, err
+	}
+	user, err := a.userProvider.UserByID(ctx, claims.UID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return nil, nil, ErrInvalidAccessToken
+		}
+		return nil, nil, err
+	}
+	return user, claims, nil
+}
@@ -0,0 +1,276 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwk"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/opaque"
+	"sso/internal/storage"
+	"strings"
+	"time"
+)
+
+// pemPrefix starts the unsealed private keys.
+const pemPrefix = "-----BEGIN"
+
+// JWKS returns the public keys of all apps signing with asymmetric keys.
+func (a *Auth) JWKS(ctx context.Context) (*jwk.Set, error) {
+	const op = "auth.JWKS"
+	log := a.log.With(slog.String("op", op))
+
+	keys, err := a.keyStorage.SigningKeys(ctx)
+	if err != nil {
+		log.Error("failed to get signing keys", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	set := &jwk.Set{Keys: make([]jwk.Key, 0, len(keys))}
+	for _, key := range keys {
+		k, err := jwk.FromPublicKey(key.Kid, key.Algorithm, key.PublicKey)
+		if err != nil {
+			log.Error("failed to build jwk", slog.String("kid", key.Kid), slog.String("error", err.Error()))
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		set.Keys = append(set.Keys, k)
+	}
+
+	return set, nil
+}
+
+// CreateSigningKey generates and stores a new key pair for the app using its signing algorithm.
+// The key is published right away and used for signing from activatesAt.
+func (a *Auth) CreateSigningKey(ctx context.Context, app *models.App, activatesAt time.Time) (*models.SigningKey, error) {
+	const op = "auth.CreateSigningKey"
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("app_id", app.ID),
+		slog.String("alg", app.SigningAlg),
+	)
+
+	key, err := a.newSigningKey(app, activatesAt)
+	if err != nil {
+		log.Error("failed to generate key", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if err := a.keyStorage.SaveSigningKey(ctx, key); err != nil {
+		log.Error("failed to save key", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("signing key created", slog.String("kid", key.Kid), slog.Time("activates_at", activatesAt))
+
+	return key, nil
+}
+
+// newSigningKey generates a key pair for the app, the private key is sealed for storing
+// if there is an encryption key.
+func (a *Auth) newSigningKey(app *models.App, activatesAt time.Time) (*models.SigningKey, error) {
+	privatePEM, publicPEM, err := jwk.Generate(app.SigningAlg)
+	if err != nil {
+		return nil, err
+	}
+	sealed := privatePEM
+	if a.secrets != nil {
+		sealed, err = a.secrets.Seal(privatePEM)
+		if err != nil {
+			return nil, err
+		}
+	}
+	kid, err := opaque.NewID()
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.SigningKey{
+		Kid:         kid,
+		AppID:       app.ID,
+		Algorithm:   app.SigningAlg,
+		PrivateKey:  sealed,
+		PublicKey:   publicPEM,
+		ActivatesAt: activatesAt,
+	}, nil
+}
+
+// RotateSigningKey creates a new key for the app and schedules retirement of the
+// previous keys. The new key is published for the configured delay before it is used
+// for signing, unless immediate is set. Previous keys stay published until every
+// token they could have signed has expired, or are retired right away if immediate is set.
+func (a *Auth) RotateSigningKey(ctx context.Context, appID int, immediate bool) (*models.SigningKey, error) {
+	const op = "auth.RotateSigningKey"
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", appID),
+	)
+
+	app, err := a.appProvider.App(ctx, appID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return nil, fmt.Errorf("%s: %w", op, ErrInvalidAppId)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if !jwk.IsAsymmetric(app.SigningAlg) {
+		return nil, fmt.Errorf("%s: %w: %s", op, jwk.ErrUnsupportedAlg, app.SigningAlg)
+	}
+
+	activatesAt := time.Now()
+	if !immediate {
+		activatesAt = activatesAt.Add(a.cfg.KeyRotation.PublishDelay)
+	}
+
+	key, err := a.CreateSigningKey(ctx, app, activatesAt)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// a key replaced immediately is presumed compromised, the tokens it signed are rejected
+	retiresAt := activatesAt
+	if !immediate {
+		retiresAt = activatesAt.Add(a.cfg.TokenTTL)
+	}
+	if err := a.keyStorage.RetireSigningKeys(ctx, app.ID, key.Kid, retiresAt); err != nil {
+		log.Error("failed to retire previous keys", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("signing key rotated", slog.String("kid", key.Kid), slog.Time("previous_retire_at", retiresAt))
+
+	return key, nil
+}
+
+// RotateSigningKeys periodically rotates keys of apps whose signing key
+// is older than the configured max age or uses a different algorithm
+// than the app, until ctx is done.
+func (a *Auth) RotateSigningKeys(ctx context.Context) {
+	const op = "auth.RotateSigningKeys"
+	log := a.log.With(slog.String("op", op))
+
+	ticker := time.NewTicker(a.cfg.KeyRotation.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := a.rotateDueKeys(ctx); err != nil {
+				log.Error("failed to rotate signing keys", slog.String("error", err.Error()))
+			}
+		}
+	}
+}
+
+func (a *Auth) rotateDueKeys(ctx context.Context) error {
+	apps, err := a.appProvider.Apps(ctx)
+	if err != nil {
+		return err
+	}
+	keys, err := a.keyStorage.SigningKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	pending := make(map[int64]bool)
+	for _, key := range keys {
+		if key.ActivatesAt.After(now) {
+			pending[key.AppID] = true
+		}
+	}
+
+	for _, app := range apps {
+		if !jwk.IsAsymmetric(app.SigningAlg) || pending[app.ID] {
+			continue
+		}
+
+		current, err := a.keyStorage.SigningKey(ctx, app.ID)
+		if err != nil && !errors.Is(err, storage.ErrSigningKeyNotFound) {
+			return err
+		}
+		// keys are created on first use, nothing to rotate yet
+		if current == nil {
+			continue
+		}
+		if current.Algorithm == app.SigningAlg && now.Sub(current.ActivatesAt) < a.cfg.KeyRotation.MaxAge {
+			continue
+		}
+
+		if _, err := a.RotateSigningKey(ctx, int(app.ID), false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// signingKey returns the key tokens of the app are signed with, with its private key opened,
+// or nil if the app signs with its shared secret. The first key of an app is created on first use.
+func (a *Auth) signingKey(ctx context.Context, app *models.App) (*models.SigningKey, error) {
+	if !jwk.IsAsymmetric(app.SigningAlg) {
+		return nil, nil
+	}
+
+	key, err := a.keyStorage.SigningKey(ctx, app.ID)
+	if errors.Is(err, storage.ErrSigningKeyNotFound) {
+		key, err = a.newSigningKey(app, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		// another request may have created the key meanwhile, which is used instead
+		key, err = a.keyStorage.SaveFirstSigningKey(ctx, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	privatePEM, err := a.openPrivateKey(key.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("key %s: %w", key.Kid, err)
+	}
+	key.PrivateKey = privatePEM
+	return key, nil
+}
+
+// openPrivateKey decrypts a sealed private key. Keys stored before the keys were sealed
+// or without an encryption key are plain PEM and returned as they are.
+func (a *Auth) openPrivateKey(privateKey string) (string, error) {
+	if strings.HasPrefix(privateKey, pemPrefix) {
+		return privateKey, nil
+	}
+	if a.secrets == nil {
+		return "", errors.New("private key is sealed, but there is no encryption key")
+	}
+	return a.secrets.Open(privateKey)
+}
+
+func (a *Auth) parseToken(ctx context.Context, accessToken string) (*jwt.Claims, error) {
+	return jwt.Parse(accessToken, func(appID int64, kid string) (string, interface{}, error) {
+		if kid == "" {
+			app, err := a.appProvider.App(ctx, int(appID))
+			if err != nil {
+				return "", nil, err
+			}
+			// the secret of an app which moved to a key pair may still be known to its clients
+			if jwk.IsAsymmetric(app.SigningAlg) {
+				return "", nil, fmt.Errorf("app %d signs with %s, the token has no kid", appID, app.SigningAlg)
+			}
+			return jwk.AlgHS256, []byte(app.Secret), nil
+		}
+
+		key, err := a.keyStorage.SigningKeyByKid(ctx, kid)
+		if err != nil {
+			return "", nil, err
+		}
+		if key.AppID != appID {
+			return "", nil, fmt.Errorf("key %s does not belong to app %d", kid, appID)
+		}
+		pub, err := jwk.ParsePublicKey(key.PublicKey)
+		if err != nil {
+			return "", nil, err
+		}
+		return key.Algorithm, pub, nil
+	})
+}
@@ -0,0 +1,328 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+	"io"
+	"log/slog"
+	"sort"
+	"sso/internal/config"
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwk"
+	"sso/internal/lib/secretbox"
+	"sso/internal/storage"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	publishDelay = time.Hour
+	tokenTTL     = 15 * time.Minute
+)
+
+// memKeys keeps apps and signing keys in memory. Its clock runs ahead of the real time
+// by shift, which lets the tests move past the activation and the retirement of keys.
+type memKeys struct {
+	mu    sync.Mutex
+	shift time.Duration
+	apps  map[int64]*models.App
+	keys  []models.SigningKey
+}
+
+func (m *memKeys) now() time.Time {
+	return time.Now().Add(m.shift)
+}
+
+func (m *memKeys) published(key *models.SigningKey, now time.Time) bool {
+	return key.RetiresAt == nil || key.RetiresAt.After(now)
+}
+
+func (m *memKeys) App(_ context.Context, appID int) (*models.App, error) {
+	app, ok := m.apps[int64(appID)]
+	if !ok {
+		return nil, storage.ErrAppNotFound
+	}
+	return app, nil
+}
+
+func (m *memKeys) Apps(_ context.Context) ([]models.App, error) {
+	apps := make([]models.App, 0, len(m.apps))
+	for _, app := range m.apps {
+		apps = append(apps, *app)
+	}
+	sort.Slice(apps, func(i, j int) bool { return apps[i].ID < apps[j].ID })
+	return apps, nil
+}
+
+func (m *memKeys) PasswordPolicy(_ context.Context, _ int64) (*models.PasswordPolicy, error) {
+	return nil, storage.ErrPasswordPolicyNotFound
+}
+
+func (m *memKeys) MaxPasswordHistorySize(_ context.Context) (int, error) {
+	return 0, nil
+}
+
+func (m *memKeys) SaveSigningKey(_ context.Context, key *models.SigningKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key.ID = int64(len(m.keys) + 1)
+	m.keys = append(m.keys, *key)
+	return nil
+}
+
+func (m *memKeys) SaveFirstSigningKey(ctx context.Context, key *models.SigningKey) (*models.SigningKey, error) {
+	if current, err := m.SigningKey(ctx, key.AppID); err == nil {
+		return current, nil
+	}
+	if err := m.SaveSigningKey(ctx, key); err != nil {
+		return nil, err
+	}
+	saved := *key
+	return &saved, nil
+}
+
+func (m *memKeys) SigningKey(_ context.Context, appID int64) (*models.SigningKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	var current *models.SigningKey
+	for i := range m.keys {
+		key := &m.keys[i]
+		if key.AppID != appID || key.ActivatesAt.After(now) || !m.published(key, now) {
+			continue
+		}
+		if current == nil || !key.ActivatesAt.Before(current.ActivatesAt) {
+			current = key
+		}
+	}
+	if current == nil {
+		return nil, storage.ErrSigningKeyNotFound
+	}
+	key := *current
+	return &key, nil
+}
+
+func (m *memKeys) SigningKeyByKid(_ context.Context, kid string) (*models.SigningKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, key := range m.keys {
+		if key.Kid == kid && m.published(&key, m.now()) {
+			return &key, nil
+		}
+	}
+	return nil, storage.ErrSigningKeyNotFound
+}
+
+func (m *memKeys) SigningKeys(_ context.Context) ([]models.SigningKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var keys []models.SigningKey
+	for _, key := range m.keys {
+		if m.published(&key, m.now()) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (m *memKeys) RetireSigningKeys(_ context.Context, appID int64, exceptKid string, retiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.keys {
+		key := &m.keys[i]
+		if key.AppID == appID && key.Kid != exceptKid && key.RetiresAt == nil {
+			key.RetiresAt = &retiresAt
+		}
+	}
+	return nil
+}
+
+func newKeysAuth(t *testing.T, apps ...*models.App) (*Auth, *memKeys) {
+	t.Helper()
+
+	raw := make([]byte, 32)
+	_, err := rand.Read(raw)
+	require.NoError(t, err)
+	secrets, err := secretbox.New(base64.StdEncoding.EncodeToString(raw))
+	require.NoError(t, err)
+
+	keys := &memKeys{apps: make(map[int64]*models.App)}
+	for _, app := range apps {
+		keys.apps[app.ID] = app
+	}
+
+	return &Auth{
+		log:         slog.New(slog.NewTextHandler(io.Discard, nil)),
+		appProvider: keys,
+		keyStorage:  keys,
+		secrets:     secrets,
+		cfg: &config.Config{
+			TokenTTL: tokenTTL,
+			KeyRotation: config.KeyRotationConfig{
+				MaxAge:       720 * time.Hour,
+				PublishDelay: publishDelay,
+			},
+		},
+	}, keys
+}
+
+func publishedKids(t *testing.T, a *Auth) []string {
+	t.Helper()
+
+	set, err := a.JWKS(context.Background())
+	require.NoError(t, err)
+	kids := make([]string, 0, len(set.Keys))
+	for _, key := range set.Keys {
+		kids = append(kids, key.Kid)
+	}
+	return kids
+}
+
+func TestRotateSigningKey(t *testing.T) {
+	ctx := context.Background()
+	app := &models.App{ID: 1, SigningAlg: jwk.AlgES256}
+
+	t.Run("publish before activate and overlap", func(t *testing.T) {
+		a, keys := newKeysAuth(t, app)
+
+		old, err := a.signingKey(ctx, app)
+		require.NoError(t, err)
+
+		rotated, err := a.RotateSigningKey(ctx, int(app.ID), false)
+		require.NoError(t, err)
+		require.WithinDuration(t, time.Now().Add(publishDelay), rotated.ActivatesAt, time.Minute)
+
+		// the new key is published, tokens are still signed with the old one
+		require.ElementsMatch(t, []string{old.Kid, rotated.Kid}, publishedKids(t, a))
+		current, err := a.signingKey(ctx, app)
+		require.NoError(t, err)
+		require.Equal(t, old.Kid, current.Kid)
+
+		// after the activation the old key stays published until its tokens expired
+		keys.shift = publishDelay + time.Second
+		current, err = a.signingKey(ctx, app)
+		require.NoError(t, err)
+		require.Equal(t, rotated.Kid, current.Kid)
+		require.ElementsMatch(t, []string{old.Kid, rotated.Kid}, publishedKids(t, a))
+
+		keys.shift = publishDelay + tokenTTL + time.Second
+		require.Equal(t, []string{rotated.Kid}, publishedKids(t, a))
+	})
+
+	t.Run("immediate", func(t *testing.T) {
+		a, _ := newKeysAuth(t, app)
+
+		old, err := a.signingKey(ctx, app)
+		require.NoError(t, err)
+
+		rotated, err := a.RotateSigningKey(ctx, int(app.ID), true)
+		require.NoError(t, err)
+
+		// the old key is retired at once, tokens it signed no longer verify
+		require.Equal(t, []string{rotated.Kid}, publishedKids(t, a))
+		_, err = a.keyStorage.SigningKeyByKid(ctx, old.Kid)
+		require.ErrorIs(t, err, storage.ErrSigningKeyNotFound)
+
+		current, err := a.signingKey(ctx, app)
+		require.NoError(t, err)
+		require.Equal(t, rotated.Kid, current.Kid)
+	})
+
+	t.Run("symmetric app", func(t *testing.T) {
+		a, _ := newKeysAuth(t, &models.App{ID: 2, SigningAlg: jwk.AlgHS256})
+
+		_, err := a.RotateSigningKey(ctx, 2, false)
+		require.ErrorIs(t, err, jwk.ErrUnsupportedAlg)
+	})
+}
+
+func TestSigningKey_SealsPrivateKey(t *testing.T) {
+	ctx := context.Background()
+	app := &models.App{ID: 1, SigningAlg: jwk.AlgES256}
+	a, keys := newKeysAuth(t, app)
+
+	key, err := a.signingKey(ctx, app)
+	require.NoError(t, err)
+	_, err = jwk.ParsePrivateKey(key.PrivateKey)
+	require.NoError(t, err)
+
+	require.Len(t, keys.keys, 1)
+	require.NotContains(t, keys.keys[0].PrivateKey, pemPrefix)
+}
+
+func TestRotateDueKeys(t *testing.T) {
+	ctx := context.Background()
+	fresh := &models.App{ID: 1, SigningAlg: jwk.AlgES256}
+	old := &models.App{ID: 2, SigningAlg: jwk.AlgES256}
+	switched := &models.App{ID: 3, SigningAlg: jwk.AlgES256}
+	unused := &models.App{ID: 4, SigningAlg: jwk.AlgES256}
+	symmetric := &models.App{ID: 5, SigningAlg: jwk.AlgHS256}
+	a, keys := newKeysAuth(t, fresh, old, switched, unused, symmetric)
+
+	for _, app := range []*models.App{fresh, old, switched} {
+		_, err := a.signingKey(ctx, app)
+		require.NoError(t, err)
+	}
+	keys.keys[1].ActivatesAt = time.Now().Add(-a.cfg.KeyRotation.MaxAge - time.Hour)
+	switched.SigningAlg = jwk.AlgRS256
+
+	require.NoError(t, a.rotateDueKeys(ctx))
+
+	rotated := make(map[int64]int)
+	for _, key := range keys.keys {
+		rotated[key.AppID]++
+	}
+	require.Equal(t, map[int64]int{fresh.ID: 1, old.ID: 2, switched.ID: 2}, rotated)
+	require.Equal(t, jwk.AlgRS256, keys.keys[len(keys.keys)-1].Algorithm)
+
+	// the pending keys are not rotated again before they activate
+	require.NoError(t, a.rotateDueKeys(ctx))
+	require.Len(t, keys.keys, 5)
+}
+
+func TestParseToken_KidlessToken(t *testing.T) {
+	ctx := context.Background()
+	const secret = "old-secret"
+
+	// a token signed with the app secret, as the apps were signed before the key pairs
+	forge := func(t *testing.T, appID int64) string {
+		t.Helper()
+
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"jti":    "forged",
+			"uid":    1,
+			"email":  "victim@sso.test",
+			"app_id": appID,
+			"exp":    time.Now().Add(time.Hour).Unix(),
+		})
+		signed, err := token.SignedString([]byte(secret))
+		require.NoError(t, err)
+		return signed
+	}
+
+	t.Run("symmetric app", func(t *testing.T) {
+		a, _ := newKeysAuth(t, &models.App{ID: 1, Secret: secret, SigningAlg: jwk.AlgHS256})
+
+		claims, err := a.parseToken(ctx, forge(t, 1))
+		require.NoError(t, err)
+		require.Equal(t, int64(1), claims.UID)
+	})
+
+	t.Run("asymmetric app", func(t *testing.T) {
+		for _, alg := range []string{jwk.AlgRS256, jwk.AlgES256, jwk.AlgEdDSA} {
+			a, _ := newKeysAuth(t, &models.App{ID: 2, Secret: secret, SigningAlg: alg})
+
+			_, err := a.parseToken(ctx, forge(t, 2))
+			require.Error(t, err, alg)
+		}
+	})
+}
@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/lib/opaque"
+	"sso/internal/storage"
+	"time"
+)
+
+// Logout revokes the access token until it expires.
+// If refreshToken is not empty, the refresh token family it belongs to is revoked as well.
+func (a *Auth) Logout(ctx context.Context, accessToken, refreshToken string) error {
+	const op = "auth.Logout"
+	log := a.log.With(slog.String("op", op))
+
+	claims, err := a.parseToken(ctx, accessToken)
+	if err != nil {
+		log.Warn("invalid access token", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, ErrInvalidAccessToken)
+	}
+
+	log = log.With(slog.Int64("uid", claims.UID))
+	log.Info("logging out user")
+
+	if err := a.revocation.RevokeToken(ctx, claims.ID, claims.ExpiresAt); err != nil {
+		log.Error("failed to revoke access token", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if refreshToken != "" {
+		token, err := a.tokenStorage.RefreshToken(ctx, opaque.Hash(refreshToken))
+		if err != nil {
+			if errors.Is(err, storage.ErrRefreshTokenNotFound) {
+				return fmt.Errorf("%s: %w", op, ErrInvalidToken)
+			}
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		if token.UserID != claims.UID {
+			log.Warn("refresh token belongs to another user")
+			return fmt.Errorf("%s: %w", op, ErrInvalidToken)
+		}
+		if err := a.tokenStorage.RevokeRefreshTokenFamily(ctx, token.FamilyID); err != nil {
+			log.Error("failed to revoke refresh token", slog.String("error", err.Error()))
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	log.Info("user logged out")
+
+	return nil
+}
+
+// IsTokenRevoked reports whether a valid access token was revoked by Logout
+// or by a password change of its user.
+func (a *Auth) IsTokenRevoked(ctx context.Context, accessToken string) (bool, error) {
+	const op = "auth.IsTokenRevoked"
+	log := a.log.With(slog.String("op", op))
+
+	claims, err := a.parseToken(ctx, accessToken)
+	if err != nil {
+		log.Warn("invalid access token", slog.String("error", err.Error()))
+		return false, fmt.Errorf("%s: %w", op, ErrInvalidAccessToken)
+	}
+
+	revoked, err := a.tokenRevoked(ctx, claims)
+	if err != nil {
+		log.Error("failed to check token revocation", slog.String("error", err.Error()))
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return revoked, nil
+}
+
+// CleanupRevokedTokens periodically removes expired entries from the revocation list
+// until ctx is done. Expired tokens are rejected by their exp claim anyway.
+func (a *Auth) CleanupRevokedTokens(ctx context.Context, interval time.Duration) {
+	const op = "auth.CleanupRevokedTokens"
+	log := a.log.With(slog.String("op", op))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := a.revocation.DeleteExpiredRevokedTokens(ctx)
+			if err != nil {
+				log.Error("failed to delete expired revoked tokens", slog.String("error", err.Error()))
+				continue
+			}
+			log.Debug("expired revoked tokens deleted", slog.Int64("count", deleted))
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/opaque"
+	"sso/internal/lib/totp"
+	"sso/internal/storage"
+	"time"
+)
+
+var (
+	ErrMFAAlreadyEnabled = errors.New("mfa already enabled")
+	ErrMFANotEnrolled    = errors.New("mfa not enrolled")
+	ErrInvalidMFACode    = errors.New("invalid mfa code")
+	ErrInvalidChallenge  = errors.New("invalid mfa challenge")
+	ErrTOTPDisabled      = errors.New("totp is disabled")
+)
+
+// EnrollTOTP generates a new TOTP secret for the user. The second factor
+// is enabled only after a code generated from it is confirmed by ConfirmTOTP.
+// The password of the user confirms the enrollment, a stolen access token alone does not
+// bind a second factor of the attacker to the account.
+// Enrollment is disabled without an encryption key for the secrets.
+func (a *Auth) EnrollTOTP(ctx context.Context, accessToken, password string) (secret string, uri string, err error) {
+	const op = "auth.EnrollTOTP"
+	log := a.log.With(slog.String("op", op))
+
+	if a.secrets == nil {
+		return "", "", fmt.Errorf("%s: %w", op, ErrTOTPDisabled)
+	}
+
+	user, _, err := a.reauthenticate(ctx, log, accessToken, password)
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("uid", user.ID))
+
+	if user.TOTPEnabled {
+		log.Warn("totp already enabled")
+		return "", "", fmt.Errorf("%s: %w", op, ErrMFAAlreadyEnabled)
+	}
+
+	secret, err = totp.GenerateSecret()
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+	sealed, err := a.secrets.Seal(secret)
+	if err != nil {
+		log.Error("failed to encrypt totp secret", slog.String("error", err.Error()))
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+	if err := a.mfaStorage.SaveTOTPSecret(ctx, user.ID, sealed); err != nil {
+		log.Error("failed to save totp secret", slog.String("error", err.Error()))
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("totp enrollment started")
+
+	return secret, totp.URI(a.cfg.MFA.Issuer, user.Email, secret), nil
+}
+
+// ConfirmTOTP enables the enrolled second factor and returns a new set of recovery codes.
+// The password of the user is required like for EnrollTOTP.
+func (a *Auth) ConfirmTOTP(ctx context.Context, accessToken, password, code string) ([]string, error) {
+	const op = "auth.ConfirmTOTP"
+	log := a.log.With(slog.String("op", op))
+
+	if a.secrets == nil {
+		return nil, fmt.Errorf("%s: %w", op, ErrTOTPDisabled)
+	}
+
+	user, _, err := a.reauthenticate(ctx, log, accessToken, password)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("uid", user.ID))
+
+	if user.TOTPEnabled {
+		return nil, fmt.Errorf("%s: %w", op, ErrMFAAlreadyEnabled)
+	}
+	if user.TOTPSecret == nil {
+		return nil, fmt.Errorf("%s: %w", op, ErrMFANotEnrolled)
+	}
+
+	ok, err := a.verifyTOTP(ctx, user, code)
+	if err != nil {
+		log.Error("failed to verify totp code", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if !ok {
+		log.Info("invalid totp code")
+		return nil, fmt.Errorf("%s: %w", op, ErrInvalidMFACode)
+	}
+
+	codes, err := a.newRecoveryCodes(ctx, user.ID)
+	if err != nil {
+		log.Error("failed to generate recovery codes", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.mfaStorage.EnableTOTP(ctx, user.ID); err != nil {
+		log.Error("failed to enable totp", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("totp enabled")
+
+	return codes, nil
+}
+
+// VerifyMFA completes the MFA challenge returned by Login and issues tokens, or the password
+// change id if the password expired. code is either a TOTP code or an unused recovery code, which is consumed.
+// Invalid codes count as failed logins of the user, so new challenges don't bring new attempts.
+func (a *Auth) VerifyMFA(ctx context.Context, challengeID, code string) (*models.LoginResult, error) {
+	const op = "auth.VerifyMFA"
+	log := a.log.With(slog.String("op", op))
+
+	challenge, err := a.mfaStorage.MFAChallenge(ctx, opaque.Hash(challengeID), a.cfg.MFA.MaxAttempts)
+	if err != nil {
+		if errors.Is(err, storage.ErrChallengeNotFound) {
+			log.Warn("challenge not found, used, expired or out of attempts")
+			return nil, fmt.Errorf("%s: %w", op, ErrInvalidChallenge)
+		}
+		log.Error("failed to get challenge", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("uid", challenge.UserID))
+
+	user, err := a.userProvider.UserByID(ctx, challenge.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return nil, fmt.Errorf("%s: %w", op, ErrInvalidChallenge)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if !user.TOTPEnabled {
+		return nil, fmt.Errorf("%s: %w", op, ErrInvalidChallenge)
+	}
+	if err := a.checkMFAAttempt(ctx, log, user.Email); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	ok, err := a.verifyTOTP(ctx, user, code)
+	if err != nil {
+		log.Error("failed to verify totp code", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if !ok {
+		ok, err = a.useRecoveryCode(ctx, user, code)
+		if err != nil {
+			log.Error("failed to use recovery code", slog.String("error", err.Error()))
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		if ok {
+			log.Warn("recovery code used")
+		}
+	}
+	if !ok {
+		log.Info("invalid mfa code")
+		if err := a.mfaStorage.AddMFAChallengeAttempt(ctx, challenge.ID); err != nil {
+			log.Error("failed to record attempt", slog.String("error", err.Error()))
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		a.mfaFailed(ctx, log, user.Email)
+		return nil, fmt.Errorf("%s: %w", op, ErrInvalidMFACode)
+	}
+	a.mfaPassed(ctx, log, user.Email)
+
+	if err := a.mfaStorage.UseMFAChallenge(ctx, challenge.ID); err != nil {
+		if errors.Is(err, storage.ErrChallengeNotFound) {
+			return nil, fmt.Errorf("%s: %w", op, ErrInvalidChallenge)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	app, err := a.appProvider.App(ctx, int(challenge.AppID))
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return nil, fmt.Errorf("%s: %w", op, ErrInvalidAppId)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if challenge.PasswordExpired {
+		changeID, err := a.createPasswordChangeTicket(ctx, user, app)
+		if err != nil {
+			log.Error("failed to create password change ticket", slog.String("error", err.Error()))
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		log.Info("mfa verified, password expired")
+		return &models.LoginResult{PasswordChangeID: changeID}, nil
+	}
+
+	pair, err := a.startSession(ctx, user, app, "")
+	if err != nil {
+		log.Error("failed to issue tokens", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("mfa verified, user logged in")
+
+	return &models.LoginResult{Tokens: pair}, nil
+}
+
+func (a *Auth) createMFAChallenge(
+	ctx context.Context,
+	user *models.User,
+	app *models.App,
+	passwordExpired bool,
+) (string, error) {
+	challengeID, hash, err := opaque.New()
+	if err != nil {
+		return "", err
+	}
+	err = a.mfaStorage.SaveMFAChallenge(ctx, &models.MFAChallenge{
+		ChallengeHash:   hash,
+		UserID:          user.ID,
+		AppID:           app.ID,
+		PasswordExpired: passwordExpired,
+		ExpiresAt:       time.Now().Add(a.cfg.MFA.ChallengeTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+	return challengeID, nil
+}
+
+// verifyTOTP checks the code and records its time step, so a code can't be replayed.
+// Without an encryption key no code is valid, the users are left with their recovery codes.
+func (a *Auth) verifyTOTP(ctx context.Context, user *models.User, code string) (bool, error) {
+	if user.TOTPSecret == nil || a.secrets == nil {
+		return false, nil
+	}
+	secret, err := a.secrets.Open(*user.TOTPSecret)
+	if err != nil {
+		return false, err
+	}
+
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	if err := a.mfaStorage.UseTOTPStep(ctx, user.ID, step); err != nil {
+		if errors.Is(err, storage.ErrTOTPStepUsed) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
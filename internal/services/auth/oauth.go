@@ -0,0 +1,289 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/opaque"
+	"sso/internal/lib/pkce"
+	"sso/internal/storage"
+	"time"
+)
+
+const (
+	responseTypeCode = "code"
+)
+
+var (
+	ErrInvalidRedirectURI      = errors.New("invalid redirect uri")
+	ErrUnsupportedResponseType = errors.New("unsupported response type")
+	ErrInvalidPKCE             = errors.New("invalid pkce code challenge")
+	ErrInvalidGrant            = errors.New("invalid grant")
+	ErrMFARequired             = errors.New("mfa code required")
+)
+
+// ValidateAuthorizationRequest checks the client, the redirect uri and the PKCE challenge
+// of an authorization request and returns the client app.
+// ErrInvalidAppId and ErrInvalidRedirectURI must not be reported to the redirect uri.
+func (a *Auth) ValidateAuthorizationRequest(ctx context.Context, req *models.AuthorizationRequest) (*models.App, error) {
+	const op = "auth.ValidateAuthorizationRequest"
+
+	app, err := a.appProvider.App(ctx, req.ClientID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return nil, fmt.Errorf("%s: %w", op, ErrInvalidAppId)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.checkRedirectURI(ctx, app, req.RedirectURI); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if req.ResponseType != responseTypeCode {
+		return nil, fmt.Errorf("%s: %w", op, ErrUnsupportedResponseType)
+	}
+	if !pkce.ValidChallenge(req.CodeChallenge, req.CodeChallengeMethod) {
+		return nil, fmt.Errorf("%s: %w", op, ErrInvalidPKCE)
+	}
+
+	return app, nil
+}
+
+// Authorize authenticates the user on behalf of the client and returns an authorization code.
+// If the user has a second factor, mfaCode must hold a TOTP or recovery code,
+// otherwise ErrMFARequired is returned.
+func (a *Auth) Authorize(ctx context.Context, req *models.AuthorizationRequest, email, password, mfaCode string) (string, error) {
+	const op = "auth.Authorize"
+	log := a.log.With(
+		slog.String("op", op),
+		slog.String("email", email),
+		slog.Int("client_id", req.ClientID),
+	)
+
+	app, err := a.ValidateAuthorizationRequest(ctx, req)
+	if err != nil {
+		log.Warn("invalid authorization request", slog.String("error", err.Error()))
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	user, err := a.signIn(ctx, log, app, email, password, mfaCode)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	familyID, err := opaque.NewID()
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	code, hash, err := opaque.New()
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	err = a.oauthStorage.SaveAuthorizationCode(ctx, &models.AuthorizationCode{
+		CodeHash:            hash,
+		FamilyID:            familyID,
+		UserID:              user.ID,
+		AppID:               app.ID,
+		RedirectURI:         req.RedirectURI,
+		Scope:               req.Scope,
+		Nonce:               req.Nonce,
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: req.CodeChallengeMethod,
+		AuthTime:            time.Now(),
+		ExpiresAt:           time.Now().Add(a.cfg.OAuth.AuthorizationCodeTTL),
+	})
+	if err != nil {
+		log.Error("failed to save authorization code", slog.String("error", err.Error()))
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("authorization code issued", slog.Int64("uid", user.ID))
+
+	return code, nil
+}
+
+// ExchangeAuthorizationCode redeems an authorization code for tokens.
+// A code can be redeemed once; replaying it revokes the tokens issued for it.
+func (a *Auth) ExchangeAuthorizationCode(
+	ctx context.Context,
+	code string,
+	clientID int,
+	redirectURI string,
+	codeVerifier string,
+) (*models.TokenPair, error) {
+	const op = "auth.ExchangeAuthorizationCode"
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int("client_id", clientID),
+	)
+
+	stored, err := a.oauthStorage.UseAuthorizationCode(ctx, opaque.Hash(code))
+	if err != nil {
+		if errors.Is(err, storage.ErrAuthCodeNotFound) {
+			log.Warn("authorization code not found or expired")
+			return nil, fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+		}
+		if errors.Is(err, storage.ErrAuthCodeUsed) {
+			log.Warn("authorization code reuse detected, revoking tokens",
+				slog.Int64("uid", stored.UserID),
+				slog.String("family_id", stored.FamilyID),
+			)
+			if err := a.tokenStorage.RevokeRefreshTokenFamily(ctx, stored.FamilyID); err != nil {
+				log.Error("failed to revoke token family", slog.String("error", err.Error()))
+				return nil, fmt.Errorf("%s: %w", op, err)
+			}
+			return nil, fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+		}
+		log.Error("failed to use authorization code", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if stored.AppID != int64(clientID) || stored.RedirectURI != redirectURI {
+		log.Warn("authorization code issued to another client or redirect uri")
+		return nil, fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+	}
+	if !pkce.Verify(codeVerifier, stored.CodeChallenge) {
+		log.Warn("code verifier does not match")
+		return nil, fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+	}
+
+	user, err := a.userProvider.UserByID(ctx, stored.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return nil, fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	app, err := a.appProvider.App(ctx, clientID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return nil, fmt.Errorf("%s: %w", op, ErrInvalidGrant)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	pair, err := a.issueTokens(ctx, user, app, stored.FamilyID, stored.Scope)
+	if err != nil {
+		log.Error("failed to issue tokens", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if hasScope(stored.Scope, scopeOpenID) {
+		pair.IDToken, err = a.issueIDToken(ctx, user, app, stored)
+		if err != nil {
+			log.Error("failed to issue id token", slog.String("error", err.Error()))
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	log.Info("authorization code exchanged", slog.Int64("uid", user.ID))
+
+	return pair, nil
+}
+
+// checkRedirectURI returns ErrInvalidRedirectURI unless the uri is registered for the app.
+func (a *Auth) checkRedirectURI(ctx context.Context, app *models.App, redirectURI string) error {
+	uris, err := a.oauthStorage.RedirectURIs(ctx, app.ID)
+	if err != nil {
+		return err
+	}
+	for _, uri := range uris {
+		// redirect uris are compared exactly, no prefix or wildcard matching
+		if uri == redirectURI {
+			return nil
+		}
+	}
+	return ErrInvalidRedirectURI
+}
+
+// signIn authenticates a user on a page served to the browser. Unlike Login
+// the second factor is checked in the same step, mfaCode is a TOTP or recovery code.
+// Invalid codes count as failed logins.
+func (a *Auth) signIn(
+	ctx context.Context,
+	log *slog.Logger,
+	app *models.App,
+	email string,
+	password string,
+	mfaCode string,
+) (*models.User, error) {
+	user, local, err := a.authenticateThrottled(ctx, log, app, email, password)
+	if err != nil {
+		return nil, err
+	}
+
+	if app.RequireVerifiedEmail && !user.EmailVerified {
+		log.Info("email not verified")
+		return nil, ErrEmailNotVerified
+	}
+
+	if local {
+		expired, err := a.passwordExpired(ctx, app.ID, user)
+		if err != nil {
+			log.Error("failed to check password expiry", slog.String("error", err.Error()))
+			return nil, err
+		}
+		if expired {
+			// the page can't change the password, Login returns the ticket for ChangePassword
+			log.Info("password expired")
+			return nil, ErrPasswordExpired
+		}
+	}
+
+	if !user.TOTPEnabled {
+		return user, nil
+	}
+	if mfaCode == "" {
+		return nil, ErrMFARequired
+	}
+	ok, err := a.verifyTOTP(ctx, user, mfaCode)
+	if err != nil {
+		log.Error("failed to verify totp code", slog.String("error", err.Error()))
+		return nil, err
+	}
+	if !ok {
+		ok, err = a.useRecoveryCode(ctx, user, mfaCode)
+		if err != nil {
+			log.Error("failed to use recovery code", slog.String("error", err.Error()))
+			return nil, err
+		}
+	}
+	if !ok {
+		log.Info("invalid mfa code")
+		a.mfaFailed(ctx, log, email)
+		return nil, ErrInvalidMFACode
+	}
+	a.mfaPassed(ctx, log, email)
+	return user, nil
+}
+
+// RefreshForClient is Refresh for the OAuth token endpoint,
+// the refresh token must have been issued to the client.
+func (a *Auth) RefreshForClient(ctx context.Context, refreshToken string, clientID int) (*models.TokenPair, error) {
+	const op = "auth.RefreshForClient"
+
+	token, err := a.tokenStorage.RefreshToken(ctx, opaque.Hash(refreshToken))
+	if err != nil {
+		if errors.Is(err, storage.ErrRefreshTokenNotFound) {
+			return nil, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if token.AppID != int64(clientID) {
+		a.log.Warn("refresh token issued to another client",
+			slog.String("op", op),
+			slog.Int("client_id", clientID),
+		)
+		return nil, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+
+	pair, err := a.Refresh(ctx, refreshToken)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return pair, nil
+}
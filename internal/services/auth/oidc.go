@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+	"sso/internal/storage"
+	"strconv"
+	"strings"
+)
+
+// Scopes understood by the OpenID Connect layer, other scopes are passed through to the tokens.
+const (
+	scopeOpenID  = "openid"
+	scopeEmail   = "email"
+	scopeProfile = "profile"
+)
+
+var (
+	ErrInsufficientScope = errors.New("insufficient scope")
+)
+
+// UserInfo returns the claims about the user the access token was issued to.
+// The token must be granted the openid scope; email and profile claims
+// are released only for the corresponding scopes.
+func (a *Auth) UserInfo(ctx context.Context, accessToken string) (*models.UserInfo, error) {
+	const op = "auth.UserInfo"
+	log := a.log.With(slog.String("op", op))
+
+	claims, err := a.verifyAccessToken(ctx, accessToken)
+	if err != nil {
+		log.Warn("failed to authenticate", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if !hasScope(claims.Scope, scopeOpenID) {
+		return nil, fmt.Errorf("%s: %w", op, ErrInsufficientScope)
+	}
+
+	user, err := a.userProvider.UserByID(ctx, claims.UID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return nil, fmt.Errorf("%s: %w", op, ErrInvalidAccessToken)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	info := &models.UserInfo{
+		Subject: strconv.FormatInt(user.ID, 10),
+	}
+	if hasScope(claims.Scope, scopeEmail) {
+		verified := user.EmailVerified
+		info.Email = user.Email
+		info.EmailVerified = &verified
+	}
+	if hasScope(claims.Scope, scopeProfile) {
+		// users have no profile attributes besides the email they sign in with
+		info.PreferredUsername = user.Email
+	}
+
+	return info, nil
+}
+
+// issueIDToken creates the ID token for the tokens redeemed with the authorization code.
+func (a *Auth) issueIDToken(
+	ctx context.Context,
+	user *models.User,
+	app *models.App,
+	code *models.AuthorizationCode,
+) (string, error) {
+	key, err := a.signingKey(ctx, app)
+	if err != nil {
+		return "", err
+	}
+	return jwt.NewIDToken(user, app, key, jwt.IDTokenParams{
+		Issuer:   a.cfg.OAuth.Issuer,
+		Nonce:    code.Nonce,
+		AuthTime: code.AuthTime,
+		Email:    hasScope(code.Scope, scopeEmail),
+	}, a.cfg.TokenTTL)
+}
+
+func hasScope(scope, want string) bool {
+	for _, s := range strings.Fields(scope) {
+		if s == want {
+			return true
+		}
+	}
+	return false
+}
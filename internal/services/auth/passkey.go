@@ -0,0 +1,368 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/opaque"
+	"sso/internal/storage"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidPasskeySession = errors.New("invalid passkey session")
+	ErrInvalidPasskey        = errors.New("invalid passkey")
+	ErrPasskeyExists         = errors.New("passkey already registered")
+)
+
+// BeginPasskeyRegistration starts the registration ceremony for the authenticated user,
+// who confirms it with the password or, if TOTP is enabled, with a TOTP code.
+// Finishing the ceremony needs the session, so it is confirmed as well.
+// options is the JSON encoded PublicKeyCredentialCreationOptions for navigator.credentials.create.
+func (a *Auth) BeginPasskeyRegistration(
+	ctx context.Context,
+	accessToken string,
+	password string,
+	code string,
+) (sessionID string, options []byte, err error) {
+	const op = "auth.BeginPasskeyRegistration"
+	log := a.log.With(slog.String("op", op))
+
+	user, _, err := a.reauthenticateFactor(ctx, log, accessToken, password, code)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("uid", user.ID))
+
+	wUser, err := a.webAuthnUser(ctx, user)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	exclusions := make([]protocol.CredentialDescriptor, 0, len(wUser.credentials))
+	for _, cred := range wUser.credentials {
+		exclusions = append(exclusions, cred.Descriptor())
+	}
+
+	creation, session, err := a.passkeys.BeginRegistration(wUser,
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+		webauthn.WithExclusions(exclusions),
+	)
+	if err != nil {
+		log.Error("failed to begin registration", slog.String("error", err.Error()))
+		return "", nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	sessionID, err = a.saveWebAuthnSession(ctx, session, &user.ID, nil)
+	if err != nil {
+		log.Error("failed to save session", slog.String("error", err.Error()))
+		return "", nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	options, err = json.Marshal(creation)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return sessionID, options, nil
+}
+
+// FinishPasskeyRegistration verifies the attestation returned by the authenticator
+// and stores the new credential.
+func (a *Auth) FinishPasskeyRegistration(ctx context.Context, accessToken, sessionID string, credential []byte) error {
+	const op = "auth.FinishPasskeyRegistration"
+	log := a.log.With(slog.String("op", op))
+
+	user, err := a.authenticate(ctx, accessToken)
+	if err != nil {
+		log.Warn("failed to authenticate", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("uid", user.ID))
+
+	stored, session, err := a.takeWebAuthnSession(ctx, sessionID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if stored.UserID == nil || *stored.UserID != user.ID {
+		log.Warn("session belongs to another user")
+		return fmt.Errorf("%s: %w", op, ErrInvalidPasskeySession)
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBody(bytes.NewReader(credential))
+	if err != nil {
+		log.Warn("failed to parse credential", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, ErrInvalidPasskey)
+	}
+
+	wUser, err := a.webAuthnUser(ctx, user)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	cred, err := a.passkeys.CreateCredential(wUser, *session, parsed)
+	if err != nil {
+		log.Warn("failed to verify credential", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, ErrInvalidPasskey)
+	}
+
+	transports := make([]string, 0, len(cred.Transport))
+	for _, t := range cred.Transport {
+		transports = append(transports, string(t))
+	}
+
+	err = a.passkeyStorage.SavePasskeyCredential(ctx, &models.PasskeyCredential{
+		UserID:          user.ID,
+		CredentialID:    cred.ID,
+		PublicKey:       cred.PublicKey,
+		AttestationType: cred.AttestationType,
+		AAGUID:          cred.Authenticator.AAGUID,
+		SignCount:       int64(cred.Authenticator.SignCount),
+		Transports:      strings.Join(transports, ","),
+		BackupEligible:  cred.Flags.BackupEligible,
+		BackupState:     cred.Flags.BackupState,
+	})
+	if err != nil {
+		if errors.Is(err, storage.ErrPasskeyExists) {
+			return fmt.Errorf("%s: %w", op, ErrPasskeyExists)
+		}
+		log.Error("failed to save credential", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("passkey registered")
+
+	return nil
+}
+
+// BeginPasskeyLogin starts a passwordless login ceremony with discoverable credentials.
+// options is the JSON encoded PublicKeyCredentialRequestOptions for navigator.credentials.get.
+func (a *Auth) BeginPasskeyLogin(ctx context.Context, appID int) (sessionID string, options []byte, err error) {
+	const op = "auth.BeginPasskeyLogin"
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", appID),
+	)
+
+	app, err := a.appProvider.App(ctx, appID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return "", nil, fmt.Errorf("%s: %w", op, ErrInvalidAppId)
+		}
+		return "", nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	assertion, session, err := a.passkeys.BeginDiscoverableLogin(
+		webauthn.WithUserVerification(protocol.VerificationRequired),
+	)
+	if err != nil {
+		log.Error("failed to begin login", slog.String("error", err.Error()))
+		return "", nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	sessionID, err = a.saveWebAuthnSession(ctx, session, nil, &app.ID)
+	if err != nil {
+		log.Error("failed to save session", slog.String("error", err.Error()))
+		return "", nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	options, err = json.Marshal(assertion)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return sessionID, options, nil
+}
+
+// FinishPasskeyLogin verifies the assertion and issues the same tokens as Login.
+// A passkey with user verification is a multi-factor credential by itself,
+// so no additional MFA challenge is required.
+func (a *Auth) FinishPasskeyLogin(ctx context.Context, sessionID string, credential []byte) (*models.TokenPair, error) {
+	const op = "auth.FinishPasskeyLogin"
+	log := a.log.With(slog.String("op", op))
+
+	stored, session, err := a.takeWebAuthnSession(ctx, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if stored.AppID == nil {
+		return nil, fmt.Errorf("%s: %w", op, ErrInvalidPasskeySession)
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(credential))
+	if err != nil {
+		log.Warn("failed to parse assertion", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("%s: %w", op, ErrInvalidPasskey)
+	}
+
+	var wUser *webAuthnUser
+	handler := func(rawID, userHandle []byte) (webauthn.User, error) {
+		uid, err := strconv.ParseInt(string(userHandle), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		user, err := a.userProvider.UserByID(ctx, uid)
+		if err != nil {
+			return nil, err
+		}
+		wUser, err = a.webAuthnUser(ctx, user)
+		if err != nil {
+			return nil, err
+		}
+		return wUser, nil
+	}
+
+	cred, err := a.passkeys.ValidateDiscoverableLogin(handler, *session, parsed)
+	if err != nil {
+		log.Warn("failed to verify assertion", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("%s: %w", op, ErrInvalidPasskey)
+	}
+
+	log = log.With(slog.Int64("uid", wUser.user.ID))
+
+	if cred.Authenticator.CloneWarning {
+		log.Warn("sign counter did not increase, authenticator may be cloned")
+		return nil, fmt.Errorf("%s: %w", op, ErrInvalidPasskey)
+	}
+
+	err = a.passkeyStorage.UpdatePasskeyUsage(ctx, cred.ID, int64(cred.Authenticator.SignCount), cred.Flags.BackupState)
+	if err != nil {
+		log.Error("failed to update passkey", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	app, err := a.appProvider.App(ctx, int(*stored.AppID))
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return nil, fmt.Errorf("%s: %w", op, ErrInvalidAppId)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if app.RequireVerifiedEmail && !wUser.user.EmailVerified {
+		return nil, fmt.Errorf("%s: %w", op, ErrEmailNotVerified)
+	}
+
+	pair, err := a.startSession(ctx, wUser.user, app, "")
+	if err != nil {
+		log.Error("failed to issue tokens", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("user logged in with passkey")
+
+	return pair, nil
+}
+
+func (a *Auth) saveWebAuthnSession(
+	ctx context.Context,
+	session *webauthn.SessionData,
+	userID *int64,
+	appID *int64,
+) (string, error) {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return "", err
+	}
+	sessionID, hash, err := opaque.New()
+	if err != nil {
+		return "", err
+	}
+	err = a.passkeyStorage.SaveWebAuthnSession(ctx, &models.WebAuthnSession{
+		SessionHash: hash,
+		UserID:      userID,
+		AppID:       appID,
+		Data:        string(data),
+		ExpiresAt:   time.Now().Add(a.cfg.WebAuthn.SessionTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+	return sessionID, nil
+}
+
+func (a *Auth) takeWebAuthnSession(ctx context.Context, sessionID string) (*models.WebAuthnSession, *webauthn.SessionData, error) {
+	stored, err := a.passkeyStorage.TakeWebAuthnSession(ctx, opaque.Hash(sessionID))
+	if err != nil {
+		if errors.Is(err, storage.ErrSessionNotFound) {
+			return nil, nil, ErrInvalidPasskeySession
+		}
+		return nil, nil, err
+	}
+	session := new(webauthn.SessionData)
+	if err := json.Unmarshal([]byte(stored.Data), session); err != nil {
+		return nil, nil, err
+	}
+	return stored, session, nil
+}
+
+func (a *Auth) webAuthnUser(ctx context.Context, user *models.User) (*webAuthnUser, error) {
+	creds, err := a.passkeyStorage.PasskeyCredentials(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	wUser := &webAuthnUser{
+		user:        user,
+		credentials: make([]webauthn.Credential, 0, len(creds)),
+	}
+	for _, c := range creds {
+		var transports []protocol.AuthenticatorTransport
+		if c.Transports != "" {
+			for _, t := range strings.Split(c.Transports, ",") {
+				transports = append(transports, protocol.AuthenticatorTransport(t))
+			}
+		}
+		wUser.credentials = append(wUser.credentials, webauthn.Credential{
+			ID:              c.CredentialID,
+			PublicKey:       c.PublicKey,
+			AttestationType: c.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				BackupEligible: c.BackupEligible,
+				BackupState:    c.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    c.AAGUID,
+				SignCount: uint32(c.SignCount),
+			},
+		})
+	}
+	return wUser, nil
+}
+
+// webAuthnUser adapts models.User to webauthn.User.
+// The user handle is the user id, it does not contain personal data.
+type webAuthnUser struct {
+	user        *models.User
+	credentials []webauthn.Credential
+}
+
+func (u *webAuthnUser) WebAuthnID() []byte {
+	return []byte(strconv.FormatInt(u.user.ID, 10))
+}
+
+func (u *webAuthnUser) WebAuthnName() string {
+	return u.user.Email
+}
+
+func (u *webAuthnUser) WebAuthnDisplayName() string {
+	return u.user.Email
+}
+
+func (u *webAuthnUser) WebAuthnCredentials() []webauthn.Credential {
+	return u.credentials
+}
+
+func (u *webAuthnUser) WebAuthnIcon() string {
+	return ""
+}
@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/opaque"
+	"sso/internal/storage"
+	"time"
+)
+
+var (
+	ErrPasswordExpired       = errors.New("password expired")
+	ErrInvalidPasswordChange = errors.New("invalid password change ticket")
+)
+
+// ChangePassword replaces the password of the user, who is either the user of the access token
+// or the user whose expired password Login returned the password change id for.
+// The current password is required in both cases. The new password has to meet the policy
+// of the app and must not be one of the previous passwords it remembers.
+// Every session of the user is revoked. A caller authenticated by an access token gets the tokens
+// of a new session, a caller with a password change id signs in again with the new password.
+func (a *Auth) ChangePassword(
+	ctx context.Context,
+	accessToken string,
+	changeID string,
+	currentPassword string,
+	newPassword string,
+) (*models.TokenPair, error) {
+	const op = "auth.ChangePassword"
+	log := a.log.With(slog.String("op", op))
+
+	var (
+		user   *models.User
+		claims *jwt.Claims
+		appID  int64
+		ticket *models.PasswordChangeTicket
+		err    error
+	)
+	if changeID != "" {
+		ticket, err = a.passwordStorage.PasswordChangeTicket(ctx, opaque.Hash(changeID))
+		if err != nil {
+			if errors.Is(err, storage.ErrPasswordChangeNotFound) {
+				log.Warn("password change ticket not found, used or expired")
+				return nil, fmt.Errorf("%s: %w", op, ErrInvalidPasswordChange)
+			}
+			log.Error("failed to get password change ticket", slog.String("error", err.Error()))
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		user, err = a.userProvider.UserByID(ctx, ticket.UserID)
+		if err != nil {
+			if errors.Is(err, storage.ErrUserNotFound) {
+				return nil, fmt.Errorf("%s: %w", op, ErrInvalidPasswordChange)
+			}
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		app, err := a.appProvider.App(ctx, int(ticket.AppID))
+		if err != nil {
+			log.Error("failed to get app", slog.String("error", err.Error()))
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		user, err = a.confirmPassword(ctx, log.With(slog.Int64("uid", user.ID)), app, user, currentPassword)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		appID = ticket.AppID
+	} else {
+		user, claims, err = a.reauthenticate(ctx, log, accessToken, currentPassword)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		appID = claims.AppID
+	}
+
+	log = log.With(slog.Int64("uid", user.ID), slog.Int64("app_id", appID))
+
+	if err := a.checkPasswordPolicy(ctx, log, appID, newPassword, user.Email, user); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if ticket != nil {
+		if err := a.passwordStorage.UsePasswordChangeTicket(ctx, ticket.ID); err != nil {
+			if errors.Is(err, storage.ErrPasswordChangeNotFound) {
+				log.Warn("password change ticket used concurrently")
+				return nil, fmt.Errorf("%s: %w", op, ErrInvalidPasswordChange)
+			}
+			log.Error("failed to use password change ticket", slog.String("error", err.Error()))
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	passHash, err := a.passwords.Hash([]byte(newPassword))
+	if err != nil {
+		log.Error("failed to hash password", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if err := a.passwordStorage.SetPassword(ctx, user.ID, passHash, maxPasswordHistory-1); err != nil {
+		log.Error("failed to set password", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.tokenStorage.RevokeUserTokens(ctx, user.ID); err != nil {
+		log.Error("failed to revoke tokens", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("password changed")
+
+	if claims == nil {
+		return nil, nil
+	}
+
+	app, err := a.appProvider.App(ctx, int(appID))
+	if err != nil {
+		log.Error("failed to get app", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	pair, err := a.startSession(ctx, user, app, claims.Scope)
+	if err != nil {
+		log.Error("failed to issue tokens", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return pair, nil
+}
+
+// createPasswordChangeTicket returns the id of a ticket permitting the user to change
+// the expired password with ChangePassword.
+func (a *Auth) createPasswordChangeTicket(ctx context.Context, user *models.User, app *models.App) (string, error) {
+	changeID, hash, err := opaque.New()
+	if err != nil {
+		return "", err
+	}
+	err = a.passwordStorage.SavePasswordChangeTicket(ctx, &models.PasswordChangeTicket{
+		TicketHash: hash,
+		UserID:     user.ID,
+		AppID:      app.ID,
+		ExpiresAt:  time.Now().Add(a.cfg.Password.ChangeTicketTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+	return changeID, nil
+}
@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/password"
+	"sso/internal/storage"
+	"time"
+)
+
+// maxPasswordHistory is how many passwords of a user are remembered at most, the current one included.
+const maxPasswordHistory = 24
+
+// PasswordPolicyError is returned for a password which breaks rules of the password policy.
+type PasswordPolicyError struct {
+	Violations []password.Violation
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return "password does not meet the policy"
+}
+
+// passwordPolicy returns the password policy of the app, or the policy of the config
+// if the app has none. An app id of zero stands for the policy of the config.
+func (a *Auth) passwordPolicy(ctx context.Context, appID int64) (*models.PasswordPolicy, error) {
+	if appID != 0 {
+		policy, err := a.appProvider.PasswordPolicy(ctx, appID)
+		if err == nil {
+			return policy, nil
+		}
+		if !errors.Is(err, storage.ErrPasswordPolicyNotFound) {
+			return nil, err
+		}
+	}
+
+	cfg := a.cfg.Password.Policy
+	return &models.PasswordPolicy{
+		MinLength:       cfg.MinLength,
+		MaxLength:       cfg.MaxLength,
+		RequireUpper:    cfg.RequireUpper,
+		RequireLower:    cfg.RequireLower,
+		RequireDigit:    cfg.RequireDigit,
+		RequireSpecial:  cfg.RequireSpecial,
+		BannedWords:     cfg.BannedWords,
+		EmailSimilarity: cfg.EmailSimilarity,
+		HistorySize:     cfg.HistorySize,
+		MaxAgeDays:      cfg.MaxAgeDays,
+	}, nil
+}
+
+// checkPasswordPolicy returns a *PasswordPolicyError if the new password of the user
+// with the email breaks the password policy of the app or is a breached password.
+// user is the user changing the password, nil on registration. A password changed without
+// an app, as on a reset, is used for every app, so the longest history of the apps applies.
+func (a *Auth) checkPasswordPolicy(
+	ctx context.Context,
+	log *slog.Logger,
+	appID int64,
+	newPassword string,
+	email string,
+	user *models.User,
+) error {
+	policy, err := a.passwordPolicy(ctx, appID)
+	if err != nil {
+		log.Error("failed to get password policy", slog.String("error", err.Error()))
+		return err
+	}
+
+	if appID == 0 && user != nil {
+		size, err := a.appProvider.MaxPasswordHistorySize(ctx)
+		if err != nil {
+			log.Error("failed to get password history size", slog.String("error", err.Error()))
+			return err
+		}
+		policy.HistorySize = max(policy.HistorySize, size)
+	}
+
+	violations := password.CheckPolicy(policy, newPassword, email)
+	if a.breaches != nil {
+		count, err := a.breaches.Count(newPassword)
+		if err != nil {
+			// the policy still applies, the corpus is not worth failing the request
+			log.Error("failed to check breached passwords", slog.String("error", err.Error()))
+		} else if count >= a.cfg.Password.Breach.MinCount {
+			violations = append(violations, password.Violation{
+				Rule:    password.RuleBreached,
+				Message: "appears in known data breaches",
+			})
+		}
+	}
+	if user != nil && policy.HistorySize > 0 {
+		reused, err := a.passwordReused(ctx, user, newPassword, policy.HistorySize)
+		if err != nil {
+			log.Error("failed to check password history", slog.String("error", err.Error()))
+			return err
+		}
+		if reused {
+			violations = append(violations, password.Violation{
+				Rule:    password.RuleHistory,
+				Message: fmt.Sprintf("must differ from the last %d passwords", policy.HistorySize),
+			})
+		}
+	}
+
+	if len(violations) > 0 {
+		log.Info("password does not meet the policy", slog.Int("violations", len(violations)))
+		return &PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// passwordReused reports whether the password is one of the size latest passwords of the user,
+// the current one included.
+func (a *Auth) passwordReused(ctx context.Context, user *models.User, newPassword string, size int) (bool, error) {
+	hashes, err := a.passwordStorage.PasswordHistory(ctx, user.ID, min(size, maxPasswordHistory)-1)
+	if err != nil {
+		return false, err
+	}
+	hashes = append([]string{string(user.PassHash)}, hashes...)
+
+	for _, hash := range hashes {
+		ok, err := a.passwords.Verify(hash, []byte(newPassword))
+		if err != nil {
+			// hashes of unusable passwords are not worth failing the change
+			continue
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// passwordExpired reports whether the password of the user is older than the policy of the app allows.
+func (a *Auth) passwordExpired(ctx context.Context, appID int64, user *models.User) (bool, error) {
+	policy, err := a.passwordPolicy(ctx, appID)
+	if err != nil {
+		return false, err
+	}
+	if policy.MaxAgeDays <= 0 {
+		return false, nil
+	}
+	maxAge := time.Duration(policy.MaxAgeDays) * 24 * time.Hour
+	return time.Since(user.PasswordChangedAt) > maxAge, nil
+}
+
+// sessionPasswordExpired is passwordExpired for a session of the user at the app, the users
+// of the directory of the app sign in with the directory password which does not expire here.
+func (a *Auth) sessionPasswordExpired(ctx context.Context, app *models.App, user *models.User) (bool, error) {
+	dir, err := a.directoryStorage.LDAPDirectory(ctx, app.ID)
+	if err != nil && !errors.Is(err, storage.ErrDirectoryNotFound) {
+		return false, err
+	}
+	if err == nil {
+		linked, err := a.directoryStorage.IsLDAPUser(ctx, dir.ID, user.ID)
+		if err != nil {
+			return false, err
+		}
+		if linked {
+			return false, nil
+		}
+	}
+	return a.passwordExpired(ctx, app.ID, user)
+}
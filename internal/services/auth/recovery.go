@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"sso/internal/domain/models"
+	"sso/internal/lib/opaque"
+	"sso/internal/storage"
+	"strings"
+)
+
+const (
+	recoveryCodesCount = 10
+	recoveryCodeLen    = 12
+	// without characters which are easy to confuse: 0/o, 1/l/i
+	recoveryCodeAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+)
+
+// RegenerateRecoveryCodes replaces all recovery codes of the user.
+// The current TOTP code is required, so a stolen access token alone is not enough.
+// Invalid codes count as failed logins of the user like at VerifyMFA.
+func (a *Auth) RegenerateRecoveryCodes(ctx context.Context, accessToken, code string) ([]string, error) {
+	const op = "auth.RegenerateRecoveryCodes"
+	log := a.log.With(slog.String("op", op))
+
+	user, err := a.authenticate(ctx, accessToken)
+	if err != nil {
+		log.Warn("failed to authenticate", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("uid", user.ID))
+
+	if !user.TOTPEnabled {
+		return nil, fmt.Errorf("%s: %w", op, ErrMFANotEnrolled)
+	}
+
+	if err := a.checkMFAAttempt(ctx, log, user.Email); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	ok, err := a.verifyTOTP(ctx, user, code)
+	if err != nil {
+		log.Error("failed to verify totp code", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if !ok {
+		log.Info("invalid totp code")
+		a.mfaFailed(ctx, log, user.Email)
+		return nil, fmt.Errorf("%s: %w", op, ErrInvalidMFACode)
+	}
+	a.mfaPassed(ctx, log, user.Email)
+
+	codes, err := a.newRecoveryCodes(ctx, user.ID)
+	if err != nil {
+		log.Error("failed to generate recovery codes", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("recovery codes regenerated")
+
+	return codes, nil
+}
+
+// newRecoveryCodes generates and stores a new set of recovery codes for the user.
+// Only hashes are stored, the codes are shown to the user once.
+func (a *Auth) newRecoveryCodes(ctx context.Context, userID int64) ([]string, error) {
+	codes := make([]string, 0, recoveryCodesCount)
+	hashes := make([]string, 0, recoveryCodesCount)
+	for i := 0; i < recoveryCodesCount; i++ {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+		hashes = append(hashes, opaque.Hash(normalizeRecoveryCode(code)))
+	}
+
+	if err := a.mfaStorage.ReplaceRecoveryCodes(ctx, userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// useRecoveryCode consumes the recovery code, it reports false if the code is unknown or used.
+func (a *Auth) useRecoveryCode(ctx context.Context, user *models.User, code string) (bool, error) {
+	err := a.mfaStorage.UseRecoveryCode(ctx, user.ID, opaque.Hash(normalizeRecoveryCode(code)))
+	if err != nil {
+		if errors.Is(err, storage.ErrRecoveryCodeNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// generateRecoveryCode returns a code formatted as xxxx-xxxx-xxxx.
+func generateRecoveryCode() (string, error) {
+	var sb strings.Builder
+	max := big.NewInt(int64(len(recoveryCodeAlphabet)))
+	for i := 0; i < recoveryCodeLen; i++ {
+		if i > 0 && i%4 == 0 {
+			sb.WriteByte('-')
+		}
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteByte(recoveryCodeAlphabet[n.Int64()])
+	}
+	return sb.String(), nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/opaque"
+	"sso/internal/storage"
+	"time"
+)
+
+// Refresh rotates the refresh token and issues a new token pair, unless the password
+// of the user expired meanwhile. Presenting a refresh token which was already used
+// revokes the whole family, since it means the token has leaked.
+func (a *Auth) Refresh(ctx context.Context, refreshToken string) (*models.TokenPair, error) {
+	const op = "auth.Refresh"
+	log := a.log.With(slog.String("op", op))
+
+	log.Info("refreshing tokens")
+
+	token, err := a.tokenStorage.UseRefreshToken(ctx, opaque.Hash(refreshToken))
+	if err != nil {
+		if errors.Is(err, storage.ErrRefreshTokenNotFound) {
+			log.Warn("refresh token not found")
+			return nil, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+		}
+		if errors.Is(err, storage.ErrRefreshTokenUsed) {
+			log.Warn("refresh token reuse detected, revoking family",
+				slog.Int64("uid", token.UserID),
+				slog.String("family_id", token.FamilyID),
+			)
+			if err := a.tokenStorage.RevokeRefreshTokenFamily(ctx, token.FamilyID); err != nil {
+				log.Error("failed to revoke token family", slog.String("error", err.Error()))
+				return nil, fmt.Errorf("%s: %w", op, err)
+			}
+			return nil, fmt.Errorf("%s: %w", op, ErrTokenReused)
+		}
+		log.Error("failed to use refresh token", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if token.RevokedAt != nil || time.Now().After(token.ExpiresAt) {
+		log.Warn("refresh token expired or revoked", slog.Int64("uid", token.UserID))
+		return nil, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+	}
+
+	user, err := a.userProvider.UserByID(ctx, token.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return nil, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	app, err := a.appProvider.App(ctx, int(token.AppID))
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return nil, fmt.Errorf("%s: %w", op, ErrInvalidToken)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// the session ends with the password, Login returns the ticket to change it
+	expired, err := a.sessionPasswordExpired(ctx, app, user)
+	if err != nil {
+		log.Error("failed to check password expiry", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if expired {
+		log.Info("password expired", slog.Int64("uid", user.ID))
+		return nil, fmt.Errorf("%s: %w", op, ErrPasswordExpired)
+	}
+
+	pair, err := a.issueTokens(ctx, user, app, token.FamilyID, token.Scope)
+	if err != nil {
+		log.Error("failed to issue tokens", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("tokens refreshed", slog.Int64("uid", user.ID))
+
+	return pair, nil
+}
+
+// startSession issues a token pair for a new refresh token family.
+func (a *Auth) startSession(ctx context.Context, user *models.User, app *models.App, scope string) (*models.TokenPair, error) {
+	familyID, err := opaque.NewID()
+	if err != nil {
+		return nil, err
+	}
+	return a.issueTokens(ctx, user, app, familyID, scope)
+}
+
+// issueTokens creates an access token and a refresh token belonging to familyID.
+// scope is the OAuth scope granted to the tokens, empty outside of OAuth.
+func (a *Auth) issueTokens(
+	ctx context.Context,
+	user *models.User,
+	app *models.App,
+	familyID string,
+	scope string,
+) (*models.TokenPair, error) {
+	key, err := a.signingKey(ctx, app)
+	if err != nil {
+		return nil, err
+	}
+
+	roles, err := a.roleStorage.UserRoles(ctx, user.ID, app.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := jwt.NewToken(user, app, key, scope, roles, a.cfg.TokenTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, hash, err := opaque.New()
+	if err != nil {
+		return nil, err
+	}
+
+	err = a.tokenStorage.SaveRefreshToken(ctx, &models.RefreshToken{
+		TokenHash: hash,
+		FamilyID:  familyID,
+		UserID:    user.ID,
+		AppID:     app.ID,
+		Scope:     scope,
+		ExpiresAt: time.Now().Add(a.cfg.RefreshTokenTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    a.cfg.TokenTTL,
+		Scope:        scope,
+	}, nil
+}
@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/opaque"
+	"sso/internal/storage"
+	"time"
+)
+
+var (
+	ErrInvalidResetToken = errors.New("invalid password reset token")
+)
+
+const (
+	passwordResetSubject = "Password reset"
+)
+
+// RequestPasswordReset sends a single-use reset token to the user.
+// It does not report whether the email is registered.
+func (a *Auth) RequestPasswordReset(ctx context.Context, email string) error {
+	const op = "auth.RequestPasswordReset"
+	log := a.log.With(
+		slog.String("op", op),
+		slog.String("email", email),
+	)
+
+	log.Info("password reset requested")
+
+	user, err := a.userProvider.User(ctx, email)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Warn("user not found")
+			return nil
+		}
+		log.Error("failed to get user", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	token, hash, err := opaque.New()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	err = a.resetStorage.SavePasswordResetToken(ctx, &models.PasswordResetToken{
+		TokenHash: hash,
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(a.cfg.PasswordResetTTL),
+	})
+	if err != nil {
+		log.Error("failed to save reset token", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	body := fmt.Sprintf(
+		"Use the following token to reset your password: %s\n\nThe token expires in %s.",
+		token, a.cfg.PasswordResetTTL,
+	)
+	// failing only for registered emails would tell which emails are registered
+	if err := a.mailer.Send(ctx, user.Email, passwordResetSubject, body); err != nil {
+		log.Error("failed to send reset token", slog.String("error", err.Error()))
+		return nil
+	}
+
+	log.Info("password reset token sent")
+
+	return nil
+}
+
+// ConfirmPasswordReset sets a new password using a reset token, invalidates the other
+// reset tokens of the user and revokes all tokens of the user. The password has to meet
+// the policy of the config and must not be one of the previous passwords the strictest app remembers.
+func (a *Auth) ConfirmPasswordReset(ctx context.Context, token string, newPassword []byte) error {
+	const op = "auth.ConfirmPasswordReset"
+	log := a.log.With(slog.String("op", op))
+
+	tokenHash := opaque.Hash(token)
+	resetToken, err := a.resetStorage.PasswordResetToken(ctx, tokenHash)
+	if err != nil {
+		if errors.Is(err, storage.ErrResetTokenNotFound) {
+			log.Warn("reset token not found, used or expired")
+			return fmt.Errorf("%s: %w", op, ErrInvalidResetToken)
+		}
+		log.Error("failed to get reset token", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("uid", resetToken.UserID))
+
+	user, err := a.userProvider.UserByID(ctx, resetToken.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return fmt.Errorf("%s: %w", op, ErrInvalidResetToken)
+		}
+		log.Error("failed to get user", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	// the token is not used up by a password the policy rejects
+	if err := a.checkPasswordPolicy(ctx, log, 0, string(newPassword), user.Email, user); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err := a.resetStorage.UsePasswordResetToken(ctx, tokenHash); err != nil {
+		if errors.Is(err, storage.ErrResetTokenNotFound) {
+			log.Warn("reset token used concurrently")
+			return fmt.Errorf("%s: %w", op, ErrInvalidResetToken)
+		}
+		log.Error("failed to use reset token", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	hashedPass, err := a.passwords.Hash(newPassword)
+	if err != nil {
+		log.Error("failed to hash password", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.passwordStorage.SetPassword(ctx, resetToken.UserID, hashedPass, maxPasswordHistory-1); err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return fmt.Errorf("%s: %w", op, ErrInvalidResetToken)
+		}
+		log.Error("failed to update password", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.tokenStorage.RevokeUserTokens(ctx, resetToken.UserID); err != nil {
+		log.Error("failed to revoke tokens", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("password reset")
+
+	return nil
+}
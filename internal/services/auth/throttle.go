@@ -0,0 +1,247 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/clientip"
+	"sso/internal/lib/jwt"
+	"sso/internal/storage"
+	"strings"
+	"time"
+)
+
+var (
+	ErrTooManyAttempts  = errors.New("too many failed login attempts")
+	ErrAccountLocked    = errors.New("account temporarily locked")
+	ErrPermissionDenied = errors.New("permission denied")
+	ErrUserNotFound     = errors.New("user not found")
+)
+
+// LoginThrottledError rejects a login before the credentials are checked.
+// Err is ErrAccountLocked if the login is locked, otherwise ErrTooManyAttempts.
+type LoginThrottledError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *LoginThrottledError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *LoginThrottledError) Unwrap() error {
+	return e.Err
+}
+
+// loginLimit is the subject of a scope failed logins are counted for.
+type loginLimit struct {
+	scope            string
+	subject          string
+	freeAttempts     int
+	lockoutThreshold int
+}
+
+// authenticateThrottled authenticates the user unless the login or the client address
+// failed too often recently. Failures are counted for both, a success forgets the failures
+// of the login only, so a client can't clear its record by signing in to an account of its own.
+// The failures of users with a second factor are forgotten once it is passed, see mfaPassed.
+func (a *Auth) authenticateThrottled(
+	ctx context.Context,
+	log *slog.Logger,
+	app *models.App,
+	login string,
+	password string,
+) (*models.User, bool, error) {
+	limits := a.loginLimits(ctx, login)
+	if err := a.checkLoginLimits(ctx, log, limits); err != nil {
+		return nil, false, err
+	}
+
+	user, local, err := a.authenticateUser(ctx, log, app, login, password)
+	if errors.Is(err, ErrInvalidCredentials) {
+		a.addLoginFailure(ctx, log, limits)
+		return nil, false, err
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	if !user.TOTPEnabled {
+		a.forgetLoginFailures(ctx, log, limits)
+	}
+	return user, local, nil
+}
+
+// checkMFAAttempt rejects a second factor code of the login while its password could
+// not be tried either, the codes are guessed no faster than the passwords.
+func (a *Auth) checkMFAAttempt(ctx context.Context, log *slog.Logger, login string) error {
+	return a.checkLoginLimits(ctx, log, a.loginLimits(ctx, login))
+}
+
+// mfaFailed counts an invalid second factor code as a failed login.
+func (a *Auth) mfaFailed(ctx context.Context, log *slog.Logger, login string) {
+	a.addLoginFailure(ctx, log, a.loginLimits(ctx, login))
+}
+
+// mfaPassed forgets the failures of the login after the second factor was passed.
+func (a *Auth) mfaPassed(ctx context.Context, log *slog.Logger, login string) {
+	a.forgetLoginFailures(ctx, log, a.loginLimits(ctx, login))
+}
+
+// forgetLoginFailures forgets the failures of the login, the first of the limits.
+func (a *Auth) forgetLoginFailures(ctx context.Context, log *slog.Logger, limits []loginLimit) {
+	if err := a.attemptStorage.DeleteLoginAttempts(ctx, limits[0].scope, limits[0].subject); err != nil {
+		log.Error("failed to reset login attempts", slog.String("error", err.Error()))
+	}
+}
+
+// loginLimits returns the limit of the login first, then the limit of the client address if it is known.
+func (a *Auth) loginLimits(ctx context.Context, login string) []loginLimit {
+	cfg := a.cfg.BruteForce
+	limits := []loginLimit{{
+		scope:            models.LoginScopeUser,
+		subject:          strings.ToLower(login),
+		freeAttempts:     cfg.UserFreeAttempts,
+		lockoutThreshold: cfg.UserLockoutThreshold,
+	}}
+	if ip := clientip.FromContext(ctx); ip != "" {
+		limits = append(limits, loginLimit{
+			scope:            models.LoginScopeIP,
+			subject:          ip,
+			freeAttempts:     cfg.IPFreeAttempts,
+			lockoutThreshold: cfg.IPLockoutThreshold,
+		})
+	}
+	return limits
+}
+
+func (a *Auth) checkLoginLimits(ctx context.Context, log *slog.Logger, limits []loginLimit) error {
+	now := time.Now()
+	for _, limit := range limits {
+		attempts, err := a.attemptStorage.LoginAttempts(ctx, limit.scope, limit.subject)
+		if err != nil {
+			if errors.Is(err, storage.ErrLoginAttemptsNotFound) {
+				continue
+			}
+			log.Error("failed to get login attempts", slog.String("error", err.Error()))
+			return err
+		}
+
+		if attempts.LockedUntil != nil && now.Before(*attempts.LockedUntil) {
+			log.Warn("login locked", slog.String("scope", limit.scope))
+			lockErr := ErrTooManyAttempts
+			if limit.scope == models.LoginScopeUser {
+				lockErr = ErrAccountLocked
+			}
+			return &LoginThrottledError{Err: lockErr, RetryAfter: attempts.LockedUntil.Sub(now)}
+		}
+		if now.Sub(attempts.WindowStartedAt) >= a.cfg.BruteForce.Window {
+			continue
+		}
+		delay := a.loginDelay(attempts.Failures, limit.freeAttempts)
+		if next := attempts.LastFailureAt.Add(delay); delay > 0 && now.Before(next) {
+			log.Warn("login delayed", slog.String("scope", limit.scope), slog.Int("failures", attempts.Failures))
+			return &LoginThrottledError{Err: ErrTooManyAttempts, RetryAfter: next.Sub(now)}
+		}
+	}
+	return nil
+}
+
+// addLoginFailure counts the failure for every limit and locks the limits whose threshold
+// is reached. The failure is still reported to the caller if it can't be counted.
+func (a *Auth) addLoginFailure(ctx context.Context, log *slog.Logger, limits []loginLimit) {
+	cfg := a.cfg.BruteForce
+	for _, limit := range limits {
+		attempts, err := a.attemptStorage.AddLoginFailure(ctx, limit.scope, limit.subject, time.Now().Add(-cfg.Window))
+		if err != nil {
+			log.Error("failed to count login failure", slog.String("error", err.Error()))
+			continue
+		}
+		if limit.lockoutThreshold <= 0 || attempts.Failures < limit.lockoutThreshold {
+			continue
+		}
+		if err := a.attemptStorage.LockLogin(ctx, limit.scope, limit.subject, time.Now().Add(cfg.LockoutDuration)); err != nil {
+			log.Error("failed to lock login", slog.String("error", err.Error()))
+			continue
+		}
+		log.Warn("login locked", slog.String("scope", limit.scope), slog.Int("failures", attempts.Failures))
+	}
+}
+
+// loginDelay returns how long the next attempt has to wait after the failures,
+// zero while there are free attempts left.
+func (a *Auth) loginDelay(failures, freeAttempts int) time.Duration {
+	cfg := a.cfg.BruteForce
+	if failures < freeAttempts {
+		return 0
+	}
+	delay := cfg.Delay
+	for i := freeAttempts; i < failures && delay < cfg.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, cfg.MaxDelay)
+}
+
+// UnlockUser lifts the lockout of the user and forgets its failed logins, under its email
+// and under the directory logins it signed in with. accessToken has to be a token of an admin.
+func (a *Auth) UnlockUser(ctx context.Context, accessToken string, userID int64) error {
+	const op = "auth.UnlockUser"
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("uid", userID),
+	)
+
+	claims, err := a.verifyAccountToken(ctx, accessToken)
+	if err != nil {
+		log.Warn("failed to authenticate", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if err := a.requireAdmin(ctx, log, claims); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	user, err := a.userProvider.UserByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Warn("user not found")
+			return fmt.Errorf("%s: %w", op, ErrUserNotFound)
+		}
+		log.Error("failed to get user", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	logins, err := a.directoryStorage.LDAPLogins(ctx, user.ID)
+	if err != nil {
+		log.Error("failed to get directory logins", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	for _, login := range append([]string{user.Email}, logins...) {
+		err = a.attemptStorage.DeleteLoginAttempts(ctx, models.LoginScopeUser, strings.ToLower(login))
+		if err != nil {
+			log.Error("failed to delete login attempts", slog.String("error", err.Error()))
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	log.Info("user unlocked", slog.Int64("admin_uid", claims.UID), slog.Int("directory_logins", len(logins)))
+
+	return nil
+}
+
+func (a *Auth) requireAdmin(ctx context.Context, log *slog.Logger, claims *jwt.Claims) error {
+	admin, err := a.userProvider.UserByID(ctx, claims.UID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return ErrInvalidAccessToken
+		}
+		log.Error("failed to get user", slog.String("error", err.Error()))
+		return err
+	}
+	if !admin.IsAdmin {
+		log.Warn("admin access by a non admin", slog.Int64("actor_uid", admin.ID))
+		return ErrPermissionDenied
+	}
+	return nil
+}
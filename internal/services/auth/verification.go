@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sso/internal/domain/models"
+	"sso/internal/lib/opaque"
+	"sso/internal/storage"
+	"time"
+)
+
+var (
+	ErrEmailNotVerified         = errors.New("email not verified")
+	ErrInvalidVerificationToken = errors.New("invalid email verification token")
+)
+
+const (
+	emailVerificationSubject = "Confirm your email"
+)
+
+// VerifyEmail marks the email the token was issued for as verified.
+func (a *Auth) VerifyEmail(ctx context.Context, token string) error {
+	const op = "auth.VerifyEmail"
+	log := a.log.With(slog.String("op", op))
+
+	verification, err := a.verificationStorage.UseEmailVerificationToken(ctx, opaque.Hash(token))
+	if err != nil {
+		if errors.Is(err, storage.ErrVerificationNotFound) {
+			log.Warn("verification token not found, used or expired")
+			return fmt.Errorf("%s: %w", op, ErrInvalidVerificationToken)
+		}
+		log.Error("failed to use verification token", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("uid", verification.UserID))
+
+	if err := a.userSaver.SetEmailVerified(ctx, verification.UserID, verification.Email); err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Warn("email changed since the token was issued")
+			return fmt.Errorf("%s: %w", op, ErrInvalidVerificationToken)
+		}
+		log.Error("failed to verify email", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("email verified")
+
+	return nil
+}
+
+// ResendVerificationEmail sends a new verification token, at most once per configured interval.
+// It does not report whether the email is registered.
+func (a *Auth) ResendVerificationEmail(ctx context.Context, email string) error {
+	const op = "auth.ResendVerificationEmail"
+	log := a.log.With(
+		slog.String("op", op),
+		slog.String("email", email),
+	)
+
+	user, err := a.userProvider.User(ctx, email)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Warn("user not found")
+			return nil
+		}
+		log.Error("failed to get user", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if user.EmailVerified {
+		log.Info("email already verified")
+		return nil
+	}
+
+	last, err := a.verificationStorage.LastEmailVerificationToken(ctx, user.ID)
+	if err != nil && !errors.Is(err, storage.ErrVerificationNotFound) {
+		log.Error("failed to get last verification token", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	// rejecting only registered emails would tell which emails are registered
+	if last != nil && time.Since(last.CreatedAt) < a.cfg.EmailVerification.ResendInterval {
+		log.Warn("verification email resend throttled")
+		return nil
+	}
+
+	if err := a.sendEmailVerification(ctx, user.ID, user.Email); err != nil {
+		log.Error("failed to send verification email", slog.String("error", err.Error()))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("verification email sent")
+
+	return nil
+}
+
+func (a *Auth) sendEmailVerification(ctx context.Context, userID int64, email string) error {
+	token, hash, err := opaque.New()
+	if err != nil {
+		return err
+	}
+
+	err = a.verificationStorage.SaveEmailVerificationToken(ctx, &models.EmailVerificationToken{
+		TokenHash: hash,
+		UserID:    userID,
+		Email:     email,
+		ExpiresAt: time.Now().Add(a.cfg.EmailVerification.TTL),
+	})
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf(
+		"Use the following token to confirm your email: %s\n\nThe token expires in %s.",
+		token, a.cfg.EmailVerification.TTL,
+	)
+	return a.mailer.Send(ctx, email, emailVerificationSubject, body)
+}
@@ -11,6 +11,11 @@ import (
 	"sso/internal/config"
 	"sso/internal/domain/models"
 	"sso/internal/storage"
+	"time"
+)
+
+const (
+	uniqueViolation = "23505"
 )
 
 type Storage struct {
@@ -67,7 +72,7 @@ func (s *Storage) User(ctx context.Context, email string) (*models.User, error)
 	const op = "storage.postgres.User"
 
 	user := new(models.User)
-	err := s.db.QueryRowx(`SELECT * FROM users WHERE email=$1`, email).StructScan(user)
+	err := s.db.QueryRowx(`SELECT * FROM users WHERE LOWER(email)=LOWER($1)`, email).StructScan(user)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
@@ -104,3 +109,1604 @@ func (s *Storage) App(ctx context.Context, appID int) (*models.App, error) {
 	}
 	return app, nil
 }
+
+// PasswordPolicy returns the password policy of the app.
+func (s *Storage) PasswordPolicy(ctx context.Context, appID int64) (*models.PasswordPolicy, error) {
+	const op = "storage.postgres.PasswordPolicy"
+
+	policy := new(models.PasswordPolicy)
+	err := s.db.QueryRowxContext(ctx, `SELECT * FROM app_password_policies WHERE app_id=$1`, appID).StructScan(policy)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, storage.ErrPasswordPolicyNotFound)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return policy, nil
+}
+
+// MaxPasswordHistorySize returns the largest history size of the password policies of the apps.
+func (s *Storage) MaxPasswordHistorySize(ctx context.Context) (int, error) {
+	const op = "storage.postgres.MaxPasswordHistorySize"
+
+	var size int
+	err := s.db.QueryRowxContext(ctx, `SELECT COALESCE(MAX(history_size), 0) FROM app_password_policies`).Scan(&size)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	return size, nil
+}
+
+func (s *Storage) UserByID(ctx context.Context, userID int64) (*models.User, error) {
+	const op = "storage.postgres.UserByID"
+
+	user := new(models.User)
+	err := s.db.QueryRowxContext(ctx, `SELECT * FROM users WHERE id=$1`, userID).StructScan(user)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return user, nil
+}
+
+func (s *Storage) SaveRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	const op = "storage.postgres.SaveRefreshToken"
+
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO refresh_tokens(token_hash, family_id, user_id, app_id, scope, expires_at) VALUES($1, $2, $3, $4, $5, $6)`,
+		token.TokenHash,
+		token.FamilyID,
+		token.UserID,
+		token.AppID,
+		token.Scope,
+		token.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// UseRefreshToken atomically marks the token as used and returns it.
+// If the token was already used storage.ErrRefreshTokenUsed is returned
+// together with the token, so the caller can revoke its family.
+func (s *Storage) UseRefreshToken(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	const op = "storage.postgres.UseRefreshToken"
+
+	token := new(models.RefreshToken)
+	err := s.db.QueryRowxContext(ctx,
+		`UPDATE refresh_tokens SET used_at = NOW()
+		WHERE token_hash=$1 AND used_at IS NULL
+		RETURNING *`,
+		tokenHash,
+	).StructScan(token)
+	if err == nil {
+		return token, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	err = s.db.QueryRowxContext(ctx, `SELECT * FROM refresh_tokens WHERE token_hash=$1`, tokenHash).StructScan(token)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, storage.ErrRefreshTokenNotFound)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return token, fmt.Errorf("%s: %w", op, storage.ErrRefreshTokenUsed)
+}
+
+func (s *Storage) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	const op = "storage.postgres.RevokeRefreshTokenFamily"
+
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE refresh_tokens SET revoked_at = NOW() WHERE family_id=$1 AND revoked_at IS NULL`,
+		familyID,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+func (s *Storage) RefreshToken(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	const op = "storage.postgres.RefreshToken"
+
+	token := new(models.RefreshToken)
+	err := s.db.QueryRowxContext(ctx, `SELECT * FROM refresh_tokens WHERE token_hash=$1`, tokenHash).StructScan(token)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, storage.ErrRefreshTokenNotFound)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return token, nil
+}
+
+func (s *Storage) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	const op = "storage.postgres.RevokeToken"
+
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO revoked_tokens(jti, expires_at) VALUES($1, $2) ON CONFLICT DO NOTHING`,
+		jti,
+		expiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+func (s *Storage) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	const op = "storage.postgres.IsTokenRevoked"
+
+	var revoked bool
+	err := s.db.QueryRowxContext(ctx,
+		`SELECT EXISTS(SELECT 1 FROM revoked_tokens WHERE jti=$1)`,
+		jti,
+	).Scan(&revoked)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+	return revoked, nil
+}
+
+func (s *Storage) DeleteExpiredRevokedTokens(ctx context.Context) (int64, error) {
+	const op = "storage.postgres.DeleteExpiredRevokedTokens"
+
+	res, err := s.db.ExecContext(ctx, `DELETE FROM revoked_tokens WHERE expires_at < NOW()`)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	return deleted, nil
+}
+
+func (s *Storage) Apps(ctx context.Context) ([]models.App, error) {
+	const op = "storage.postgres.Apps"
+
+	var apps []models.App
+	err := s.db.SelectContext(ctx, &apps, `SELECT * FROM apps ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return apps, nil
+}
+
+func (s *Storage) SaveSigningKey(ctx context.Context, key *models.SigningKey) error {
+	const op = "storage.postgres.SaveSigningKey"
+
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO signing_keys(kid, app_id, algorithm, private_key, public_key, activates_at)
+		VALUES($1, $2, $3, $4, $5, $6)`,
+		key.Kid,
+		key.AppID,
+		key.Algorithm,
+		key.PrivateKey,
+		key.PublicKey,
+		key.ActivatesAt,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// SaveFirstSigningKey saves the key unless the app already has a key to sign with,
+// which is returned instead. Concurrent calls for an app are serialized by an advisory lock.
+func (s *Storage) SaveFirstSigningKey(ctx context.Context, key *models.SigningKey) (_ *models.SigningKey, err error) {
+	const op = "storage.postgres.SaveFirstSigningKey"
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	_, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext('signing_keys'), $1)`, key.AppID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	current := new(models.SigningKey)
+	err = tx.QueryRowxContext(ctx,
+		`SELECT * FROM signing_keys
+		WHERE app_id=$1 AND activates_at <= NOW() AND (retires_at IS NULL OR retires_at > NOW())
+		ORDER BY activates_at DESC, id DESC LIMIT 1`,
+		key.AppID,
+	).StructScan(current)
+	switch {
+	case err == nil:
+		key = current
+	case errors.Is(err, sql.ErrNoRows):
+		err = tx.QueryRowxContext(ctx,
+			`INSERT INTO signing_keys(kid, app_id, algorithm, private_key, public_key, activates_at)
+			VALUES($1, $2, $3, $4, $5, $6) RETURNING *`,
+			key.Kid,
+			key.AppID,
+			key.Algorithm,
+			key.PrivateKey,
+			key.PublicKey,
+			key.ActivatesAt,
+		).StructScan(current)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		key = current
+	default:
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return key, nil
+}
+
+// SigningKey returns the key the app currently signs with:
+// the most recently activated key which is not retired.
+func (s *Storage) SigningKey(ctx context.Context, appID int64) (*models.SigningKey, error) {
+	const op = "storage.postgres.SigningKey"
+
+	key := new(models.SigningKey)
+	err := s.db.QueryRowxContext(ctx,
+		`SELECT * FROM signing_keys
+		WHERE app_id=$1 AND activates_at <= NOW() AND (retires_at IS NULL OR retires_at > NOW())
+		ORDER BY activates_at DESC, id DESC LIMIT 1`,
+		appID,
+	).StructScan(key)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, storage.ErrSigningKeyNotFound)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return key, nil
+}
+
+func (s *Storage) SigningKeyByKid(ctx context.Context, kid string) (*models.SigningKey, error) {
+	const op = "storage.postgres.SigningKeyByKid"
+
+	key := new(models.SigningKey)
+	err := s.db.QueryRowxContext(ctx,
+		`SELECT * FROM signing_keys WHERE kid=$1 AND (retires_at IS NULL OR retires_at > NOW())`,
+		kid,
+	).StructScan(key)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, storage.ErrSigningKeyNotFound)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return key, nil
+}
+
+// SigningKeys returns all published keys, i.e. keys which are not retired yet.
+func (s *Storage) SigningKeys(ctx context.Context) ([]models.SigningKey, error) {
+	const op = "storage.postgres.SigningKeys"
+
+	var keys []models.SigningKey
+	err := s.db.SelectContext(ctx, &keys,
+		`SELECT * FROM signing_keys WHERE retires_at IS NULL OR retires_at > NOW() ORDER BY id`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return keys, nil
+}
+
+// RetireSigningKeys schedules retirement of all app keys except kid
+// which do not have a retirement scheduled yet.
+func (s *Storage) RetireSigningKeys(ctx context.Context, appID int64, exceptKid string, retiresAt time.Time) error {
+	const op = "storage.postgres.RetireSigningKeys"
+
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE signing_keys SET retires_at=$1 WHERE app_id=$2 AND kid<>$3 AND retires_at IS NULL`,
+		retiresAt,
+		appID,
+		exceptKid,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+func (s *Storage) UpdatePassword(ctx context.Context, userID int64, passHash string) error {
+	const op = "storage.postgres.UpdatePassword"
+
+	res, err := s.db.ExecContext(ctx, `UPDATE users SET pass_hash=$1 WHERE id=$2`, passHash, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+	return nil
+}
+
+// SetPassword replaces the password of the user, moves the current one to the history
+// and keeps only the keep latest passwords in the history.
+func (s *Storage) SetPassword(ctx context.Context, userID int64, passHash string, keep int) (err error) {
+	const op = "storage.postgres.SetPassword"
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	res, err := tx.ExecContext(ctx,
+		`INSERT INTO password_history(user_id, pass_hash) SELECT id, pass_hash FROM users WHERE id=$1`,
+		userID,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE users SET pass_hash=$1, password_changed_at=NOW() WHERE id=$2`,
+		passHash,
+		userID,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`DELETE FROM password_history WHERE user_id=$1 AND id NOT IN (
+			SELECT id FROM password_history WHERE user_id=$1 ORDER BY id DESC LIMIT $2
+		)`,
+		userID,
+		keep,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// PasswordHistory returns the hashes of the limit latest previous passwords of the user, latest first.
+func (s *Storage) PasswordHistory(ctx context.Context, userID int64, limit int) ([]string, error) {
+	const op = "storage.postgres.PasswordHistory"
+
+	var hashes []string
+	err := s.db.SelectContext(ctx, &hashes,
+		`SELECT pass_hash FROM password_history WHERE user_id=$1 ORDER BY id DESC LIMIT $2`,
+		userID,
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return hashes, nil
+}
+
+func (s *Storage) SavePasswordChangeTicket(ctx context.Context, ticket *models.PasswordChangeTicket) error {
+	const op = "storage.postgres.SavePasswordChangeTicket"
+
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO password_change_tickets(ticket_hash, user_id, app_id, expires_at) VALUES($1, $2, $3, $4)`,
+		ticket.TicketHash,
+		ticket.UserID,
+		ticket.AppID,
+		ticket.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// PasswordChangeTicket returns the ticket if it is neither used nor expired.
+func (s *Storage) PasswordChangeTicket(ctx context.Context, ticketHash string) (*models.PasswordChangeTicket, error) {
+	const op = "storage.postgres.PasswordChangeTicket"
+
+	ticket := new(models.PasswordChangeTicket)
+	err := s.db.QueryRowxContext(ctx,
+		`SELECT * FROM password_change_tickets WHERE ticket_hash=$1 AND used_at IS NULL AND expires_at > NOW()`,
+		ticketHash,
+	).StructScan(ticket)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, storage.ErrPasswordChangeNotFound)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return ticket, nil
+}
+
+// UsePasswordChangeTicket atomically marks the ticket as used.
+func (s *Storage) UsePasswordChangeTicket(ctx context.Context, ticketID int64) error {
+	const op = "storage.postgres.UsePasswordChangeTicket"
+
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE password_change_tickets SET used_at = NOW() WHERE id=$1 AND used_at IS NULL`,
+		ticketID,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrPasswordChangeNotFound)
+	}
+	return nil
+}
+
+func (s *Storage) LoginAttempts(ctx context.Context, scope, subject string) (*models.LoginAttempts, error) {
+	const op = "storage.postgres.LoginAttempts"
+
+	attempts := new(models.LoginAttempts)
+	err := s.db.QueryRowxContext(ctx,
+		`SELECT * FROM login_attempts WHERE scope=$1 AND subject=$2`,
+		scope,
+		subject,
+	).StructScan(attempts)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, storage.ErrLoginAttemptsNotFound)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return attempts, nil
+}
+
+// AddLoginFailure counts a failed login of the subject and returns its attempts.
+// The count starts over if the window started before windowStart.
+func (s *Storage) AddLoginFailure(ctx context.Context, scope, subject string, windowStart time.Time) (*models.LoginAttempts, error) {
+	const op = "storage.postgres.AddLoginFailure"
+
+	attempts := new(models.LoginAttempts)
+	err := s.db.QueryRowxContext(ctx,
+		`INSERT INTO login_attempts(scope, subject, failures) VALUES($1, $2, 1)
+		ON CONFLICT (scope, subject) DO UPDATE SET
+			failures = CASE WHEN login_attempts.window_started_at < $3 THEN 1 ELSE login_attempts.failures + 1 END,
+			window_started_at = CASE WHEN login_attempts.window_started_at < $3 THEN NOW() ELSE login_attempts.window_started_at END,
+			last_failure_at = NOW()
+		RETURNING *`,
+		scope,
+		subject,
+		windowStart,
+	).StructScan(attempts)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return attempts, nil
+}
+
+func (s *Storage) LockLogin(ctx context.Context, scope, subject string, until time.Time) error {
+	const op = "storage.postgres.LockLogin"
+
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE login_attempts SET locked_until=$3 WHERE scope=$1 AND subject=$2`,
+		scope,
+		subject,
+		until,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// DeleteLoginAttempts forgets the failures of the subject and lifts its lock.
+func (s *Storage) DeleteLoginAttempts(ctx context.Context, scope, subject string) error {
+	const op = "storage.postgres.DeleteLoginAttempts"
+
+	_, err := s.db.ExecContext(ctx,
+		`DELETE FROM login_attempts WHERE scope=$1 AND subject=$2`,
+		scope,
+		subject,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// RevokeUserTokens revokes the refresh tokens of the user and rejects its access tokens
+// issued before now. The access tokens carry the issue time in whole seconds, so do the users.
+func (s *Storage) RevokeUserTokens(ctx context.Context, userID int64) (err error) {
+	const op = "storage.postgres.RevokeUserTokens"
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id=$1 AND revoked_at IS NULL`,
+		userID,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	_, err = tx.ExecContext(ctx,
+		`UPDATE users SET tokens_valid_after = date_trunc('second', NOW()) WHERE id=$1`,
+		userID,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+func (s *Storage) SavePasswordResetToken(ctx context.Context, token *models.PasswordResetToken) error {
+	const op = "storage.postgres.SavePasswordResetToken"
+
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO password_reset_tokens(token_hash, user_id, expires_at) VALUES($1, $2, $3)`,
+		token.TokenHash,
+		token.UserID,
+		token.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// UsePasswordResetToken atomically marks an unused and unexpired token as used and returns it.
+// PasswordResetToken returns the reset token if it is neither used nor expired.
+func (s *Storage) PasswordResetToken(ctx context.Context, tokenHash string) (*models.PasswordResetToken, error) {
+	const op = "storage.postgres.PasswordResetToken"
+
+	token := new(models.PasswordResetToken)
+	err := s.db.QueryRowxContext(ctx,
+		`SELECT * FROM password_reset_tokens WHERE token_hash=$1 AND used_at IS NULL AND expires_at > NOW()`,
+		tokenHash,
+	).StructScan(token)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, storage.ErrResetTokenNotFound)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return token, nil
+}
+
+// UsePasswordResetToken marks the token used, along with the other outstanding tokens of its user.
+func (s *Storage) UsePasswordResetToken(ctx context.Context, tokenHash string) (*models.PasswordResetToken, error) {
+	const op = "storage.postgres.UsePasswordResetToken"
+
+	token := new(models.PasswordResetToken)
+	err := s.db.QueryRowxContext(ctx,
+		`WITH used AS (
+			UPDATE password_reset_tokens SET used_at = NOW()
+			WHERE token_hash=$1 AND used_at IS NULL AND expires_at > NOW()
+			RETURNING *
+		), others AS (
+			UPDATE password_reset_tokens SET used_at = NOW()
+			WHERE user_id IN (SELECT user_id FROM used) AND token_hash<>$1 AND used_at IS NULL
+		)
+		SELECT * FROM used`,
+		tokenHash,
+	).StructScan(token)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, storage.ErrResetTokenNotFound)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return token, nil
+}
+
+// SetEmailVerified marks the email as verified if it is still the email of the user.
+func (s *Storage) SetEmailVerified(ctx context.Context, userID int64, email string) error {
+	const op = "storage.postgres.SetEmailVerified"
+
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE users SET email_verified=TRUE WHERE id=$1 AND email=$2`,
+		userID,
+		email,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+	return nil
+}
+
+func (s *Storage) SaveEmailVerificationToken(ctx context.Context, token *models.EmailVerificationToken) error {
+	const op = "storage.postgres.SaveEmailVerificationToken"
+
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO email_verification_tokens(token_hash, user_id, email, expires_at) VALUES($1, $2, $3, $4)`,
+		token.TokenHash,
+		token.UserID,
+		token.Email,
+		token.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// UseEmailVerificationToken atomically marks an unused and unexpired token as used and returns it.
+func (s *Storage) UseEmailVerificationToken(ctx context.Context, tokenHash string) (*models.EmailVerificationToken, error) {
+	const op = "storage.postgres.UseEmailVerificationToken"
+
+	token := new(models.EmailVerificationToken)
+	err := s.db.QueryRowxContext(ctx,
+		`UPDATE email_verification_tokens SET used_at = NOW()
+		WHERE token_hash=$1 AND used_at IS NULL AND expires_at > NOW()
+		RETURNING *`,
+		tokenHash,
+	).StructScan(token)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, storage.ErrVerificationNotFound)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return token, nil
+}
+
+func (s *Storage) LastEmailVerificationToken(ctx context.Context, userID int64) (*models.EmailVerificationToken, error) {
+	const op = "storage.postgres.LastEmailVerificationToken"
+
+	token := new(models.EmailVerificationToken)
+	err := s.db.QueryRowxContext(ctx,
+		`SELECT * FROM email_verification_tokens WHERE user_id=$1 ORDER BY created_at DESC, id DESC LIMIT 1`,
+		userID,
+	).StructScan(token)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, storage.ErrVerificationNotFound)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return token, nil
+}
+
+func (s *Storage) SaveEmailChangeToken(ctx context.Context, token *models.EmailChangeToken) error {
+	const op = "storage.postgres.SaveEmailChangeToken"
+
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO email_change_tokens(token_hash, user_id, old_email, new_email, expires_at) VALUES($1, $2, $3, $4, $5)`,
+		token.TokenHash,
+		token.UserID,
+		token.OldEmail,
+		token.NewEmail,
+		token.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// UseEmailChangeToken atomically marks an unused and unexpired token as used and returns it.
+func (s *Storage) UseEmailChangeToken(ctx context.Context, tokenHash string) (*models.EmailChangeToken, error) {
+	const op = "storage.postgres.UseEmailChangeToken"
+
+	token := new(models.EmailChangeToken)
+	err := s.db.QueryRowxContext(ctx,
+		`UPDATE email_change_tokens SET used_at = NOW()
+		WHERE token_hash=$1 AND used_at IS NULL AND expires_at > NOW()
+		RETURNING *`,
+		tokenHash,
+	).StructScan(token)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, storage.ErrEmailChangeNotFound)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return token, nil
+}
+
+// ChangeEmail replaces the email of the user if it is still oldEmail, the new email is verified.
+func (s *Storage) ChangeEmail(ctx context.Context, userID int64, oldEmail, newEmail string) error {
+	const op = "storage.postgres.ChangeEmail"
+
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE users SET email=$1, email_verified=TRUE WHERE id=$2 AND email=$3`,
+		newEmail,
+		userID,
+		oldEmail,
+	)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+			return fmt.Errorf("%s: %w", op, storage.ErrUserExists)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+	return nil
+}
+
+// SaveTOTPSecret stores a pending TOTP secret, it is enabled by EnableTOTP.
+func (s *Storage) SaveTOTPSecret(ctx context.Context, userID int64, secret string) error {
+	const op = "storage.postgres.SaveTOTPSecret"
+
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE users SET totp_secret=$1, totp_enabled=FALSE, totp_last_step=0 WHERE id=$2`,
+		secret,
+		userID,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+func (s *Storage) EnableTOTP(ctx context.Context, userID int64) error {
+	const op = "storage.postgres.EnableTOTP"
+
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE users SET totp_enabled=TRUE WHERE id=$1 AND totp_secret IS NOT NULL`,
+		userID,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// UseTOTPStep records the time step of an accepted code.
+// It returns storage.ErrTOTPStepUsed if the step or a later one was already used.
+func (s *Storage) UseTOTPStep(ctx context.Context, userID int64, step int64) error {
+	const op = "storage.postgres.UseTOTPStep"
+
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE users SET totp_last_step=$1 WHERE id=$2 AND totp_last_step < $1`,
+		step,
+		userID,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrTOTPStepUsed)
+	}
+	return nil
+}
+
+func (s *Storage) SaveMFAChallenge(ctx context.Context, challenge *models.MFAChallenge) error {
+	const op = "storage.postgres.SaveMFAChallenge"
+
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO mfa_challenges(challenge_hash, user_id, app_id, password_expired, expires_at)
+		VALUES($1, $2, $3, $4, $5)`,
+		challenge.ChallengeHash,
+		challenge.UserID,
+		challenge.AppID,
+		challenge.PasswordExpired,
+		challenge.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// MFAChallenge returns an unused and unexpired challenge with fewer than maxAttempts attempts.
+func (s *Storage) MFAChallenge(ctx context.Context, challengeHash string, maxAttempts int) (*models.MFAChallenge, error) {
+	const op = "storage.postgres.MFAChallenge"
+
+	challenge := new(models.MFAChallenge)
+	err := s.db.QueryRowxContext(ctx,
+		`SELECT * FROM mfa_challenges
+		WHERE challenge_hash=$1 AND used_at IS NULL AND expires_at > NOW() AND attempts < $2`,
+		challengeHash,
+		maxAttempts,
+	).StructScan(challenge)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, storage.ErrChallengeNotFound)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return challenge, nil
+}
+
+func (s *Storage) AddMFAChallengeAttempt(ctx context.Context, challengeID int64) error {
+	const op = "storage.postgres.AddMFAChallengeAttempt"
+
+	_, err := s.db.ExecContext(ctx, `UPDATE mfa_challenges SET attempts = attempts + 1 WHERE id=$1`, challengeID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// UseMFAChallenge atomically marks the challenge as used.
+func (s *Storage) UseMFAChallenge(ctx context.Context, challengeID int64) error {
+	const op = "storage.postgres.UseMFAChallenge"
+
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE mfa_challenges SET used_at = NOW() WHERE id=$1 AND used_at IS NULL`,
+		challengeID,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrChallengeNotFound)
+	}
+	return nil
+}
+
+// ReplaceRecoveryCodes deletes all recovery codes of the user, including used ones, and saves the new ones.
+func (s *Storage) ReplaceRecoveryCodes(ctx context.Context, userID int64, codeHashes []string) (err error) {
+	const op = "storage.postgres.ReplaceRecoveryCodes"
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id=$1`, userID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	for _, hash := range codeHashes {
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO recovery_codes(user_id, code_hash) VALUES($1, $2)`,
+			userID,
+			hash,
+		)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// UseRecoveryCode atomically marks an unused recovery code of the user as used.
+func (s *Storage) UseRecoveryCode(ctx context.Context, userID int64, codeHash string) error {
+	const op = "storage.postgres.UseRecoveryCode"
+
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE recovery_codes SET used_at = NOW() WHERE user_id=$1 AND code_hash=$2 AND used_at IS NULL`,
+		userID,
+		codeHash,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrRecoveryCodeNotFound)
+	}
+	return nil
+}
+
+func (s *Storage) SavePasskeyCredential(ctx context.Context, cred *models.PasskeyCredential) error {
+	const op = "storage.postgres.SavePasskeyCredential"
+
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO passkey_credentials(
+			user_id, credential_id, public_key, attestation_type, aaguid,
+			sign_count, transports, backup_eligible, backup_state
+		) VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		cred.UserID,
+		cred.CredentialID,
+		cred.PublicKey,
+		cred.AttestationType,
+		cred.AAGUID,
+		cred.SignCount,
+		cred.Transports,
+		cred.BackupEligible,
+		cred.BackupState,
+	)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+			return fmt.Errorf("%s: %w", op, storage.ErrPasskeyExists)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+func (s *Storage) PasskeyCredentials(ctx context.Context, userID int64) ([]models.PasskeyCredential, error) {
+	const op = "storage.postgres.PasskeyCredentials"
+
+	var creds []models.PasskeyCredential
+	err := s.db.SelectContext(ctx, &creds, `SELECT * FROM passkey_credentials WHERE user_id=$1 ORDER BY id`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return creds, nil
+}
+
+func (s *Storage) UpdatePasskeyUsage(ctx context.Context, credentialID []byte, signCount int64, backupState bool) error {
+	const op = "storage.postgres.UpdatePasskeyUsage"
+
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE passkey_credentials SET sign_count=$1, backup_state=$2, last_used_at=NOW() WHERE credential_id=$3`,
+		signCount,
+		backupState,
+		credentialID,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+func (s *Storage) SaveWebAuthnSession(ctx context.Context, session *models.WebAuthnSession) error {
+	const op = "storage.postgres.SaveWebAuthnSession"
+
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO webauthn_sessions(session_hash, user_id, app_id, data, expires_at) VALUES($1, $2, $3, $4, $5)`,
+		session.SessionHash,
+		session.UserID,
+		session.AppID,
+		session.Data,
+		session.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// TakeWebAuthnSession deletes an unexpired session and returns it, so every session is used once.
+func (s *Storage) TakeWebAuthnSession(ctx context.Context, sessionHash string) (*models.WebAuthnSession, error) {
+	const op = "storage.postgres.TakeWebAuthnSession"
+
+	session := new(models.WebAuthnSession)
+	err := s.db.QueryRowxContext(ctx,
+		`DELETE FROM webauthn_sessions WHERE session_hash=$1 AND expires_at > NOW() RETURNING *`,
+		sessionHash,
+	).StructScan(session)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, storage.ErrSessionNotFound)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return session, nil
+}
+
+func (s *Storage) RedirectURIs(ctx context.Context, appID int64) ([]string, error) {
+	const op = "storage.postgres.RedirectURIs"
+
+	var uris []string
+	err := s.db.SelectContext(ctx, &uris, `SELECT redirect_uri FROM app_redirect_uris WHERE app_id=$1`, appID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return uris, nil
+}
+
+func (s *Storage) SaveAuthorizationCode(ctx context.Context, code *models.AuthorizationCode) error {
+	const op = "storage.postgres.SaveAuthorizationCode"
+
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO authorization_codes(
+			code_hash, family_id, user_id, app_id, redirect_uri, scope, nonce,
+			code_challenge, code_challenge_method, auth_time, expires_at
+		) VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		code.CodeHash,
+		code.FamilyID,
+		code.UserID,
+		code.AppID,
+		code.RedirectURI,
+		code.Scope,
+		code.Nonce,
+		code.CodeChallenge,
+		code.CodeChallengeMethod,
+		code.AuthTime,
+		code.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// UseAuthorizationCode atomically marks an unused and unexpired code as used and returns it.
+// If the code was already used, it is returned together with storage.ErrAuthCodeUsed.
+func (s *Storage) UseAuthorizationCode(ctx context.Context, codeHash string) (*models.AuthorizationCode, error) {
+	const op = "storage.postgres.UseAuthorizationCode"
+
+	code := new(models.AuthorizationCode)
+	err := s.db.QueryRowxContext(ctx,
+		`UPDATE authorization_codes SET used_at = NOW()
+		WHERE code_hash=$1 AND used_at IS NULL AND expires_at > NOW()
+		RETURNING *`,
+		codeHash,
+	).StructScan(code)
+	if err == nil {
+		return code, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	err = s.db.QueryRowxContext(ctx,
+		`SELECT * FROM authorization_codes WHERE code_hash=$1 AND used_at IS NOT NULL`,
+		codeHash,
+	).StructScan(code)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, storage.ErrAuthCodeNotFound)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return code, fmt.Errorf("%s: %w", op, storage.ErrAuthCodeUsed)
+}
+
+func (s *Storage) SaveClient(ctx context.Context, client *models.Client) error {
+	const op = "storage.postgres.SaveClient"
+
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO oauth_clients(client_id, secret_hash, app_id, scopes) VALUES($1, $2, $3, $4)`,
+		client.ClientID,
+		client.SecretHash,
+		client.AppID,
+		client.Scopes,
+	)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+			return fmt.Errorf("%s: %w", op, storage.ErrClientExists)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+func (s *Storage) Client(ctx context.Context, clientID string) (*models.Client, error) {
+	const op = "storage.postgres.Client"
+
+	client := new(models.Client)
+	err := s.db.QueryRowxContext(ctx, `SELECT * FROM oauth_clients WHERE client_id=$1`, clientID).StructScan(client)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, storage.ErrClientNotFound)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return client, nil
+}
+
+func (s *Storage) SaveDeviceCode(ctx context.Context, code *models.DeviceCode) error {
+	const op = "storage.postgres.SaveDeviceCode"
+
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO device_codes(device_code_hash, user_code, app_id, scope, poll_interval, expires_at)
+		VALUES($1, $2, $3, $4, $5, $6)`,
+		code.DeviceCodeHash,
+		code.UserCode,
+		code.AppID,
+		code.Scope,
+		code.PollInterval,
+		code.ExpiresAt,
+	)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+			return fmt.Errorf("%s: %w", op, storage.ErrDeviceCodeExists)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+func (s *Storage) DeviceCode(ctx context.Context, deviceCodeHash string) (*models.DeviceCode, error) {
+	const op = "storage.postgres.DeviceCode"
+
+	code := new(models.DeviceCode)
+	err := s.db.QueryRowxContext(ctx,
+		`SELECT * FROM device_codes WHERE device_code_hash=$1`,
+		deviceCodeHash,
+	).StructScan(code)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, storage.ErrDeviceCodeNotFound)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return code, nil
+}
+
+// PollDeviceCode records a token request of the device, slowDown increases the poll interval by 5 seconds.
+func (s *Storage) PollDeviceCode(ctx context.Context, id int64, slowDown bool) error {
+	const op = "storage.postgres.PollDeviceCode"
+
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE device_codes
+		SET last_polled_at = NOW(), poll_interval = poll_interval + CASE WHEN $2 THEN 5 ELSE 0 END
+		WHERE id=$1`,
+		id,
+		slowDown,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// PendingDeviceCode returns the unexpired device code waiting for the user's decision.
+func (s *Storage) PendingDeviceCode(ctx context.Context, userCode string) (*models.DeviceCode, error) {
+	const op = "storage.postgres.PendingDeviceCode"
+
+	code := new(models.DeviceCode)
+	err := s.db.QueryRowxContext(ctx,
+		`SELECT * FROM device_codes WHERE user_code=$1 AND status=$2 AND expires_at > NOW()`,
+		userCode,
+		models.DeviceCodePending,
+	).StructScan(code)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, storage.ErrDeviceCodeNotFound)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return code, nil
+}
+
+// DecideDeviceCode sets the status of a pending and unexpired device code
+// to approved or denied on behalf of the user.
+func (s *Storage) DecideDeviceCode(ctx context.Context, id int64, userID int64, status string) error {
+	const op = "storage.postgres.DecideDeviceCode"
+
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE device_codes SET status=$1, user_id=$2
+		WHERE id=$3 AND status=$4 AND expires_at > NOW()`,
+		status,
+		userID,
+		id,
+		models.DeviceCodePending,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrDeviceCodeNotFound)
+	}
+	return nil
+}
+
+// UseDeviceCode atomically marks an approved device code as used, so tokens are issued once.
+func (s *Storage) UseDeviceCode(ctx context.Context, id int64) error {
+	const op = "storage.postgres.UseDeviceCode"
+
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE device_codes SET used_at = NOW()
+		WHERE id=$1 AND status=$2 AND used_at IS NULL AND expires_at > NOW()`,
+		id,
+		models.DeviceCodeApproved,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrDeviceCodeNotFound)
+	}
+	return nil
+}
+
+func (s *Storage) TokenExchangePolicy(ctx context.Context, clientID string, audienceAppID int64) (*models.TokenExchangePolicy, error) {
+	const op = "storage.postgres.TokenExchangePolicy"
+
+	policy := new(models.TokenExchangePolicy)
+	err := s.db.QueryRowxContext(ctx,
+		`SELECT * FROM token_exchange_policies WHERE client_id=$1 AND audience_app_id=$2`,
+		clientID,
+		audienceAppID,
+	).StructScan(policy)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, storage.ErrPolicyNotFound)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return policy, nil
+}
+
+func (s *Storage) SaveTokenExchangeAudit(ctx context.Context, entry *models.TokenExchangeAudit) error {
+	const op = "storage.postgres.SaveTokenExchangeAudit"
+
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO token_exchange_audit(token_id, client_id, subject_user_id, actor_user_id, audience_app_id, scope)
+		VALUES($1, $2, $3, $4, $5, $6)`,
+		entry.TokenID,
+		entry.ClientID,
+		entry.SubjectUserID,
+		entry.ActorUserID,
+		entry.AudienceAppID,
+		entry.Scope,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+func (s *Storage) IdentityProvider(ctx context.Context, appID int64, name string) (*models.IdentityProvider, error) {
+	const op = "storage.postgres.IdentityProvider"
+
+	provider := new(models.IdentityProvider)
+	err := s.db.QueryRowxContext(ctx,
+		`SELECT * FROM identity_providers WHERE app_id=$1 AND name=$2`,
+		appID,
+		name,
+	).StructScan(provider)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, storage.ErrProviderNotFound)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return provider, nil
+}
+
+func (s *Storage) IdentityProviderByID(ctx context.Context, id int64) (*models.IdentityProvider, error) {
+	const op = "storage.postgres.IdentityProviderByID"
+
+	provider := new(models.IdentityProvider)
+	err := s.db.QueryRowxContext(ctx, `SELECT * FROM identity_providers WHERE id=$1`, id).StructScan(provider)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, storage.ErrProviderNotFound)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return provider, nil
+}
+
+func (s *Storage) SaveFederatedLogin(ctx context.Context, login *models.FederatedLogin) error {
+	const op = "storage.postgres.SaveFederatedLogin"
+
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO federated_logins(state_hash, provider_id, redirect_uri, nonce, code_verifier, expires_at)
+		VALUES($1, $2, $3, $4, $5, $6)`,
+		login.StateHash,
+		login.ProviderID,
+		login.RedirectURI,
+		login.Nonce,
+		login.CodeVerifier,
+		login.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// TakeFederatedLogin deletes and returns an unexpired federated login, so a state is used once.
+func (s *Storage) TakeFederatedLogin(ctx context.Context, stateHash string) (*models.FederatedLogin, error) {
+	const op = "storage.postgres.TakeFederatedLogin"
+
+	login := new(models.FederatedLogin)
+	err := s.db.QueryRowxContext(ctx,
+		`DELETE FROM federated_logins WHERE state_hash=$1 AND expires_at > NOW() RETURNING *`,
+		stateHash,
+	).StructScan(login)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, storage.ErrFederatedLoginNotFound)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return login, nil
+}
+
+func (s *Storage) UserIdentity(ctx context.Context, providerID int64, subject string) (*models.UserIdentity, error) {
+	const op = "storage.postgres.UserIdentity"
+
+	identity := new(models.UserIdentity)
+	err := s.db.QueryRowxContext(ctx,
+		`SELECT * FROM user_identities WHERE provider_id=$1 AND subject=$2`,
+		providerID,
+		subject,
+	).StructScan(identity)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, storage.ErrIdentityNotFound)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return identity, nil
+}
+
+// SaveUserIdentity links the identity to its user. A user has at most one identity per provider.
+func (s *Storage) SaveUserIdentity(ctx context.Context, identity *models.UserIdentity) error {
+	const op = "storage.postgres.SaveUserIdentity"
+
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO user_identities(user_id, provider_id, subject) VALUES($1, $2, $3)`,
+		identity.UserID,
+		identity.ProviderID,
+		identity.Subject,
+	)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+			return fmt.Errorf("%s: %w", op, storage.ErrIdentityExists)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// SaveFederatedUser creates a user with a verified email together with its upstream identity.
+func (s *Storage) SaveFederatedUser(ctx context.Context, email, passHash string, providerID int64, subject string) (uid int64, err error) {
+	const op = "storage.postgres.SaveFederatedUser"
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var pqErr *pq.Error
+	err = tx.QueryRowxContext(ctx,
+		`INSERT INTO users(email, pass_hash, email_verified) VALUES($1, $2, TRUE) RETURNING id`,
+		email,
+		passHash,
+	).Scan(&uid)
+	if err != nil {
+		if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrUserExists)
+		}
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO user_identities(user_id, provider_id, subject) VALUES($1, $2, $3)`,
+		uid,
+		providerID,
+		subject,
+	)
+	if err != nil {
+		if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrIdentityExists)
+		}
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	return uid, nil
+}
+
+func (s *Storage) DeleteUserIdentity(ctx context.Context, userID, providerID int64) error {
+	const op = "storage.postgres.DeleteUserIdentity"
+
+	res, err := s.db.ExecContext(ctx,
+		`DELETE FROM user_identities WHERE user_id=$1 AND provider_id=$2`,
+		userID,
+		providerID,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrIdentityNotFound)
+	}
+	return nil
+}
+
+func (s *Storage) LDAPDirectory(ctx context.Context, appID int64) (*models.LDAPDirectory, error) {
+	const op = "storage.postgres.LDAPDirectory"
+
+	dir := new(models.LDAPDirectory)
+	err := s.db.QueryRowxContext(ctx, `SELECT * FROM ldap_directories WHERE app_id=$1`, appID).StructScan(dir)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, storage.ErrDirectoryNotFound)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return dir, nil
+}
+
+func (s *Storage) LDAPGroupRoles(ctx context.Context, directoryID int64) ([]models.LDAPGroupRole, error) {
+	const op = "storage.postgres.LDAPGroupRoles"
+
+	var roles []models.LDAPGroupRole
+	err := s.db.SelectContext(ctx, &roles, `SELECT * FROM ldap_group_roles WHERE directory_id=$1 ORDER BY id`, directoryID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return roles, nil
+}
+
+// IsLDAPUser reports whether the user was provisioned by the directory or linked to it.
+func (s *Storage) IsLDAPUser(ctx context.Context, directoryID, userID int64) (bool, error) {
+	const op = "storage.postgres.IsLDAPUser"
+
+	var exists bool
+	err := s.db.QueryRowxContext(ctx,
+		`SELECT EXISTS(SELECT 1 FROM ldap_users WHERE directory_id=$1 AND user_id=$2)`,
+		directoryID,
+		userID,
+	).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+	return exists, nil
+}
+
+func (s *Storage) SaveLDAPUser(ctx context.Context, directoryID, userID int64) error {
+	const op = "storage.postgres.SaveLDAPUser"
+
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO ldap_users(directory_id, user_id) VALUES($1, $2) ON CONFLICT DO NOTHING`,
+		directoryID,
+		userID,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// SaveLDAPLogin records the login the user signed in to the directory with,
+// a login of another entry of the directory is taken over.
+func (s *Storage) SaveLDAPLogin(ctx context.Context, directoryID, userID int64, login string) error {
+	const op = "storage.postgres.SaveLDAPLogin"
+
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO ldap_user_logins(directory_id, login, user_id) VALUES($1, $2, $3)
+		ON CONFLICT (directory_id, login) DO UPDATE SET user_id = EXCLUDED.user_id`,
+		directoryID,
+		login,
+		userID,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// LDAPLogins returns the directory logins the user signed in with.
+func (s *Storage) LDAPLogins(ctx context.Context, userID int64) ([]string, error) {
+	const op = "storage.postgres.LDAPLogins"
+
+	var logins []string
+	err := s.db.SelectContext(ctx, &logins, `SELECT DISTINCT login FROM ldap_user_logins WHERE user_id=$1`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return logins, nil
+}
+
+// ReplaceUserRoles replaces all roles of the user in the app.
+func (s *Storage) ReplaceUserRoles(ctx context.Context, userID, appID int64, roles []string) (err error) {
+	const op = "storage.postgres.ReplaceUserRoles"
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.ExecContext(ctx, `DELETE FROM user_roles WHERE user_id=$1 AND app_id=$2`, userID, appID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	for _, role := range roles {
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO user_roles(user_id, app_id, role) VALUES($1, $2, $3) ON CONFLICT DO NOTHING`,
+			userID,
+			appID,
+			role,
+		)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+func (s *Storage) UserRoles(ctx context.Context, userID, appID int64) ([]string, error) {
+	const op = "storage.postgres.UserRoles"
+
+	var roles []string
+	err := s.db.SelectContext(ctx, &roles,
+		`SELECT role FROM user_roles WHERE user_id=$1 AND app_id=$2 ORDER BY role`,
+		userID,
+		appID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return roles, nil
+}
@@ -3,7 +3,33 @@ package storage
 import "errors"
 
 var (
-	ErrUserExists   = errors.New("user already exists")
-	ErrUserNotFound = errors.New("user bot found")
-	ErrAppNotFound  = errors.New("app not found")
+	ErrUserExists             = errors.New("user already exists")
+	ErrUserNotFound           = errors.New("user bot found")
+	ErrAppNotFound            = errors.New("app not found")
+	ErrRefreshTokenNotFound   = errors.New("refresh token not found")
+	ErrRefreshTokenUsed       = errors.New("refresh token already used")
+	ErrSigningKeyNotFound     = errors.New("signing key not found")
+	ErrResetTokenNotFound     = errors.New("password reset token not found")
+	ErrVerificationNotFound   = errors.New("email verification token not found")
+	ErrChallengeNotFound      = errors.New("mfa challenge not found")
+	ErrTOTPStepUsed           = errors.New("totp code already used")
+	ErrRecoveryCodeNotFound   = errors.New("recovery code not found")
+	ErrPasskeyExists          = errors.New("passkey already registered")
+	ErrSessionNotFound        = errors.New("webauthn session not found")
+	ErrAuthCodeNotFound       = errors.New("authorization code not found")
+	ErrAuthCodeUsed           = errors.New("authorization code already used")
+	ErrClientExists           = errors.New("client already exists")
+	ErrClientNotFound         = errors.New("client not found")
+	ErrDeviceCodeExists       = errors.New("device code already exists")
+	ErrDeviceCodeNotFound     = errors.New("device code not found")
+	ErrPolicyNotFound         = errors.New("token exchange policy not found")
+	ErrProviderNotFound       = errors.New("identity provider not found")
+	ErrFederatedLoginNotFound = errors.New("federated login not found")
+	ErrIdentityExists         = errors.New("identity already linked")
+	ErrIdentityNotFound       = errors.New("identity not found")
+	ErrDirectoryNotFound      = errors.New("ldap directory not found")
+	ErrPasswordPolicyNotFound = errors.New("password policy not found")
+	ErrPasswordChangeNotFound = errors.New("password change ticket not found")
+	ErrEmailChangeNotFound    = errors.New("email change token not found")
+	ErrLoginAttemptsNotFound  = errors.New("login attempts not found")
 )
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS webauthn_sessions;
+DROP TABLE IF EXISTS passkey_credentials;
@@ -0,0 +1,6 @@
+ALTER TABLE refresh_tokens
+    DROP COLUMN IF EXISTS scope;
+
+ALTER TABLE authorization_codes
+    DROP COLUMN IF EXISTS auth_time,
+    DROP COLUMN IF EXISTS nonce;
@@ -0,0 +1,6 @@
+ALTER TABLE authorization_codes
+    ADD COLUMN nonce TEXT NOT NULL DEFAULT '',
+    ADD COLUMN auth_time TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+ALTER TABLE refresh_tokens
+    ADD COLUMN scope TEXT NOT NULL DEFAULT '';
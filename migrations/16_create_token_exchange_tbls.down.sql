@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS token_exchange_audit;
+DROP TABLE IF EXISTS token_exchange_policies;
@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS token_exchange_policies(
+    id SERIAL PRIMARY KEY,
+    client_id VARCHAR(64) NOT NULL REFERENCES oauth_clients(client_id) ON DELETE CASCADE,
+    audience_app_id INTEGER NOT NULL REFERENCES apps(id) ON DELETE CASCADE,
+    scopes TEXT NOT NULL DEFAULT '',
+    allow_impersonation BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (client_id, audience_app_id)
+);
+
+CREATE TABLE IF NOT EXISTS token_exchange_audit(
+    id SERIAL PRIMARY KEY,
+    token_id VARCHAR(64) NOT NULL,
+    client_id VARCHAR(64) NOT NULL,
+    subject_user_id INTEGER NOT NULL,
+    actor_user_id INTEGER,
+    audience_app_id INTEGER NOT NULL,
+    scope TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_token_exchange_audit_subject_user_id ON token_exchange_audit(subject_user_id);
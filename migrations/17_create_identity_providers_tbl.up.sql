@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS identity_providers(
+    id SERIAL PRIMARY KEY,
+    app_id INTEGER NOT NULL REFERENCES apps(id) ON DELETE CASCADE,
+    name VARCHAR(64) NOT NULL,
+    issuer VARCHAR(512) NOT NULL,
+    client_id VARCHAR(256) NOT NULL,
+    client_secret VARCHAR(512) NOT NULL,
+    scopes TEXT NOT NULL DEFAULT 'openid email',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (app_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS federated_logins(
+    id SERIAL PRIMARY KEY,
+    state_hash VARCHAR(64) NOT NULL UNIQUE,
+    provider_id INTEGER NOT NULL REFERENCES identity_providers(id) ON DELETE CASCADE,
+    redirect_uri TEXT NOT NULL,
+    nonce VARCHAR(64) NOT NULL,
+    code_verifier VARCHAR(128) NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS user_identities(
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    provider_id INTEGER NOT NULL REFERENCES identity_providers(id) ON DELETE CASCADE,
+    subject VARCHAR(256) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (provider_id, subject),
+    UNIQUE (user_id, provider_id)
+);
+
+-- emails differing only in case belong to the same person
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_lower_email ON users(LOWER(email));
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS user_roles;
+DROP TABLE IF EXISTS ldap_group_roles;
+DROP TABLE IF EXISTS ldap_directories;
@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS ldap_directories(
+    id SERIAL PRIMARY KEY,
+    app_id INTEGER NOT NULL UNIQUE REFERENCES apps(id) ON DELETE CASCADE,
+    url VARCHAR(512) NOT NULL,
+    start_tls BOOLEAN NOT NULL DEFAULT FALSE,
+    bind_dn VARCHAR(512) NOT NULL,
+    bind_password VARCHAR(512) NOT NULL,
+    base_dn VARCHAR(512) NOT NULL,
+    user_filter VARCHAR(512) NOT NULL DEFAULT '(mail=%s)',
+    email_attribute VARCHAR(64) NOT NULL DEFAULT 'mail',
+    group_attribute VARCHAR(64) NOT NULL DEFAULT 'memberOf',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS ldap_group_roles(
+    id SERIAL PRIMARY KEY,
+    directory_id INTEGER NOT NULL REFERENCES ldap_directories(id) ON DELETE CASCADE,
+    group_dn VARCHAR(512) NOT NULL,
+    role VARCHAR(64) NOT NULL,
+    UNIQUE (directory_id, group_dn, role)
+);
+
+CREATE TABLE IF NOT EXISTS user_roles(
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    app_id INTEGER NOT NULL REFERENCES apps(id) ON DELETE CASCADE,
+    role VARCHAR(64) NOT NULL,
+    PRIMARY KEY (user_id, app_id, role)
+);
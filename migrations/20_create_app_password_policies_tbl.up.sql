@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS app_password_policies(
+    app_id INTEGER PRIMARY KEY REFERENCES apps(id) ON DELETE CASCADE,
+    min_length INTEGER NOT NULL DEFAULT 8,
+    max_length INTEGER NOT NULL DEFAULT 128,
+    require_upper BOOLEAN NOT NULL DEFAULT TRUE,
+    require_lower BOOLEAN NOT NULL DEFAULT FALSE,
+    require_digit BOOLEAN NOT NULL DEFAULT TRUE,
+    require_special BOOLEAN NOT NULL DEFAULT TRUE,
+    banned_words TEXT NOT NULL DEFAULT '',
+    email_similarity DOUBLE PRECISION NOT NULL DEFAULT 0.7,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
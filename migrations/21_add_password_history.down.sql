@@ -0,0 +1,9 @@
+ALTER TABLE app_password_policies
+    DROP COLUMN IF EXISTS history_size,
+    DROP COLUMN IF EXISTS max_age_days;
+
+DROP TABLE IF EXISTS password_change_tickets;
+DROP TABLE IF EXISTS password_history;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS password_changed_at;
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS login_attempts(
+    scope VARCHAR(16) NOT NULL,
+    subject VARCHAR(256) NOT NULL,
+    failures INTEGER NOT NULL DEFAULT 0,
+    window_started_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_failure_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    locked_until TIMESTAMPTZ,
+    PRIMARY KEY (scope, subject)
+);
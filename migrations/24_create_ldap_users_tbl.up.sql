@@ -0,0 +1,8 @@
+-- the local users a directory provisioned or its entries were linked to, only they sign in
+-- with the directory credentials
+CREATE TABLE IF NOT EXISTS ldap_users(
+    directory_id INTEGER NOT NULL REFERENCES ldap_directories(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (directory_id, user_id)
+);
@@ -0,0 +1,3 @@
+-- access tokens of the user issued before it are rejected, a password change revokes them all
+ALTER TABLE users
+    ADD COLUMN tokens_valid_after TIMESTAMPTZ;
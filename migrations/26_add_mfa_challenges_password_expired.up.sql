@@ -0,0 +1,3 @@
+-- the password change ticket of an expired password is issued once the second factor passed
+ALTER TABLE mfa_challenges
+    ADD COLUMN password_expired BOOLEAN NOT NULL DEFAULT FALSE;
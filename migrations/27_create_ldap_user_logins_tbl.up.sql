@@ -0,0 +1,11 @@
+-- the directory logins other than the email the users signed in with, failed logins are
+-- counted under the login, an unlock of the user forgets them under each of its logins
+CREATE TABLE IF NOT EXISTS ldap_user_logins(
+    directory_id INTEGER NOT NULL REFERENCES ldap_directories(id) ON DELETE CASCADE,
+    login TEXT NOT NULL,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (directory_id, login)
+);
+
+CREATE INDEX IF NOT EXISTS idx_ldap_user_logins_user_id ON ldap_user_logins(user_id);
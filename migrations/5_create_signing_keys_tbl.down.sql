@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS signing_keys;
+
+ALTER TABLE apps
+    DROP COLUMN IF EXISTS signing_alg;
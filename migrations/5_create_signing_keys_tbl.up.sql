@@ -0,0 +1,14 @@
+ALTER TABLE apps
+    ADD COLUMN signing_alg VARCHAR(16) NOT NULL DEFAULT 'HS256';
+
+CREATE TABLE IF NOT EXISTS signing_keys(
+    id SERIAL PRIMARY KEY,
+    kid VARCHAR(64) NOT NULL UNIQUE,
+    app_id INTEGER NOT NULL REFERENCES apps(id) ON DELETE CASCADE,
+    algorithm VARCHAR(16) NOT NULL,
+    private_key TEXT NOT NULL,
+    public_key TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_signing_keys_app_id ON signing_keys(app_id);
@@ -0,0 +1,3 @@
+ALTER TABLE signing_keys
+    DROP COLUMN IF EXISTS activates_at,
+    DROP COLUMN IF EXISTS retires_at;
@@ -0,0 +1,5 @@
+ALTER TABLE signing_keys
+    ADD COLUMN activates_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    ADD COLUMN retires_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_signing_keys_retires_at ON signing_keys(retires_at);
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS email_verification_tokens;
+
+ALTER TABLE apps
+    DROP COLUMN IF EXISTS require_verified_email;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS email_verified;
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS mfa_challenges;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS totp_secret,
+    DROP COLUMN IF EXISTS totp_enabled,
+    DROP COLUMN IF EXISTS totp_last_step;
@@ -0,0 +1,4 @@
+// Package ssov1 is the generated code of the sso contract in protos/proto/sso.
+package ssov1
+
+//go:generate protoc -I ../../../proto --go_out=../.. --go_opt=paths=source_relative --go-grpc_out=../.. --go-grpc_opt=paths=source_relative sso/sso.proto
@@ -0,0 +1,32 @@
+package tests
+
+import (
+	sso "github.com/Rasikrr/protobuff/protos/gen/go/sso"
+	"github.com/brianvoe/gofakeit"
+	"github.com/stretchr/testify/require"
+	"sso/tests/suite"
+	"testing"
+)
+
+// breachedPassword meets the password policy of the config and is in tests/testdata/pwned_passwords.txt,
+// the corpus the service has to be configured with.
+const breachedPassword = "Summer2024!"
+
+func TestBreachedPassword_Register(t *testing.T) {
+	ctx, st := suite.New(t)
+	if st.Cfg.Password.Breach.Path == "" {
+		t.Skip("breached passwords corpus is not configured")
+	}
+
+	_, err := st.AuthClient.Register(ctx, &sso.RegisterRequest{
+		Email:    gofakeit.Email(),
+		Password: breachedPassword,
+	})
+	require.Equal(t, []string{"breached"}, keys(policyViolations(t, err)))
+
+	_, err = st.AuthClient.Register(ctx, &sso.RegisterRequest{
+		Email:    gofakeit.Email(),
+		Password: generateRandomPassword(),
+	})
+	require.NoError(t, err)
+}
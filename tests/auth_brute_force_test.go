@@ -0,0 +1,199 @@
+package tests
+
+import (
+	"context"
+	sso "github.com/Rasikrr/protobuff/protos/gen/go/sso"
+	"github.com/brianvoe/gofakeit"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"net/http"
+	"net/url"
+	"sso/tests/suite"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogin_Backoff(t *testing.T) {
+	ctx, st := suite.New(t)
+	if st.Cfg.BruteForce.Delay <= 0 {
+		t.Skip("login delays are disabled")
+	}
+
+	email, password := registerUser(ctx, t, st)
+	failLogins(ctx, t, st, email, st.Cfg.BruteForce.UserFreeAttempts)
+
+	// the correct password is rejected as well until the delay passed
+	_, err := st.AuthClient.Login(ctx, &sso.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    appId,
+	})
+	retryAfter := retryDelay(t, err, codes.ResourceExhausted, "TOO_MANY_LOGIN_ATTEMPTS")
+	require.LessOrEqual(t, retryAfter, st.Cfg.BruteForce.Delay+time.Second)
+
+	time.Sleep(retryAfter)
+	login(ctx, t, st, email, password, appId)
+
+	// the successful login forgot the failures
+	failLogins(ctx, t, st, email, 1)
+	login(ctx, t, st, email, password, appId)
+}
+
+func TestUnlockUser(t *testing.T) {
+	ctx, st := suite.New(t)
+	if st.Cfg.BruteForce.Delay <= 0 {
+		t.Skip("login delays are disabled")
+	}
+
+	email := gofakeit.Email()
+	password := generateRandomPassword()
+	resp, err := st.AuthClient.Register(ctx, &sso.RegisterRequest{
+		Email:    email,
+		Password: password,
+	})
+	require.NoError(t, err)
+
+	failLogins(ctx, t, st, email, st.Cfg.BruteForce.UserFreeAttempts)
+	_, err = st.AuthClient.Login(ctx, &sso.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    appId,
+	})
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	other, otherPassword := registerUser(ctx, t, st)
+	_, err = st.AuthClient.UnlockUser(ctx, &sso.UnlockUserRequest{
+		Token:  login(ctx, t, st, other, otherPassword, appId),
+		UserId: resp.GetUserId(),
+	})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	adminToken := login(ctx, t, st, supportEmail, supportPassword, appId)
+
+	// a token acting for the admin is not the admin
+	_, err = st.AuthClient.UnlockUser(ctx, &sso.UnlockUserRequest{
+		Token:  withActor(t, adminToken, "other-client"),
+		UserId: resp.GetUserId(),
+	})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = st.AuthClient.UnlockUser(ctx, &sso.UnlockUserRequest{
+		Token:  adminToken,
+		UserId: resp.GetUserId(),
+	})
+	require.NoError(t, err)
+
+	login(ctx, t, st, email, password, appId)
+
+	_, err = st.AuthClient.UnlockUser(ctx, &sso.UnlockUserRequest{
+		Token:  adminToken,
+		UserId: 1 << 40,
+	})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = st.AuthClient.UnlockUser(ctx, &sso.UnlockUserRequest{
+		Token:  "invalid",
+		UserId: resp.GetUserId(),
+	})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = st.AuthClient.UnlockUser(ctx, &sso.UnlockUserRequest{Token: adminToken})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestUnlockUser_DirectoryLogin(t *testing.T) {
+	ctx, st := suite.New(t)
+	if st.Cfg.BruteForce.Delay <= 0 {
+		t.Skip("login delays are disabled")
+	}
+	dir := st.LDAPDirectory(t)
+
+	email := gofakeit.Email()
+	password := generateRandomPassword()
+	dn := dir.AddUser(email, password)
+	uid := strings.TrimPrefix(strings.SplitN(dn, ",", 2)[0], "uid=")
+
+	// the user signs in on the login page with the uid, its failures are counted under the uid
+	params := authorizationParams()
+	params.Set("client_id", strconv.Itoa(directoryAppID))
+	postAuthorize(t, st, params, uid, password)
+	for i := 0; i < st.Cfg.BruteForce.UserFreeAttempts; i++ {
+		require.Contains(t, loginPage(t, st, params, uid, generateRandomPassword()), "Invalid email or password")
+	}
+	require.Contains(t, loginPage(t, st, params, uid, password), "Too many failed attempts")
+
+	var userID int64
+	err := st.DB(t).GetContext(ctx, &userID, `SELECT id FROM users WHERE email=$1`, email)
+	require.NoError(t, err)
+	_, err = st.AuthClient.UnlockUser(ctx, &sso.UnlockUserRequest{
+		Token:  login(ctx, t, st, supportEmail, supportPassword, appId),
+		UserId: userID,
+	})
+	require.NoError(t, err)
+
+	postAuthorize(t, st, params, uid, password)
+}
+
+// loginPage submits the login form and returns the page it is rendered again with.
+func loginPage(t *testing.T, st *suite.Suite, params url.Values, login, password string) string {
+	t.Helper()
+
+	form := url.Values{}
+	for k, v := range params {
+		form[k] = v
+	}
+	form.Set("email", login)
+	form.Set("password", password)
+
+	resp, err := noRedirectClient.PostForm(st.HTTPURL("/oauth/authorize"), form)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+// failLogins signs in with a wrong password n times.
+func failLogins(ctx context.Context, t *testing.T, st *suite.Suite, email string, n int) {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		_, err := st.AuthClient.Login(ctx, &sso.LoginRequest{
+			Email:    email,
+			Password: generateRandomPassword(),
+			AppId:    appId,
+		})
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+	}
+}
+
+// retryDelay returns the delay of the RetryInfo of the error after checking the code
+// and the reason of its ErrorInfo.
+func retryDelay(t *testing.T, err error, code codes.Code, reason string) time.Duration {
+	t.Helper()
+
+	require.Error(t, err)
+	st, ok := status.FromError(err)
+	require.True(t, ok)
+	require.Equal(t, code, st.Code())
+
+	var delay time.Duration
+	for _, detail := range st.Details() {
+		switch d := detail.(type) {
+		case *errdetails.ErrorInfo:
+			require.Equal(t, reason, d.GetReason())
+			require.NotEmpty(t, d.GetMetadata()["retry_after"])
+		case *errdetails.RetryInfo:
+			delay = d.GetRetryDelay().AsDuration()
+		}
+	}
+	require.Positive(t, delay)
+	return delay
+}
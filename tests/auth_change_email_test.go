@@ -0,0 +1,153 @@
+package tests
+
+import (
+	sso "github.com/Rasikrr/protobuff/protos/gen/go/sso"
+	"github.com/brianvoe/gofakeit"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"regexp"
+	"sso/tests/suite"
+	"testing"
+)
+
+var emailChangeTokenRe = regexp.MustCompile(`confirm your new email: (\S+)`)
+
+func TestChangeEmail_HappyPath(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email, password := registerUser(ctx, t, st)
+	token := login(ctx, t, st, email, password, appId)
+	newEmail := gofakeit.Email()
+
+	_, err := st.AuthClient.ChangeEmail(ctx, &sso.ChangeEmailRequest{
+		Token:    token,
+		Password: password,
+		NewEmail: newEmail,
+	})
+	require.NoError(t, err)
+	require.Contains(t, st.LastMail(t, email), newEmail)
+
+	match := emailChangeTokenRe.FindStringSubmatch(st.LastMail(t, newEmail))
+	require.Len(t, match, 2)
+
+	// the email changes only once the token is confirmed
+	login(ctx, t, st, email, password, appId)
+
+	_, err = st.AuthClient.ConfirmEmailChange(ctx, &sso.ConfirmEmailChangeRequest{Token: match[1]})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.ConfirmEmailChange(ctx, &sso.ConfirmEmailChangeRequest{Token: match[1]})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = st.AuthClient.Login(ctx, &sso.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    appId,
+	})
+	require.Error(t, err)
+
+	claims := parseClaims(t, login(ctx, t, st, newEmail, password, appId), appSecret)
+	require.Equal(t, newEmail, claims["email"])
+}
+
+func TestChangeEmail_EmailInUse(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email, password := registerUser(ctx, t, st)
+	token := login(ctx, t, st, email, password, appId)
+	taken, _ := registerUser(ctx, t, st)
+
+	_, err := st.AuthClient.ChangeEmail(ctx, &sso.ChangeEmailRequest{
+		Token:    token,
+		Password: password,
+		NewEmail: taken,
+	})
+	require.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	// the email is taken between the request and the confirmation
+	newEmail := gofakeit.Email()
+	_, err = st.AuthClient.ChangeEmail(ctx, &sso.ChangeEmailRequest{
+		Token:    token,
+		Password: password,
+		NewEmail: newEmail,
+	})
+	require.NoError(t, err)
+	match := emailChangeTokenRe.FindStringSubmatch(st.LastMail(t, newEmail))
+	require.Len(t, match, 2)
+
+	_, err = st.AuthClient.Register(ctx, &sso.RegisterRequest{
+		Email:    newEmail,
+		Password: generateRandomPassword(),
+	})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.ConfirmEmailChange(ctx, &sso.ConfirmEmailChangeRequest{Token: match[1]})
+	require.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	login(ctx, t, st, email, password, appId)
+}
+
+func TestChangeEmail_FailCases(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email, password := registerUser(ctx, t, st)
+	token := login(ctx, t, st, email, password, appId)
+
+	tests := []struct {
+		name        string
+		token       string
+		password    string
+		newEmail    string
+		expectedErr codes.Code
+	}{
+		{
+			name:        "Missing token",
+			password:    password,
+			newEmail:    gofakeit.Email(),
+			expectedErr: codes.InvalidArgument,
+		},
+		{
+			name:        "Invalid token",
+			token:       "invalid",
+			password:    password,
+			newEmail:    gofakeit.Email(),
+			expectedErr: codes.Unauthenticated,
+		},
+		{
+			name:        "Wrong password",
+			token:       token,
+			password:    generateRandomPassword(),
+			newEmail:    gofakeit.Email(),
+			expectedErr: codes.Unauthenticated,
+		},
+		{
+			name:        "Invalid email",
+			token:       token,
+			password:    password,
+			newEmail:    "not-an-email",
+			expectedErr: codes.InvalidArgument,
+		},
+		{
+			name:        "Current email",
+			token:       token,
+			password:    password,
+			newEmail:    email,
+			expectedErr: codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := st.AuthClient.ChangeEmail(ctx, &sso.ChangeEmailRequest{
+				Token:    tt.token,
+				Password: tt.password,
+				NewEmail: tt.newEmail,
+			})
+			require.Equal(t, tt.expectedErr, status.Code(err))
+		})
+	}
+
+	_, err := st.AuthClient.ConfirmEmailChange(ctx, &sso.ConfirmEmailChangeRequest{Token: "invalid"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
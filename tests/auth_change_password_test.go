@@ -0,0 +1,328 @@
+package tests
+
+import (
+	"context"
+	sso "github.com/Rasikrr/protobuff/protos/gen/go/sso"
+	"github.com/brianvoe/gofakeit"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sso/internal/lib/totp"
+	"sso/tests/suite"
+	"testing"
+	"time"
+)
+
+const (
+	regulatedAppID = 103
+	expiredEmail   = "expired@sso.test"
+	// expiredPassword is the password of the expired user of the fixture.
+	expiredPassword = "legacy-password"
+)
+
+func TestChangePassword_HappyPath(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email, password := registerUser(ctx, t, st)
+	token := login(ctx, t, st, email, password, appId)
+	newPassword := generateRandomPassword()
+
+	_, err := st.AuthClient.ChangePassword(ctx, &sso.ChangePasswordRequest{
+		Token:           token,
+		CurrentPassword: generateRandomPassword(),
+		NewPassword:     newPassword,
+	})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = st.AuthClient.ChangePassword(ctx, &sso.ChangePasswordRequest{
+		Token:           token,
+		CurrentPassword: password,
+		NewPassword:     "weak",
+	})
+	require.Contains(t, policyViolations(t, err), "min_length")
+
+	other, err := st.AuthClient.Login(ctx, &sso.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    appId,
+	})
+	require.NoError(t, err)
+	// access tokens are revoked by their issue time in whole seconds
+	time.Sleep(time.Second)
+
+	resp, err := st.AuthClient.ChangePassword(ctx, &sso.ChangePasswordRequest{
+		Token:           token,
+		CurrentPassword: password,
+		NewPassword:     newPassword,
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, resp.GetToken())
+	require.NotEmpty(t, resp.GetRefreshToken())
+
+	// the other sessions are revoked, the caller continues with the new session
+	_, err = st.AuthClient.Refresh(ctx, &sso.RefreshRequest{RefreshToken: other.GetRefreshToken()})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = st.AuthClient.Refresh(ctx, &sso.RefreshRequest{RefreshToken: resp.GetRefreshToken()})
+	require.NoError(t, err)
+	for _, accessToken := range []string{token, other.GetToken()} {
+		validated, err := st.AuthClient.ValidateToken(ctx, &sso.ValidateTokenRequest{
+			Token: accessToken,
+			AppId: appId,
+		})
+		require.NoError(t, err)
+		require.False(t, validated.GetActive())
+	}
+	validated, err := st.AuthClient.ValidateToken(ctx, &sso.ValidateTokenRequest{
+		Token: resp.GetToken(),
+		AppId: appId,
+	})
+	require.NoError(t, err)
+	require.True(t, validated.GetActive())
+
+	_, err = st.AuthClient.Login(ctx, &sso.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    appId,
+	})
+	require.Error(t, err)
+	login(ctx, t, st, email, newPassword, appId)
+}
+
+func TestChangePassword_History(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	passwords := []string{generateRandomPassword()}
+	_, err := st.AuthClient.Register(ctx, &sso.RegisterRequest{
+		Email:    email,
+		Password: passwords[0],
+		AppId:    regulatedAppID,
+	})
+	require.NoError(t, err)
+
+	change := func(newPassword string) error {
+		current := passwords[len(passwords)-1]
+		token := login(ctx, t, st, email, current, regulatedAppID)
+		_, err := st.AuthClient.ChangePassword(ctx, &sso.ChangePasswordRequest{
+			Token:           token,
+			CurrentPassword: current,
+			NewPassword:     newPassword,
+		})
+		if err == nil {
+			passwords = append(passwords, newPassword)
+		}
+		return err
+	}
+
+	require.Equal(t, []string{"history"}, keys(policyViolations(t, change(passwords[0]))))
+	require.NoError(t, change(generateRandomPassword()))
+	require.Equal(t, []string{"history"}, keys(policyViolations(t, change(passwords[0]))))
+	require.NoError(t, change(generateRandomPassword()))
+	require.NoError(t, change(generateRandomPassword()))
+
+	// the app remembers three passwords, the first one is the fourth latest now
+	require.NoError(t, change(passwords[0]))
+}
+
+func TestChangePassword_ExpiredPassword(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	resp, err := st.AuthClient.Login(ctx, &sso.LoginRequest{
+		Email:    expiredEmail,
+		Password: expiredPassword,
+		AppId:    regulatedAppID,
+	})
+	require.NoError(t, err)
+	require.True(t, resp.GetPasswordExpired())
+	require.NotEmpty(t, resp.GetPasswordChangeId())
+	require.Empty(t, resp.GetToken())
+	require.Empty(t, resp.GetRefreshToken())
+
+	// the password expires under the policy of the regulated app only
+	login(ctx, t, st, expiredEmail, expiredPassword, appId)
+
+	_, err = st.AuthClient.ChangePassword(ctx, &sso.ChangePasswordRequest{
+		PasswordChangeId: "unknown",
+		CurrentPassword:  expiredPassword,
+		NewPassword:      generateRandomPassword(),
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	require.ErrorContains(t, err, "invalid or expired password change")
+
+	_, err = st.AuthClient.ChangePassword(ctx, &sso.ChangePasswordRequest{
+		PasswordChangeId: resp.GetPasswordChangeId(),
+		CurrentPassword:  "wrong-password",
+		NewPassword:      generateRandomPassword(),
+	})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = st.AuthClient.ChangePassword(ctx, &sso.ChangePasswordRequest{
+		PasswordChangeId: resp.GetPasswordChangeId(),
+		CurrentPassword:  expiredPassword,
+		NewPassword:      "Short-1",
+	})
+	require.Contains(t, policyViolations(t, err), "min_length")
+
+	// the fixture user has to stay expired for the next run, so the change is not completed,
+	// the rejected attempts above did not use up the ticket either
+	_, err = st.AuthClient.ChangePassword(ctx, &sso.ChangePasswordRequest{
+		PasswordChangeId: resp.GetPasswordChangeId(),
+		CurrentPassword:  expiredPassword,
+		NewPassword:      expiredPassword,
+	})
+	rules := policyViolations(t, err)
+	require.Contains(t, rules, "history")
+}
+
+func TestChangePassword_ExpiredPasswordMFA(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	password := generateRandomPassword()
+	_, err := st.AuthClient.Register(ctx, &sso.RegisterRequest{
+		Email:    email,
+		Password: password,
+		AppId:    regulatedAppID,
+	})
+	require.NoError(t, err)
+	secret := enrollTOTP(ctx, t, st, login(ctx, t, st, email, password, appId), password)
+
+	_, err = st.DB(t).ExecContext(ctx,
+		`UPDATE users SET password_changed_at = NOW() - INTERVAL '100 days' WHERE email=$1`,
+		email,
+	)
+	require.NoError(t, err)
+
+	// the password alone yields the challenge, not the password change
+	respLogin, err := st.AuthClient.Login(ctx, &sso.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    regulatedAppID,
+	})
+	require.NoError(t, err)
+	require.True(t, respLogin.GetMfaRequired())
+	require.NotEmpty(t, respLogin.GetMfaChallengeId())
+	require.False(t, respLogin.GetPasswordExpired())
+	require.Empty(t, respLogin.GetPasswordChangeId())
+
+	// the code of the enrollment is used, the next step is accepted as well
+	code, err := totp.Code(secret, totp.Step(time.Now())+1)
+	require.NoError(t, err)
+	respVerify, err := st.AuthClient.VerifyMFA(ctx, &sso.VerifyMFARequest{
+		ChallengeId: respLogin.GetMfaChallengeId(),
+		Code:        code,
+	})
+	require.NoError(t, err)
+	require.True(t, respVerify.GetPasswordExpired())
+	require.NotEmpty(t, respVerify.GetPasswordChangeId())
+	require.Empty(t, respVerify.GetToken())
+	require.Empty(t, respVerify.GetRefreshToken())
+
+	_, err = st.AuthClient.ChangePassword(ctx, &sso.ChangePasswordRequest{
+		PasswordChangeId: respVerify.GetPasswordChangeId(),
+		CurrentPassword:  password,
+		NewPassword:      generateRandomPassword(),
+	})
+	require.NoError(t, err)
+}
+
+func TestRefresh_ExpiredPassword(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	password := generateRandomPassword()
+	_, err := st.AuthClient.Register(ctx, &sso.RegisterRequest{
+		Email:    email,
+		Password: password,
+		AppId:    regulatedAppID,
+	})
+	require.NoError(t, err)
+	resp, err := st.AuthClient.Login(ctx, &sso.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    regulatedAppID,
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, resp.GetRefreshToken())
+
+	// the password expires while the session lasts
+	_, err = st.DB(t).ExecContext(ctx,
+		`UPDATE users SET password_changed_at = NOW() - INTERVAL '100 days' WHERE email=$1`,
+		email,
+	)
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.Refresh(ctx, &sso.RefreshRequest{
+		RefreshToken: resp.GetRefreshToken(),
+	})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+	require.ErrorContains(t, err, "password expired")
+}
+
+func TestChangePassword_Throttled(t *testing.T) {
+	ctx, st := suite.New(t)
+	if st.Cfg.BruteForce.Delay <= 0 {
+		t.Skip("login delays are disabled")
+	}
+
+	email, password := registerUser(ctx, t, st)
+	token := login(ctx, t, st, email, password, appId)
+
+	// a stolen token does not let the password be guessed faster than at the login
+	for i := 0; i < st.Cfg.BruteForce.UserFreeAttempts; i++ {
+		_, err := st.AuthClient.ChangePassword(ctx, &sso.ChangePasswordRequest{
+			Token:           token,
+			CurrentPassword: generateRandomPassword(),
+			NewPassword:     generateRandomPassword(),
+		})
+		require.Equal(t, codes.Unauthenticated, status.Code(err))
+	}
+
+	_, err := st.AuthClient.ChangePassword(ctx, &sso.ChangePasswordRequest{
+		Token:           token,
+		CurrentPassword: password,
+		NewPassword:     generateRandomPassword(),
+	})
+	retryDelay(t, err, codes.ResourceExhausted, "TOO_MANY_LOGIN_ATTEMPTS")
+
+	// the failures count for the login as well
+	_, err = st.AuthClient.Login(ctx, &sso.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    appId,
+	})
+	retryDelay(t, err, codes.ResourceExhausted, "TOO_MANY_LOGIN_ATTEMPTS")
+}
+
+func TestChangePassword_InvalidRequest(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	_, err := st.AuthClient.ChangePassword(ctx, &sso.ChangePasswordRequest{
+		CurrentPassword: generateRandomPassword(),
+		NewPassword:     generateRandomPassword(),
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	require.ErrorContains(t, err, "either token or password_change_id is required")
+
+	_, err = st.AuthClient.ChangePassword(ctx, &sso.ChangePasswordRequest{
+		Token:            "token",
+		PasswordChangeId: "change",
+		CurrentPassword:  generateRandomPassword(),
+		NewPassword:      generateRandomPassword(),
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+// login signs the user in to the app and returns the access token.
+func login(ctx context.Context, t *testing.T, st *suite.Suite, email, password string, appID int32) string {
+	t.Helper()
+
+	resp, err := st.AuthClient.Login(ctx, &sso.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    appID,
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, resp.GetToken())
+	return resp.GetToken()
+}
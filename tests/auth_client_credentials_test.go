@@ -0,0 +1,130 @@
+package tests
+
+import (
+	"encoding/json"
+	sso "github.com/Rasikrr/protobuff/protos/gen/go/sso"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/url"
+	"sso/tests/suite"
+	"strings"
+	"testing"
+)
+
+const (
+	clientID     = "test-client"
+	clientSecret = "test-client-secret"
+)
+
+func TestClientCredentials_GRPC_HappyPath(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	resp, err := st.AuthClient.ClientCredentials(ctx, &sso.ClientCredentialsRequest{
+		ClientId:     clientID,
+		ClientSecret: clientSecret,
+		Scope:        "orders:read",
+	})
+	require.NoError(t, err)
+	require.Equal(t, "orders:read", resp.GetScope())
+	require.Greater(t, resp.GetExpiresIn(), int64(0))
+
+	token, err := jwt.Parse(resp.GetToken(), func(t *jwt.Token) (interface{}, error) {
+		return []byte(appSecret), nil
+	})
+	require.NoError(t, err)
+	claims := token.Claims.(jwt.MapClaims)
+	require.Equal(t, clientID, claims["sub"])
+	require.Equal(t, clientID, claims["client_id"])
+	require.Equal(t, "orders:read", claims["scope"])
+	require.NotContains(t, claims, "uid")
+
+	// all allowed scopes are granted when none are requested
+	resp, err = st.AuthClient.ClientCredentials(ctx, &sso.ClientCredentialsRequest{
+		ClientId:     clientID,
+		ClientSecret: clientSecret,
+	})
+	require.NoError(t, err)
+	require.Equal(t, "orders:read orders:write", resp.GetScope())
+}
+
+func TestClientCredentials_GRPC_FailCases(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	tests := []struct {
+		name        string
+		clientID    string
+		secret      string
+		scope       string
+		expectedErr string
+	}{
+		{
+			name:        "Wrong secret",
+			clientID:    clientID,
+			secret:      "wrong-secret",
+			expectedErr: "invalid client credentials",
+		},
+		{
+			name:        "Unknown client",
+			clientID:    "unknown-client",
+			secret:      clientSecret,
+			expectedErr: "invalid client credentials",
+		},
+		{
+			name:        "Scope not allowed",
+			clientID:    clientID,
+			secret:      clientSecret,
+			scope:       "orders:read users:admin",
+			expectedErr: "scope not allowed",
+		},
+		{
+			name:        "Empty secret",
+			clientID:    clientID,
+			expectedErr: "client_secret is required",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := st.AuthClient.ClientCredentials(ctx, &sso.ClientCredentialsRequest{
+				ClientId:     tt.clientID,
+				ClientSecret: tt.secret,
+				Scope:        tt.scope,
+			})
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tt.expectedErr)
+		})
+	}
+}
+
+func TestClientCredentials_HTTP(t *testing.T) {
+	_, st := suite.New(t)
+
+	form := url.Values{
+		"grant_type": {"client_credentials"},
+		"scope":      {"orders:write"},
+	}
+	req, err := http.NewRequest(http.MethodPost, st.HTTPURL("/oauth/token"), strings.NewReader(form.Encode()))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(clientID, clientSecret)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var body map[string]interface{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	require.NotEmpty(t, body["access_token"])
+	require.Equal(t, "orders:write", body["scope"])
+	require.NotContains(t, body, "refresh_token")
+
+	status, errBody := postToken(t, st, url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {clientID},
+		"client_secret": {"wrong-secret"},
+	})
+	require.Equal(t, http.StatusUnauthorized, status)
+	require.Equal(t, "invalid_client", errBody["error"])
+}
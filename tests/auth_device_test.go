@@ -0,0 +1,178 @@
+package tests
+
+import (
+	"encoding/json"
+	sso "github.com/Rasikrr/protobuff/protos/gen/go/sso"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/url"
+	"sso/tests/suite"
+	"strconv"
+	"testing"
+)
+
+func TestDevice_ApproveGRPC_HappyPath(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	device := startDeviceAuthorization(t, st, "openid")
+	require.Equal(t, st.Cfg.OAuth.Issuer+"/oauth/device", device["verification_uri"])
+	require.Contains(t, device["verification_uri_complete"], url.QueryEscape(device["user_code"].(string)))
+	require.Equal(t, st.Cfg.OAuth.DevicePollInterval.Seconds(), device["interval"])
+
+	login := registerAndLogin(ctx, t, st)
+	_, err := st.AuthClient.ApproveDevice(ctx, &sso.ApproveDeviceRequest{
+		Token:    login.GetToken(),
+		UserCode: device["user_code"].(string),
+		Approve:  true,
+	})
+	require.NoError(t, err)
+
+	status, body := pollDevice(t, st, device["device_code"].(string))
+	require.Equal(t, http.StatusOK, status)
+	require.NotEmpty(t, body["access_token"])
+	require.NotEmpty(t, body["refresh_token"])
+
+	// the device code is single use
+	status, body = pollDevice(t, st, device["device_code"].(string))
+	require.Equal(t, http.StatusBadRequest, status)
+	require.Equal(t, "invalid_grant", body["error"])
+
+	// a decided user code can't be approved again
+	_, err = st.AuthClient.ApproveDevice(ctx, &sso.ApproveDeviceRequest{
+		Token:    login.GetToken(),
+		UserCode: device["user_code"].(string),
+		Approve:  true,
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "invalid or expired user code")
+}
+
+func TestDevice_PendingAndSlowDown(t *testing.T) {
+	_, st := suite.New(t)
+
+	device := startDeviceAuthorization(t, st, "")
+
+	status, body := pollDevice(t, st, device["device_code"].(string))
+	require.Equal(t, http.StatusBadRequest, status)
+	require.Equal(t, "authorization_pending", body["error"])
+
+	status, body = pollDevice(t, st, device["device_code"].(string))
+	require.Equal(t, http.StatusBadRequest, status)
+	require.Equal(t, "slow_down", body["error"])
+}
+
+func TestDevice_DenyOnVerificationPage(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	device := startDeviceAuthorization(t, st, "")
+	email, password := registerUser(ctx, t, st)
+
+	resp, err := http.Get(st.HTTPURL("/oauth/device?user_code=" + url.QueryEscape(device["user_code"].(string))))
+	require.NoError(t, err)
+	page, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Contains(t, string(page), device["user_code"].(string))
+
+	resp, err = http.PostForm(st.HTTPURL("/oauth/device"), url.Values{
+		"user_code": {device["user_code"].(string)},
+		"email":     {email},
+		"password":  {password},
+		"action":    {"deny"},
+	})
+	require.NoError(t, err)
+	page, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	require.Contains(t, string(page), "denied")
+
+	status, body := pollDevice(t, st, device["device_code"].(string))
+	require.Equal(t, http.StatusBadRequest, status)
+	require.Equal(t, "access_denied", body["error"])
+}
+
+func TestDevice_MFAFailuresThrottleLogin(t *testing.T) {
+	ctx, st := suite.New(t)
+	if st.Cfg.BruteForce.Delay <= 0 {
+		t.Skip("login delays are disabled")
+	}
+
+	device := startDeviceAuthorization(t, st, "")
+	email, password := registerUser(ctx, t, st)
+	enrollTOTP(ctx, t, st, login(ctx, t, st, email, password, appId), password)
+
+	approve := func(mfaCode string) string {
+		resp, err := http.PostForm(st.HTTPURL("/oauth/device"), url.Values{
+			"user_code": {device["user_code"].(string)},
+			"email":     {email},
+			"password":  {password},
+			"mfa_code":  {mfaCode},
+			"action":    {"approve"},
+		})
+		require.NoError(t, err)
+		page, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err)
+		return string(page)
+	}
+
+	for i := 0; i < st.Cfg.BruteForce.UserFreeAttempts; i++ {
+		require.Contains(t, approve("invalid-code"), "Invalid authentication code")
+	}
+	// the correct password does not bring new guesses
+	require.Contains(t, approve("invalid-code"), "Too many failed attempts")
+}
+
+func TestDevice_FailCases(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	login := registerAndLogin(ctx, t, st)
+	_, err := st.AuthClient.ApproveDevice(ctx, &sso.ApproveDeviceRequest{
+		Token:    login.GetToken(),
+		UserCode: "BCDF-GHJK",
+		Approve:  true,
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "invalid or expired user code")
+
+	status, body := pollDevice(t, st, "unknown-device-code")
+	require.Equal(t, http.StatusBadRequest, status)
+	require.Equal(t, "invalid_grant", body["error"])
+
+	resp, err := http.PostForm(st.HTTPURL("/oauth/device_authorization"), url.Values{
+		"client_id": {"999"},
+	})
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func startDeviceAuthorization(t *testing.T, st *suite.Suite, scope string) map[string]interface{} {
+	t.Helper()
+
+	resp, err := http.PostForm(st.HTTPURL("/oauth/device_authorization"), url.Values{
+		"client_id": {strconv.Itoa(appId)},
+		"scope":     {scope},
+	})
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var body map[string]interface{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	require.NotEmpty(t, body["device_code"])
+	require.Regexp(t, `^[B-Z]{4}-[B-Z]{4}$`, body["user_code"])
+	return body
+}
+
+func pollDevice(t *testing.T, st *suite.Suite, deviceCode string) (int, map[string]interface{}) {
+	t.Helper()
+
+	return postToken(t, st, url.Values{
+		"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+		"device_code": {deviceCode},
+		"client_id":   {strconv.Itoa(appId)},
+	})
+}
@@ -0,0 +1,64 @@
+package tests
+
+import (
+	sso "github.com/Rasikrr/protobuff/protos/gen/go/sso"
+	"github.com/brianvoe/gofakeit"
+	"github.com/stretchr/testify/require"
+	"regexp"
+	"sso/tests/suite"
+	"testing"
+)
+
+var verificationTokenRe = regexp.MustCompile(`confirm your email: (\S+)`)
+
+func TestEmailVerification_HappyPath(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+
+	_, err := st.AuthClient.Register(ctx, &sso.RegisterRequest{
+		Email:    email,
+		Password: generateRandomPassword(),
+	})
+	require.NoError(t, err)
+
+	match := verificationTokenRe.FindStringSubmatch(st.LastMail(t, email))
+	require.Len(t, match, 2)
+
+	_, err = st.AuthClient.VerifyEmail(ctx, &sso.VerifyEmailRequest{
+		Token: match[1],
+	})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.VerifyEmail(ctx, &sso.VerifyEmailRequest{
+		Token: match[1],
+	})
+	require.Error(t, err)
+	require.ErrorContains(t, err, "invalid or expired token")
+}
+
+func TestEmailVerification_ResendThrottled(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+
+	_, err := st.AuthClient.Register(ctx, &sso.RegisterRequest{
+		Email:    email,
+		Password: generateRandomPassword(),
+	})
+	require.NoError(t, err)
+
+	mail := st.LastMail(t, email)
+
+	// a throttled resend looks like a resend to an unregistered email, no mail is sent
+	_, err = st.AuthClient.ResendVerificationEmail(ctx, &sso.ResendVerificationEmailRequest{
+		Email: email,
+	})
+	require.NoError(t, err)
+	require.Equal(t, mail, st.LastMail(t, email))
+
+	_, err = st.AuthClient.ResendVerificationEmail(ctx, &sso.ResendVerificationEmailRequest{
+		Email: gofakeit.Email(),
+	})
+	require.NoError(t, err)
+}
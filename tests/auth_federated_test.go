@@ -0,0 +1,205 @@
+package tests
+
+import (
+	"context"
+	sso "github.com/Rasikrr/protobuff/protos/gen/go/sso"
+	"github.com/brianvoe/gofakeit"
+	"github.com/stretchr/testify/require"
+	"sso/tests/suite"
+	"strings"
+	"testing"
+)
+
+const providerName = "fake"
+
+func TestFederatedLogin_HappyPath(t *testing.T) {
+	ctx, st := suite.New(t)
+	provider := st.OIDCProvider(t)
+
+	email := gofakeit.Email()
+	subject := gofakeit.UUID()
+
+	// the first login provisions the user
+	tokens := federatedLogin(ctx, t, st, provider, subject, email, true)
+	claims := parseClaims(t, tokens.GetToken(), appSecret)
+	require.Equal(t, email, claims["email"])
+	require.NotEmpty(t, tokens.GetRefreshToken())
+
+	// a repeated login signs in the same user
+	again := federatedLogin(ctx, t, st, provider, subject, email, true)
+	require.Equal(t, claims["uid"], parseClaims(t, again.GetToken(), appSecret)["uid"])
+
+	info, err := st.AuthClient.ValidateToken(ctx, &sso.ValidateTokenRequest{
+		Token: again.GetToken(),
+		AppId: appId,
+	})
+	require.NoError(t, err)
+	require.True(t, info.GetActive())
+}
+
+func TestFederatedLogin_ExistingUser(t *testing.T) {
+	ctx, st := suite.New(t)
+	provider := st.OIDCProvider(t)
+
+	email, password := registerUser(ctx, t, st)
+	subject := gofakeit.UUID()
+
+	match := verificationTokenRe.FindStringSubmatch(st.LastMail(t, email))
+	require.Len(t, match, 2)
+	_, err := st.AuthClient.VerifyEmail(ctx, &sso.VerifyEmailRequest{
+		Token: match[1],
+	})
+	require.NoError(t, err)
+
+	// an existing account is never taken over by email, even verified on both sides,
+	// its owner has to link the identity
+	resp := beginFederatedLogin(ctx, t, st)
+	code, state := provider.Authorize(t, resp.GetAuthorizationUrl(), subject, strings.ToUpper(email), true)
+	_, err = st.AuthClient.FinishFederatedLogin(ctx, &sso.FinishFederatedLoginRequest{
+		State: state,
+		Code:  code,
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "account already exists")
+
+	token := login(ctx, t, st, email, password, appId)
+	resp = beginFederatedLogin(ctx, t, st)
+	code, state = provider.Authorize(t, resp.GetAuthorizationUrl(), subject, email, true)
+	_, err = st.AuthClient.LinkIdentity(ctx, &sso.LinkIdentityRequest{
+		Token:    token,
+		Password: password,
+		State:    state,
+		Code:     code,
+	})
+	require.NoError(t, err)
+
+	tokens := federatedLogin(ctx, t, st, provider, subject, email, true)
+	require.Equal(t,
+		parseClaims(t, token, appSecret)["uid"],
+		parseClaims(t, tokens.GetToken(), appSecret)["uid"],
+	)
+}
+
+func TestFederatedLogin_FailCases(t *testing.T) {
+	ctx, st := suite.New(t)
+	provider := st.OIDCProvider(t)
+
+	t.Run("Unverified email", func(t *testing.T) {
+		resp := beginFederatedLogin(ctx, t, st)
+		code, state := provider.Authorize(t, resp.GetAuthorizationUrl(), gofakeit.UUID(), gofakeit.Email(), false)
+
+		_, err := st.AuthClient.FinishFederatedLogin(ctx, &sso.FinishFederatedLoginRequest{
+			State: state,
+			Code:  code,
+		})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "email not verified")
+	})
+
+	t.Run("Reused state", func(t *testing.T) {
+		resp := beginFederatedLogin(ctx, t, st)
+		code, state := provider.Authorize(t, resp.GetAuthorizationUrl(), gofakeit.UUID(), gofakeit.Email(), true)
+
+		_, err := st.AuthClient.FinishFederatedLogin(ctx, &sso.FinishFederatedLoginRequest{
+			State: state,
+			Code:  code,
+		})
+		require.NoError(t, err)
+
+		_, err = st.AuthClient.FinishFederatedLogin(ctx, &sso.FinishFederatedLoginRequest{
+			State: state,
+			Code:  code,
+		})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid or expired state")
+	})
+
+	t.Run("Invalid code", func(t *testing.T) {
+		resp := beginFederatedLogin(ctx, t, st)
+
+		_, err := st.AuthClient.FinishFederatedLogin(ctx, &sso.FinishFederatedLoginRequest{
+			State: resp.GetState(),
+			Code:  "not-a-code",
+		})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "upstream authentication failed")
+	})
+
+	tests := []struct {
+		name        string
+		req         *sso.BeginFederatedLoginRequest
+		expectedErr string
+	}{
+		{
+			name: "Unknown provider",
+			req: &sso.BeginFederatedLoginRequest{
+				AppId:       appId,
+				Provider:    "unknown",
+				RedirectUri: redirectURI,
+			},
+			expectedErr: "unknown provider",
+		},
+		{
+			name: "Unregistered redirect uri",
+			req: &sso.BeginFederatedLoginRequest{
+				AppId:       appId,
+				Provider:    providerName,
+				RedirectUri: "http://evil.test/callback",
+			},
+			expectedErr: "invalid redirect_uri",
+		},
+		{
+			name: "Begin without app id",
+			req: &sso.BeginFederatedLoginRequest{
+				Provider:    providerName,
+				RedirectUri: redirectURI,
+			},
+			expectedErr: "app_id is required",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := st.AuthClient.BeginFederatedLogin(ctx, tt.req)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tt.expectedErr)
+		})
+	}
+}
+
+// federatedLogin signs the account in at the fake provider and finishes the login.
+func federatedLogin(
+	ctx context.Context,
+	t *testing.T,
+	st *suite.Suite,
+	provider *suite.OIDCProvider,
+	subject, email string,
+	emailVerified bool,
+) *sso.FinishFederatedLoginResponse {
+	t.Helper()
+
+	resp := beginFederatedLogin(ctx, t, st)
+	code, state := provider.Authorize(t, resp.GetAuthorizationUrl(), subject, email, emailVerified)
+	require.Equal(t, resp.GetState(), state)
+
+	tokens, err := st.AuthClient.FinishFederatedLogin(ctx, &sso.FinishFederatedLoginRequest{
+		State: state,
+		Code:  code,
+	})
+	require.NoError(t, err)
+	return tokens
+}
+
+func beginFederatedLogin(ctx context.Context, t *testing.T, st *suite.Suite) *sso.BeginFederatedLoginResponse {
+	t.Helper()
+
+	resp, err := st.AuthClient.BeginFederatedLogin(ctx, &sso.BeginFederatedLoginRequest{
+		AppId:       appId,
+		Provider:    providerName,
+		RedirectUri: redirectURI,
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, resp.GetAuthorizationUrl())
+	require.NotEmpty(t, resp.GetState())
+	return resp
+}
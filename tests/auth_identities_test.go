@@ -0,0 +1,109 @@
+package tests
+
+import (
+	sso "github.com/Rasikrr/protobuff/protos/gen/go/sso"
+	"github.com/brianvoe/gofakeit"
+	"github.com/stretchr/testify/require"
+	"sso/tests/suite"
+	"testing"
+)
+
+func TestLinkIdentity_HappyPath(t *testing.T) {
+	ctx, st := suite.New(t)
+	provider := st.OIDCProvider(t)
+
+	email, password := registerUser(ctx, t, st)
+	respLogin, err := st.AuthClient.Login(ctx, &sso.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    appId,
+	})
+	require.NoError(t, err)
+	uid := parseClaims(t, respLogin.GetToken(), appSecret)["uid"]
+
+	// the upstream account may use another email
+	subject := gofakeit.UUID()
+	resp := beginFederatedLogin(ctx, t, st)
+	code, state := provider.Authorize(t, resp.GetAuthorizationUrl(), subject, gofakeit.Email(), false)
+
+	_, err = st.AuthClient.LinkIdentity(ctx, &sso.LinkIdentityRequest{
+		Token:    respLogin.GetToken(),
+		Password: password,
+		State:    state,
+		Code:     code,
+	})
+	require.NoError(t, err)
+
+	tokens := federatedLogin(ctx, t, st, provider, subject, gofakeit.Email(), false)
+	require.Equal(t, uid, parseClaims(t, tokens.GetToken(), appSecret)["uid"])
+
+	_, err = st.AuthClient.UnlinkIdentity(ctx, &sso.UnlinkIdentityRequest{
+		Token:    respLogin.GetToken(),
+		Password: password,
+		Provider: providerName,
+	})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.UnlinkIdentity(ctx, &sso.UnlinkIdentityRequest{
+		Token:    respLogin.GetToken(),
+		Password: password,
+		Provider: providerName,
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "identity not linked")
+}
+
+func TestLinkIdentity_FailCases(t *testing.T) {
+	ctx, st := suite.New(t)
+	provider := st.OIDCProvider(t)
+
+	email, password := registerUser(ctx, t, st)
+	respLogin, err := st.AuthClient.Login(ctx, &sso.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    appId,
+	})
+	require.NoError(t, err)
+
+	t.Run("Wrong password", func(t *testing.T) {
+		resp := beginFederatedLogin(ctx, t, st)
+		code, state := provider.Authorize(t, resp.GetAuthorizationUrl(), gofakeit.UUID(), gofakeit.Email(), true)
+
+		_, err := st.AuthClient.LinkIdentity(ctx, &sso.LinkIdentityRequest{
+			Token:    respLogin.GetToken(),
+			Password: "wrong-password",
+			State:    state,
+			Code:     code,
+		})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid password")
+	})
+
+	t.Run("Identity of another user", func(t *testing.T) {
+		// the first federated login provisions another user with the identity
+		subject := gofakeit.UUID()
+		federatedLogin(ctx, t, st, provider, subject, gofakeit.Email(), true)
+
+		resp := beginFederatedLogin(ctx, t, st)
+		code, state := provider.Authorize(t, resp.GetAuthorizationUrl(), subject, gofakeit.Email(), true)
+
+		_, err := st.AuthClient.LinkIdentity(ctx, &sso.LinkIdentityRequest{
+			Token:    respLogin.GetToken(),
+			Password: password,
+			State:    state,
+			Code:     code,
+		})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "identity already linked")
+	})
+
+	t.Run("Unlink with invalid token", func(t *testing.T) {
+		_, err := st.AuthClient.UnlinkIdentity(ctx, &sso.UnlinkIdentityRequest{
+			Token:    "not-a-token",
+			Password: password,
+			Provider: providerName,
+		})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid token")
+	})
+}
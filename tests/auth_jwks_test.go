@@ -0,0 +1,38 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	sso "github.com/Rasikrr/protobuff/protos/gen/go/sso"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"sso/tests/suite"
+	"testing"
+)
+
+func TestJWKS_GRPCAndHTTPMatch(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	respJWKS, err := st.AuthClient.GetJWKS(ctx, &sso.GetJWKSRequest{})
+	require.NoError(t, err)
+	for _, k := range respJWKS.GetKeys() {
+		require.NotEmpty(t, k.GetKid())
+		require.Equal(t, "sig", k.GetUse())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		fmt.Sprintf("http://localhost:%d/.well-known/jwks.json", st.Cfg.HTTP.Port), nil)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var set struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+		} `json:"keys"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&set))
+	require.Len(t, set.Keys, len(respJWKS.GetKeys()))
+}
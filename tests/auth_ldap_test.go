@@ -0,0 +1,211 @@
+package tests
+
+import (
+	sso "github.com/Rasikrr/protobuff/protos/gen/go/sso"
+	"github.com/brianvoe/gofakeit"
+	"github.com/stretchr/testify/require"
+	"sso/tests/suite"
+	"testing"
+)
+
+const (
+	// directoryAppID is the corp app whose users sign in at the fake directory.
+	directoryAppID     = 101
+	directoryAppSecret = "corp-secret"
+)
+
+func TestLDAPLogin_HappyPath(t *testing.T) {
+	ctx, st := suite.New(t)
+	dir := st.LDAPDirectory(t)
+
+	email := gofakeit.Email()
+	password := generateRandomPassword()
+	dn := dir.AddUser(email, password, suite.LDAPAdminsGroup, suite.LDAPStaffGroup)
+
+	// the first login provisions the user with the roles of the groups
+	resp, err := st.AuthClient.Login(ctx, &sso.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    directoryAppID,
+	})
+	require.NoError(t, err)
+	claims := parseClaims(t, resp.GetToken(), directoryAppSecret)
+	require.Equal(t, email, claims["email"])
+	require.Equal(t, []interface{}{"admin", "staff"}, claims["roles"])
+
+	// roles follow the groups on every login
+	dir.SetGroups(dn, suite.LDAPStaffGroup)
+	resp, err = st.AuthClient.Login(ctx, &sso.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    directoryAppID,
+	})
+	require.NoError(t, err)
+	again := parseClaims(t, resp.GetToken(), directoryAppSecret)
+	require.Equal(t, claims["uid"], again["uid"])
+	require.Equal(t, []interface{}{"staff"}, again["roles"])
+
+	refreshed, err := st.AuthClient.Refresh(ctx, &sso.RefreshRequest{
+		RefreshToken: resp.GetRefreshToken(),
+	})
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{"staff"}, parseClaims(t, refreshed.GetToken(), directoryAppSecret)["roles"])
+}
+
+func TestLDAPLogin_LocalUser(t *testing.T) {
+	ctx, st := suite.New(t)
+	st.LDAPDirectory(t)
+
+	// users who are not in the directory sign in with their local password
+	email, password := registerUser(ctx, t, st)
+	resp, err := st.AuthClient.Login(ctx, &sso.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    directoryAppID,
+	})
+	require.NoError(t, err)
+	require.NotContains(t, parseClaims(t, resp.GetToken(), directoryAppSecret), "roles")
+}
+
+func TestLDAPLogin_LinkDirectory(t *testing.T) {
+	ctx, st := suite.New(t)
+	dir := st.LDAPDirectory(t)
+
+	email, password := registerUser(ctx, t, st)
+	match := verificationTokenRe.FindStringSubmatch(st.LastMail(t, email))
+	require.Len(t, match, 2)
+	_, err := st.AuthClient.VerifyEmail(ctx, &sso.VerifyEmailRequest{
+		Token: match[1],
+	})
+	require.NoError(t, err)
+
+	// even a verified account is not taken over by the directory entry of its email
+	directoryPassword := generateRandomPassword()
+	dir.AddUser(email, directoryPassword, suite.LDAPStaffGroup)
+	_, err = st.AuthClient.Login(ctx, &sso.LoginRequest{
+		Email:    email,
+		Password: directoryPassword,
+		AppId:    directoryAppID,
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "account already exists")
+
+	// its owner links it with the local password and the directory password
+	token := login(ctx, t, st, email, password, appId)
+	_, err = st.AuthClient.LinkDirectory(ctx, &sso.LinkDirectoryRequest{
+		Token:             token,
+		Password:          password,
+		AppId:             directoryAppID,
+		DirectoryPassword: "wrong-password",
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "invalid directory credentials")
+
+	_, err = st.AuthClient.LinkDirectory(ctx, &sso.LinkDirectoryRequest{
+		Token:             token,
+		Password:          password,
+		AppId:             directoryAppID,
+		DirectoryPassword: directoryPassword,
+	})
+	require.NoError(t, err)
+
+	resp, err := st.AuthClient.Login(ctx, &sso.LoginRequest{
+		Email:    email,
+		Password: directoryPassword,
+		AppId:    directoryAppID,
+	})
+	require.NoError(t, err)
+	claims := parseClaims(t, resp.GetToken(), directoryAppSecret)
+	require.Equal(t, parseClaims(t, token, appSecret)["uid"], claims["uid"])
+	require.Equal(t, []interface{}{"staff"}, claims["roles"])
+}
+
+func TestLDAPLogin_Reauthenticate(t *testing.T) {
+	ctx, st := suite.New(t)
+	dir := st.LDAPDirectory(t)
+
+	email := gofakeit.Email()
+	password := generateRandomPassword()
+	dir.AddUser(email, password)
+
+	resp, err := st.AuthClient.Login(ctx, &sso.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    directoryAppID,
+	})
+	require.NoError(t, err)
+
+	// account changes are confirmed with the directory password, the local one is unusable
+	_, err = st.AuthClient.ChangeEmail(ctx, &sso.ChangeEmailRequest{
+		Token:    resp.GetToken(),
+		Password: "wrong-password",
+		NewEmail: gofakeit.Email(),
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "invalid password")
+
+	_, err = st.AuthClient.ChangeEmail(ctx, &sso.ChangeEmailRequest{
+		Token:    resp.GetToken(),
+		Password: password,
+		NewEmail: gofakeit.Email(),
+	})
+	require.NoError(t, err)
+}
+
+func TestLDAPLogin_FailCases(t *testing.T) {
+	ctx, st := suite.New(t)
+	dir := st.LDAPDirectory(t)
+
+	email := gofakeit.Email()
+	password := generateRandomPassword()
+	dir.AddUser(email, password)
+
+	// a local account is not taken over by the directory entry of its email
+	localEmail, localPassword := registerUser(ctx, t, st)
+	directoryPassword := generateRandomPassword()
+	dir.AddUser(localEmail, directoryPassword)
+
+	tests := []struct {
+		name        string
+		email       string
+		password    string
+		expectedErr string
+	}{
+		{
+			name:        "Wrong directory password",
+			email:       email,
+			password:    "wrong-password",
+			expectedErr: "invalid credentials",
+		},
+		{
+			name:        "Local account of the entry",
+			email:       localEmail,
+			password:    directoryPassword,
+			expectedErr: "account already exists",
+		},
+		{
+			name:        "Local password of a directory user",
+			email:       localEmail,
+			password:    localPassword,
+			expectedErr: "invalid credentials",
+		},
+		{
+			name:        "Unknown user",
+			email:       gofakeit.Email(),
+			password:    password,
+			expectedErr: "invalid credentials",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := st.AuthClient.Login(ctx, &sso.LoginRequest{
+				Email:    tt.email,
+				Password: tt.password,
+				AppId:    directoryAppID,
+			})
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tt.expectedErr)
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package tests
+
+import (
+	sso "github.com/Rasikrr/protobuff/protos/gen/go/sso"
+	"github.com/stretchr/testify/require"
+	"sso/tests/suite"
+	"testing"
+)
+
+func TestLogout_HappyPath(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	respLogin := registerAndLogin(ctx, t, st)
+
+	respRevoked, err := st.AuthClient.IsTokenRevoked(ctx, &sso.IsTokenRevokedRequest{
+		Token: respLogin.GetToken(),
+	})
+	require.NoError(t, err)
+	require.False(t, respRevoked.GetRevoked())
+
+	_, err = st.AuthClient.Logout(ctx, &sso.LogoutRequest{
+		Token:        respLogin.GetToken(),
+		RefreshToken: respLogin.GetRefreshToken(),
+	})
+	require.NoError(t, err)
+
+	respRevoked, err = st.AuthClient.IsTokenRevoked(ctx, &sso.IsTokenRevokedRequest{
+		Token: respLogin.GetToken(),
+	})
+	require.NoError(t, err)
+	require.True(t, respRevoked.GetRevoked())
+
+	_, err = st.AuthClient.Refresh(ctx, &sso.RefreshRequest{
+		RefreshToken: respLogin.GetRefreshToken(),
+	})
+	require.Error(t, err)
+	require.ErrorContains(t, err, "invalid refresh token")
+}
+
+func TestLogout_InvalidToken(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	_, err := st.AuthClient.Logout(ctx, &sso.LogoutRequest{
+		Token: "",
+	})
+	require.Error(t, err)
+	require.ErrorContains(t, err, "token is required")
+
+	_, err = st.AuthClient.Logout(ctx, &sso.LogoutRequest{
+		Token: "not-a-jwt",
+	})
+	require.Error(t, err)
+	require.ErrorContains(t, err, "invalid token")
+}
@@ -0,0 +1,255 @@
+package tests
+
+import (
+	"context"
+	sso "github.com/Rasikrr/protobuff/protos/gen/go/sso"
+	"github.com/brianvoe/gofakeit"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sso/internal/lib/totp"
+	"sso/tests/suite"
+	"testing"
+	"time"
+)
+
+func TestMFA_TOTP_HappyPath(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	password := generateRandomPassword()
+
+	_, err := st.AuthClient.Register(ctx, &sso.RegisterRequest{
+		Email:    email,
+		Password: password,
+	})
+	require.NoError(t, err)
+
+	respLogin, err := st.AuthClient.Login(ctx, &sso.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    appId,
+	})
+	require.NoError(t, err)
+
+	// the access token alone does not enroll a second factor
+	_, err = st.AuthClient.EnrollTOTP(ctx, &sso.EnrollTOTPRequest{
+		Token:    respLogin.GetToken(),
+		Password: "wrong-password",
+	})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	respEnroll, err := st.AuthClient.EnrollTOTP(ctx, &sso.EnrollTOTPRequest{
+		Token:    respLogin.GetToken(),
+		Password: password,
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, respEnroll.GetSecret())
+	require.Contains(t, respEnroll.GetUri(), "otpauth://totp/")
+
+	step := totp.Step(time.Now())
+	code, err := totp.Code(respEnroll.GetSecret(), step)
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.ConfirmTOTP(ctx, &sso.ConfirmTOTPRequest{
+		Token:    respLogin.GetToken(),
+		Password: "wrong-password",
+		Code:     code,
+	})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	respConfirm, err := st.AuthClient.ConfirmTOTP(ctx, &sso.ConfirmTOTPRequest{
+		Token:    respLogin.GetToken(),
+		Password: password,
+		Code:     code,
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, respConfirm.GetRecoveryCodes())
+
+	respLogin, err = st.AuthClient.Login(ctx, &sso.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    appId,
+	})
+	require.NoError(t, err)
+	require.True(t, respLogin.GetMfaRequired())
+	require.Empty(t, respLogin.GetToken())
+	require.NotEmpty(t, respLogin.GetMfaChallengeId())
+
+	// a code can't be replayed
+	_, err = st.AuthClient.VerifyMFA(ctx, &sso.VerifyMFARequest{
+		ChallengeId: respLogin.GetMfaChallengeId(),
+		Code:        code,
+	})
+	require.Error(t, err)
+	require.ErrorContains(t, err, "invalid code")
+
+	nextCode, err := totp.Code(respEnroll.GetSecret(), step+1)
+	require.NoError(t, err)
+
+	respVerify, err := st.AuthClient.VerifyMFA(ctx, &sso.VerifyMFARequest{
+		ChallengeId: respLogin.GetMfaChallengeId(),
+		Code:        nextCode,
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, respVerify.GetToken())
+	require.NotEmpty(t, respVerify.GetRefreshToken())
+
+	// a challenge can be completed only once
+	_, err = st.AuthClient.VerifyMFA(ctx, &sso.VerifyMFARequest{
+		ChallengeId: respLogin.GetMfaChallengeId(),
+		Code:        nextCode,
+	})
+	require.Error(t, err)
+	require.ErrorContains(t, err, "invalid or expired challenge")
+}
+
+func TestMFA_RecoveryCode(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	password := generateRandomPassword()
+
+	_, err := st.AuthClient.Register(ctx, &sso.RegisterRequest{
+		Email:    email,
+		Password: password,
+	})
+	require.NoError(t, err)
+
+	respLogin, err := st.AuthClient.Login(ctx, &sso.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    appId,
+	})
+	require.NoError(t, err)
+
+	respEnroll, err := st.AuthClient.EnrollTOTP(ctx, &sso.EnrollTOTPRequest{
+		Token:    respLogin.GetToken(),
+		Password: password,
+	})
+	require.NoError(t, err)
+
+	code, err := totp.Code(respEnroll.GetSecret(), totp.Step(time.Now()))
+	require.NoError(t, err)
+
+	respConfirm, err := st.AuthClient.ConfirmTOTP(ctx, &sso.ConfirmTOTPRequest{
+		Token:    respLogin.GetToken(),
+		Password: password,
+		Code:     code,
+	})
+	require.NoError(t, err)
+	recoveryCode := respConfirm.GetRecoveryCodes()[0]
+
+	for i, expectErr := range []bool{false, true} {
+		respLogin, err := st.AuthClient.Login(ctx, &sso.LoginRequest{
+			Email:    email,
+			Password: password,
+			AppId:    appId,
+		})
+		require.NoError(t, err)
+
+		_, err = st.AuthClient.VerifyMFA(ctx, &sso.VerifyMFARequest{
+			ChallengeId: respLogin.GetMfaChallengeId(),
+			Code:        recoveryCode,
+		})
+		if expectErr {
+			require.Error(t, err, "recovery code reused on attempt %d", i)
+			require.ErrorContains(t, err, "invalid code")
+		} else {
+			require.NoError(t, err)
+		}
+	}
+}
+
+func TestMFA_FailuresThrottleLogin(t *testing.T) {
+	ctx, st := suite.New(t)
+	if st.Cfg.BruteForce.Delay <= 0 {
+		t.Skip("login delays are disabled")
+	}
+
+	email, password := registerUser(ctx, t, st)
+	enrollTOTP(ctx, t, st, login(ctx, t, st, email, password, appId), password)
+
+	// every login brings a new challenge, the failed codes are counted across them
+	for i := 0; i < st.Cfg.BruteForce.UserFreeAttempts; i++ {
+		respLogin, err := st.AuthClient.Login(ctx, &sso.LoginRequest{
+			Email:    email,
+			Password: password,
+			AppId:    appId,
+		})
+		require.NoError(t, err)
+		require.True(t, respLogin.GetMfaRequired())
+
+		_, err = st.AuthClient.VerifyMFA(ctx, &sso.VerifyMFARequest{
+			ChallengeId: respLogin.GetMfaChallengeId(),
+			Code:        "invalid-code",
+		})
+		require.ErrorContains(t, err, "invalid code")
+	}
+
+	_, err := st.AuthClient.Login(ctx, &sso.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    appId,
+	})
+	retryDelay(t, err, codes.ResourceExhausted, "TOO_MANY_LOGIN_ATTEMPTS")
+}
+
+// enrollTOTP enables TOTP for the user of the token and returns the secret.
+func enrollTOTP(ctx context.Context, t *testing.T, st *suite.Suite, token, password string) string {
+	t.Helper()
+
+	respEnroll, err := st.AuthClient.EnrollTOTP(ctx, &sso.EnrollTOTPRequest{
+		Token:    token,
+		Password: password,
+	})
+	require.NoError(t, err)
+
+	code, err := totp.Code(respEnroll.GetSecret(), totp.Step(time.Now()))
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.ConfirmTOTP(ctx, &sso.ConfirmTOTPRequest{
+		Token:    token,
+		Password: password,
+		Code:     code,
+	})
+	require.NoError(t, err)
+	return respEnroll.GetSecret()
+}
+
+func TestMFA_RecoveryCodesFailuresThrottleLogin(t *testing.T) {
+	ctx, st := suite.New(t)
+	if st.Cfg.BruteForce.Delay <= 0 {
+		t.Skip("login delays are disabled")
+	}
+
+	email, password := registerUser(ctx, t, st)
+	token := login(ctx, t, st, email, password, appId)
+	secret := enrollTOTP(ctx, t, st, token, password)
+
+	code, err := totp.Code(secret, totp.Step(time.Now()))
+	require.NoError(t, err)
+	invalidCode := code[:5] + string('0'+(code[5]-'0'+1)%10)
+
+	// the codes guessed with the access token are counted like the ones of the challenges
+	for i := 0; i < st.Cfg.BruteForce.UserFreeAttempts; i++ {
+		_, err := st.AuthClient.RegenerateRecoveryCodes(ctx, &sso.RegenerateRecoveryCodesRequest{
+			Token: token,
+			Code:  invalidCode,
+		})
+		require.ErrorContains(t, err, "invalid code")
+	}
+
+	_, err = st.AuthClient.RegenerateRecoveryCodes(ctx, &sso.RegenerateRecoveryCodesRequest{
+		Token: token,
+		Code:  invalidCode,
+	})
+	retryDelay(t, err, codes.ResourceExhausted, "TOO_MANY_LOGIN_ATTEMPTS")
+
+	_, err = st.AuthClient.Login(ctx, &sso.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    appId,
+	})
+	retryDelay(t, err, codes.ResourceExhausted, "TOO_MANY_LOGIN_ATTEMPTS")
+}
@@ -0,0 +1,182 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	sso "github.com/Rasikrr/protobuff/protos/gen/go/sso"
+	"github.com/brianvoe/gofakeit"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/url"
+	"sso/internal/lib/pkce"
+	"sso/tests/suite"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const (
+	redirectURI  = "http://localhost:3000/callback"
+	codeVerifier = "dBjftJeZ4CVP-mJ92K9qqxE0Dkx3JqTr6ZyD8Xmb3ow1a"
+)
+
+// noRedirectClient returns redirects to the test instead of following them.
+var noRedirectClient = &http.Client{
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+func TestOAuth_AuthorizationCode_HappyPath(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email, password := registerUser(ctx, t, st)
+	params := authorizationParams()
+
+	resp, err := noRedirectClient.Get(st.HTTPURL("/oauth/authorize?" + params.Encode()))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	location := postAuthorize(t, st, params, email, password)
+	require.Equal(t, "state-123", location.Query().Get("state"))
+	code := location.Query().Get("code")
+	require.NotEmpty(t, code)
+
+	status, body := postToken(t, st, url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {strconv.Itoa(appId)},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {codeVerifier},
+	})
+	require.Equal(t, http.StatusOK, status)
+	require.NotEmpty(t, body["access_token"])
+	require.NotEmpty(t, body["refresh_token"])
+	require.Equal(t, "Bearer", body["token_type"])
+
+	status, refreshed := postToken(t, st, url.Values{
+		"grant_type":    {"refresh_token"},
+		"client_id":     {strconv.Itoa(appId)},
+		"refresh_token": {body["refresh_token"].(string)},
+	})
+	require.Equal(t, http.StatusOK, status)
+	require.NotEmpty(t, refreshed["refresh_token"])
+
+	// replaying the code fails and revokes the tokens issued for it
+	status, errBody := postToken(t, st, url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {strconv.Itoa(appId)},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {codeVerifier},
+	})
+	require.Equal(t, http.StatusBadRequest, status)
+	require.Equal(t, "invalid_grant", errBody["error"])
+
+	status, _ = postToken(t, st, url.Values{
+		"grant_type":    {"refresh_token"},
+		"client_id":     {strconv.Itoa(appId)},
+		"refresh_token": {refreshed["refresh_token"].(string)},
+	})
+	require.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestOAuth_AuthorizationCode_WrongVerifier(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email, password := registerUser(ctx, t, st)
+	location := postAuthorize(t, st, authorizationParams(), email, password)
+
+	status, body := postToken(t, st, url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {strconv.Itoa(appId)},
+		"code":          {location.Query().Get("code")},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {strings.Repeat("a", 43)},
+	})
+	require.Equal(t, http.StatusBadRequest, status)
+	require.Equal(t, "invalid_grant", body["error"])
+}
+
+func TestOAuth_Authorize_FailCases(t *testing.T) {
+	_, st := suite.New(t)
+
+	unregistered := authorizationParams()
+	unregistered.Set("redirect_uri", "http://evil.example.com/callback")
+
+	resp, err := noRedirectClient.Get(st.HTTPURL("/oauth/authorize?" + unregistered.Encode()))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	noPKCE := authorizationParams()
+	noPKCE.Del("code_challenge")
+
+	resp, err = noRedirectClient.Get(st.HTTPURL("/oauth/authorize?" + noPKCE.Encode()))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+	location, err := resp.Location()
+	require.NoError(t, err)
+	require.Equal(t, "invalid_request", location.Query().Get("error"))
+}
+
+func registerUser(ctx context.Context, t *testing.T, st *suite.Suite) (string, string) {
+	t.Helper()
+
+	email := gofakeit.Email()
+	password := generateRandomPassword()
+
+	_, err := st.AuthClient.Register(ctx, &sso.RegisterRequest{
+		Email:    email,
+		Password: password,
+	})
+	require.NoError(t, err)
+	return email, password
+}
+
+func authorizationParams() url.Values {
+	return url.Values{
+		"response_type":         {"code"},
+		"client_id":             {strconv.Itoa(appId)},
+		"redirect_uri":          {redirectURI},
+		"state":                 {"state-123"},
+		"code_challenge":        {pkce.Challenge(codeVerifier)},
+		"code_challenge_method": {pkce.MethodS256},
+	}
+}
+
+// postAuthorize submits the login form and returns the redirect location.
+func postAuthorize(t *testing.T, st *suite.Suite, params url.Values, email, password string) *url.URL {
+	t.Helper()
+
+	form := url.Values{}
+	for k, v := range params {
+		form[k] = v
+	}
+	form.Set("email", email)
+	form.Set("password", password)
+
+	resp, err := noRedirectClient.PostForm(st.HTTPURL("/oauth/authorize"), form)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+
+	location, err := resp.Location()
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(location.String(), redirectURI))
+	return location
+}
+
+func postToken(t *testing.T, st *suite.Suite, form url.Values) (int, map[string]interface{}) {
+	t.Helper()
+
+	resp, err := http.PostForm(st.HTTPURL("/oauth/token"), form)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var body map[string]interface{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	return resp.StatusCode, body
+}
@@ -0,0 +1,93 @@
+package tests
+
+import (
+	"encoding/json"
+	sso "github.com/Rasikrr/protobuff/protos/gen/go/sso"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/url"
+	"sso/tests/suite"
+	"strconv"
+	"testing"
+)
+
+func TestOIDC_Discovery(t *testing.T) {
+	_, st := suite.New(t)
+
+	resp, err := http.Get(st.HTTPURL("/.well-known/openid-configuration"))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var doc map[string]interface{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&doc))
+	require.Equal(t, st.Cfg.OAuth.Issuer, doc["issuer"])
+	require.Equal(t, st.Cfg.OAuth.Issuer+"/.well-known/jwks.json", doc["jwks_uri"])
+	require.Equal(t, st.Cfg.OAuth.Issuer+"/oauth/userinfo", doc["userinfo_endpoint"])
+}
+
+func TestOIDC_IDTokenAndUserInfo(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email, password := registerUser(ctx, t, st)
+	params := authorizationParams()
+	params.Set("scope", "openid email")
+	params.Set("nonce", "nonce-456")
+
+	location := postAuthorize(t, st, params, email, password)
+	status, body := postToken(t, st, url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {strconv.Itoa(appId)},
+		"code":          {location.Query().Get("code")},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {codeVerifier},
+	})
+	require.Equal(t, http.StatusOK, status)
+	require.NotEmpty(t, body["id_token"])
+
+	idToken, err := jwt.Parse(body["id_token"].(string), func(t *jwt.Token) (interface{}, error) {
+		return []byte(appSecret), nil
+	})
+	require.NoError(t, err)
+	claims := idToken.Claims.(jwt.MapClaims)
+	require.Equal(t, st.Cfg.OAuth.Issuer, claims["iss"])
+	require.Equal(t, strconv.Itoa(appId), claims["aud"])
+	require.Equal(t, "nonce-456", claims["nonce"])
+	require.Equal(t, email, claims["email"])
+	require.NotEmpty(t, claims["sub"])
+	require.NotEmpty(t, claims["auth_time"])
+
+	info := getUserInfo(t, st, body["access_token"].(string), http.StatusOK)
+	require.Equal(t, claims["sub"], info["sub"])
+	require.Equal(t, email, info["email"])
+	require.NotContains(t, info, "preferred_username")
+
+	// tokens issued by Login are not granted the openid scope
+	respLogin, err := st.AuthClient.Login(ctx, &sso.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    appId,
+	})
+	require.NoError(t, err)
+	getUserInfo(t, st, respLogin.GetToken(), http.StatusForbidden)
+
+	getUserInfo(t, st, "invalid", http.StatusUnauthorized)
+}
+
+func getUserInfo(t *testing.T, st *suite.Suite, accessToken string, wantStatus int) map[string]interface{} {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, st.HTTPURL("/oauth/userinfo"), nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, wantStatus, resp.StatusCode)
+
+	var body map[string]interface{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	return body
+}
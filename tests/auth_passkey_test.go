@@ -0,0 +1,121 @@
+package tests
+
+import (
+	sso "github.com/Rasikrr/protobuff/protos/gen/go/sso"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sso/tests/suite"
+	"testing"
+)
+
+func TestPasskey_RegisterLogin_HappyPath(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email, password := registerUser(ctx, t, st)
+	token := login(ctx, t, st, email, password, appId)
+	authenticator := st.NewAuthenticator(t)
+
+	respBegin, err := st.AuthClient.BeginPasskeyRegistration(ctx, &sso.BeginPasskeyRegistrationRequest{
+		Token:    token,
+		Password: password,
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, respBegin.GetSessionId())
+	require.NotEmpty(t, respBegin.GetOptions())
+
+	credential := authenticator.Create(t, respBegin.GetOptions())
+	_, err = st.AuthClient.FinishPasskeyRegistration(ctx, &sso.FinishPasskeyRegistrationRequest{
+		Token:      token,
+		SessionId:  respBegin.GetSessionId(),
+		Credential: credential,
+	})
+	require.NoError(t, err)
+
+	// a registration session can be completed only once
+	_, err = st.AuthClient.FinishPasskeyRegistration(ctx, &sso.FinishPasskeyRegistrationRequest{
+		Token:      token,
+		SessionId:  respBegin.GetSessionId(),
+		Credential: credential,
+	})
+	require.Error(t, err)
+	require.ErrorContains(t, err, "invalid or expired session")
+
+	respBeginLogin, err := st.AuthClient.BeginPasskeyLogin(ctx, &sso.BeginPasskeyLoginRequest{
+		AppId: appId,
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, respBeginLogin.GetSessionId())
+
+	assertion := authenticator.Get(t, respBeginLogin.GetOptions())
+	respFinishLogin, err := st.AuthClient.FinishPasskeyLogin(ctx, &sso.FinishPasskeyLoginRequest{
+		SessionId:  respBeginLogin.GetSessionId(),
+		Credential: assertion,
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, respFinishLogin.GetToken())
+	require.NotEmpty(t, respFinishLogin.GetRefreshToken())
+
+	// an assertion can't be replayed
+	_, err = st.AuthClient.FinishPasskeyLogin(ctx, &sso.FinishPasskeyLoginRequest{
+		SessionId:  respBeginLogin.GetSessionId(),
+		Credential: assertion,
+	})
+	require.Error(t, err)
+	require.ErrorContains(t, err, "invalid or expired session")
+}
+
+func TestPasskey_Register_RequiresReauthentication(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email, password := registerUser(ctx, t, st)
+	token := login(ctx, t, st, email, password, appId)
+
+	// the access token alone does not register a passkey
+	_, err := st.AuthClient.BeginPasskeyRegistration(ctx, &sso.BeginPasskeyRegistrationRequest{
+		Token: token,
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = st.AuthClient.BeginPasskeyRegistration(ctx, &sso.BeginPasskeyRegistrationRequest{
+		Token:    token,
+		Password: generateRandomPassword(),
+	})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// only users with TOTP enabled confirm with a code
+	_, err = st.AuthClient.BeginPasskeyRegistration(ctx, &sso.BeginPasskeyRegistrationRequest{
+		Token: token,
+		Code:  "123456",
+	})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
+
+func TestPasskey_Login_UnknownCredential(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email, password := registerUser(ctx, t, st)
+	token := login(ctx, t, st, email, password, appId)
+	authenticator := st.NewAuthenticator(t)
+
+	respBegin, err := st.AuthClient.BeginPasskeyRegistration(ctx, &sso.BeginPasskeyRegistrationRequest{
+		Token:    token,
+		Password: password,
+	})
+	require.NoError(t, err)
+
+	// the credential is created on the device but never sent to the server
+	authenticator.Create(t, respBegin.GetOptions())
+
+	respBeginLogin, err := st.AuthClient.BeginPasskeyLogin(ctx, &sso.BeginPasskeyLoginRequest{
+		AppId: appId,
+	})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.FinishPasskeyLogin(ctx, &sso.FinishPasskeyLoginRequest{
+		SessionId:  respBeginLogin.GetSessionId(),
+		Credential: authenticator.Get(t, respBeginLogin.GetOptions()),
+	})
+	require.Error(t, err)
+	require.ErrorContains(t, err, "invalid credential")
+}
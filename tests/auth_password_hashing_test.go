@@ -0,0 +1,53 @@
+package tests
+
+import (
+	sso "github.com/Rasikrr/protobuff/protos/gen/go/sso"
+	"github.com/stretchr/testify/require"
+	"sso/tests/suite"
+	"strings"
+	"testing"
+)
+
+const (
+	legacyEmail    = "legacy@sso.test"
+	legacyPassword = "legacy-password"
+	// legacyHash is the bcrypt hash of the legacy password in the fixture.
+	legacyHash = "$2a$10$1l6gbUis0y.Rojx6IgvpWOuMi/rKRn7hyIhjCn84Kcbbyh.ThogN."
+)
+
+func TestPasswordHashing_LegacyHash(t *testing.T) {
+	ctx, st := suite.New(t)
+	db := st.DB(t)
+
+	// an earlier run replaced the hash of the fixture already
+	_, err := db.ExecContext(ctx, `UPDATE users SET pass_hash=$1 WHERE email=$2`, legacyHash, legacyEmail)
+	require.NoError(t, err)
+
+	passHash := func() string {
+		var hash string
+		err := db.GetContext(ctx, &hash, `SELECT pass_hash FROM users WHERE email=$1`, legacyEmail)
+		require.NoError(t, err)
+		return hash
+	}
+	require.True(t, strings.HasPrefix(passHash(), "$2a$"))
+
+	// the first login replaces the bcrypt hash, the user has to be able to sign in with either
+	for i := 0; i < 2; i++ {
+		resp, err := st.AuthClient.Login(ctx, &sso.LoginRequest{
+			Email:    legacyEmail,
+			Password: legacyPassword,
+			AppId:    appId,
+		})
+		require.NoError(t, err)
+		require.NotEmpty(t, resp.GetToken())
+		require.True(t, strings.HasPrefix(passHash(), "$argon2id$"))
+	}
+
+	_, err = st.AuthClient.Login(ctx, &sso.LoginRequest{
+		Email:    legacyEmail,
+		Password: "wrong-password",
+		AppId:    appId,
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "invalid credentials")
+}
@@ -0,0 +1,147 @@
+package tests
+
+import (
+	sso "github.com/Rasikrr/protobuff/protos/gen/go/sso"
+	"github.com/brianvoe/gofakeit"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sso/tests/suite"
+	"testing"
+)
+
+const strictAppID = 102
+
+func TestPasswordPolicy_Global(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	_, err := st.AuthClient.Register(ctx, &sso.RegisterRequest{
+		Email:    gofakeit.Email(),
+		Password: "letmein",
+	})
+	rules := policyViolations(t, err)
+	require.Contains(t, rules, "min_length")
+	require.Contains(t, rules, "uppercase")
+	require.Contains(t, rules, "digit")
+	require.Contains(t, rules, "special")
+	require.Contains(t, rules, "banned_word")
+	require.NotContains(t, rules, "lowercase")
+
+	_, err = st.AuthClient.Register(ctx, &sso.RegisterRequest{
+		Email:    "marguerite.lopez@example.com",
+		Password: "Marguerite.Lopez1",
+	})
+	require.Equal(t, []string{"email_similarity"}, keys(policyViolations(t, err)))
+}
+
+func TestPasswordPolicy_App(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	// the password meets the policy of the config but not the one of the app
+	password := generateRandomPassword()
+	_, err := st.AuthClient.Register(ctx, &sso.RegisterRequest{
+		Email:    gofakeit.Email(),
+		Password: password,
+		AppId:    strictAppID,
+	})
+	require.Equal(t, []string{"min_length"}, keys(policyViolations(t, err)))
+
+	_, err = st.AuthClient.Register(ctx, &sso.RegisterRequest{
+		Email:    gofakeit.Email(),
+		Password: password,
+		AppId:    appId,
+	})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.Register(ctx, &sso.RegisterRequest{
+		Email:    gofakeit.Email(),
+		Password: "Acme-Corporation-2024",
+		AppId:    strictAppID,
+	})
+	require.Equal(t, []string{"banned_word"}, keys(policyViolations(t, err)))
+
+	email := gofakeit.Email()
+	strongPassword := password + generateRandomPassword()
+	_, err = st.AuthClient.Register(ctx, &sso.RegisterRequest{
+		Email:    email,
+		Password: strongPassword,
+		AppId:    strictAppID,
+	})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.Login(ctx, &sso.LoginRequest{
+		Email:    email,
+		Password: strongPassword,
+		AppId:    strictAppID,
+	})
+	require.NoError(t, err)
+}
+
+func TestPasswordPolicy_Reset(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email, _ := registerUser(ctx, t, st)
+
+	_, err := st.AuthClient.RequestPasswordReset(ctx, &sso.RequestPasswordResetRequest{
+		Email: email,
+	})
+	require.NoError(t, err)
+	match := resetTokenRe.FindStringSubmatch(st.LastMail(t, email))
+	require.Len(t, match, 2)
+
+	_, err = st.AuthClient.ConfirmPasswordReset(ctx, &sso.ConfirmPasswordResetRequest{
+		Token:       match[1],
+		NewPassword: "short",
+	})
+	require.Contains(t, policyViolations(t, err), "min_length")
+
+	// a rejected password does not use up the token
+	newPassword := generateRandomPassword()
+	_, err = st.AuthClient.ConfirmPasswordReset(ctx, &sso.ConfirmPasswordResetRequest{
+		Token:       match[1],
+		NewPassword: newPassword,
+	})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.Login(ctx, &sso.LoginRequest{
+		Email:    email,
+		Password: newPassword,
+		AppId:    appId,
+	})
+	require.NoError(t, err)
+}
+
+// policyViolations returns the broken rules of the password policy listed in the details of the error.
+func policyViolations(t *testing.T, err error) map[string]string {
+	t.Helper()
+
+	require.Error(t, err)
+	st, ok := status.FromError(err)
+	require.True(t, ok)
+	require.Equal(t, codes.InvalidArgument, st.Code())
+	require.Equal(t, "password does not meet the policy", st.Message())
+
+	var rules map[string]string
+	var violations int
+	for _, detail := range st.Details() {
+		switch d := detail.(type) {
+		case *errdetails.ErrorInfo:
+			require.Equal(t, "PASSWORD_POLICY_VIOLATION", d.GetReason())
+			rules = d.GetMetadata()
+		case *errdetails.BadRequest:
+			violations = len(d.GetFieldViolations())
+		}
+	}
+	require.NotEmpty(t, rules)
+	require.Len(t, rules, violations)
+	return rules
+}
+
+func keys(m map[string]string) []string {
+	res := make([]string, 0, len(m))
+	for k := range m {
+		res = append(res, k)
+	}
+	return res
+}
@@ -0,0 +1,141 @@
+package tests
+
+import (
+	sso "github.com/Rasikrr/protobuff/protos/gen/go/sso"
+	"github.com/brianvoe/gofakeit"
+	"github.com/stretchr/testify/require"
+	"regexp"
+	"sso/tests/suite"
+	"testing"
+)
+
+var resetTokenRe = regexp.MustCompile(`reset your password: (\S+)`)
+
+func TestPasswordReset_HappyPath(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	password := generateRandomPassword()
+	newPassword := generateRandomPassword()
+
+	_, err := st.AuthClient.Register(ctx, &sso.RegisterRequest{
+		Email:    email,
+		Password: password,
+	})
+	require.NoError(t, err)
+
+	respLogin, err := st.AuthClient.Login(ctx, &sso.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    appId,
+	})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.RequestPasswordReset(ctx, &sso.RequestPasswordResetRequest{
+		Email: email,
+	})
+	require.NoError(t, err)
+
+	match := resetTokenRe.FindStringSubmatch(st.LastMail(t, email))
+	require.Len(t, match, 2)
+	token := match[1]
+
+	_, err = st.AuthClient.ConfirmPasswordReset(ctx, &sso.ConfirmPasswordResetRequest{
+		Token:       token,
+		NewPassword: newPassword,
+	})
+	require.NoError(t, err)
+
+	// token is single-use
+	_, err = st.AuthClient.ConfirmPasswordReset(ctx, &sso.ConfirmPasswordResetRequest{
+		Token:       token,
+		NewPassword: newPassword,
+	})
+	require.Error(t, err)
+	require.ErrorContains(t, err, "invalid or expired token")
+
+	// existing sessions are invalidated
+	_, err = st.AuthClient.Refresh(ctx, &sso.RefreshRequest{
+		RefreshToken: respLogin.GetRefreshToken(),
+	})
+	require.Error(t, err)
+
+	_, err = st.AuthClient.Login(ctx, &sso.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    appId,
+	})
+	require.Error(t, err)
+
+	_, err = st.AuthClient.Login(ctx, &sso.LoginRequest{
+		Email:    email,
+		Password: newPassword,
+		AppId:    appId,
+	})
+	require.NoError(t, err)
+}
+
+func TestPasswordReset_UnknownEmail(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	_, err := st.AuthClient.RequestPasswordReset(ctx, &sso.RequestPasswordResetRequest{
+		Email: gofakeit.Email(),
+	})
+	require.NoError(t, err)
+}
+
+func TestPasswordReset_InvalidatesOtherTokens(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email, _ := registerUser(ctx, t, st)
+
+	requestToken := func() string {
+		_, err := st.AuthClient.RequestPasswordReset(ctx, &sso.RequestPasswordResetRequest{
+			Email: email,
+		})
+		require.NoError(t, err)
+
+		match := resetTokenRe.FindStringSubmatch(st.LastMail(t, email))
+		require.Len(t, match, 2)
+		return match[1]
+	}
+	first := requestToken()
+	second := requestToken()
+
+	_, err := st.AuthClient.ConfirmPasswordReset(ctx, &sso.ConfirmPasswordResetRequest{
+		Token:       second,
+		NewPassword: generateRandomPassword(),
+	})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.ConfirmPasswordReset(ctx, &sso.ConfirmPasswordResetRequest{
+		Token:       first,
+		NewPassword: generateRandomPassword(),
+	})
+	require.ErrorContains(t, err, "invalid or expired token")
+}
+
+func TestPasswordReset_History(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	// the reset belongs to no app, the longest history of the apps applies
+	email, password := registerUser(ctx, t, st)
+	_, err := st.AuthClient.RequestPasswordReset(ctx, &sso.RequestPasswordResetRequest{
+		Email: email,
+	})
+	require.NoError(t, err)
+	match := resetTokenRe.FindStringSubmatch(st.LastMail(t, email))
+	require.Len(t, match, 2)
+
+	_, err = st.AuthClient.ConfirmPasswordReset(ctx, &sso.ConfirmPasswordResetRequest{
+		Token:       match[1],
+		NewPassword: password,
+	})
+	require.Contains(t, policyViolations(t, err), "history")
+
+	_, err = st.AuthClient.ConfirmPasswordReset(ctx, &sso.ConfirmPasswordResetRequest{
+		Token:       match[1],
+		NewPassword: generateRandomPassword(),
+	})
+	require.NoError(t, err)
+}
@@ -0,0 +1,50 @@
+package tests
+
+import (
+	sso "github.com/Rasikrr/protobuff/protos/gen/go/sso"
+	"github.com/brianvoe/gofakeit"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sso/internal/config"
+	"sso/tests/suite"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	limit := config.RateLimit{Rate: st.Cfg.RateLimit.Rate, Burst: st.Cfg.RateLimit.Burst}
+	for method, l := range st.Cfg.RateLimit.Methods {
+		if strings.HasSuffix(method, "/ValidateToken") {
+			limit = l
+		}
+	}
+	if limit.Rate <= 0 {
+		t.Skip("rate limit is disabled")
+	}
+
+	// the requests are limited per app, an unknown app keeps the other tests out of the bucket
+	appID := int32(gofakeit.Number(1_000_000, 2_000_000_000))
+	validate := func() error {
+		_, err := st.AuthClient.ValidateToken(ctx, &sso.ValidateTokenRequest{
+			Token: "invalid",
+			AppId: appID,
+		})
+		return err
+	}
+
+	var err error
+	for i := 0; i < 2*max(limit.Burst, 1); i++ {
+		if err = validate(); status.Code(err) == codes.ResourceExhausted {
+			break
+		}
+	}
+	retryAfter := retryDelay(t, err, codes.ResourceExhausted, "RATE_LIMIT_EXCEEDED")
+	require.LessOrEqual(t, retryAfter, time.Duration(float64(time.Second)/limit.Rate))
+
+	time.Sleep(retryAfter)
+	require.NotEqual(t, codes.ResourceExhausted, status.Code(validate()))
+}
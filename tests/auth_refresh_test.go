@@ -0,0 +1,85 @@
+package tests
+
+import (
+	"context"
+	sso "github.com/Rasikrr/protobuff/protos/gen/go/sso"
+	"github.com/brianvoe/gofakeit"
+	"github.com/stretchr/testify/require"
+	"sso/tests/suite"
+	"testing"
+)
+
+func TestRefresh_HappyPath(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	respLogin := registerAndLogin(ctx, t, st)
+	require.NotEmpty(t, respLogin.GetRefreshToken())
+
+	respRefresh, err := st.AuthClient.Refresh(ctx, &sso.RefreshRequest{
+		RefreshToken: respLogin.GetRefreshToken(),
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, respRefresh.GetToken())
+	require.NotEmpty(t, respRefresh.GetRefreshToken())
+	require.NotEqual(t, respLogin.GetRefreshToken(), respRefresh.GetRefreshToken())
+}
+
+func TestRefresh_ReuseRevokesFamily(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	respLogin := registerAndLogin(ctx, t, st)
+
+	respRefresh, err := st.AuthClient.Refresh(ctx, &sso.RefreshRequest{
+		RefreshToken: respLogin.GetRefreshToken(),
+	})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.Refresh(ctx, &sso.RefreshRequest{
+		RefreshToken: respLogin.GetRefreshToken(),
+	})
+	require.Error(t, err)
+	require.ErrorContains(t, err, "invalid refresh token")
+
+	_, err = st.AuthClient.Refresh(ctx, &sso.RefreshRequest{
+		RefreshToken: respRefresh.GetRefreshToken(),
+	})
+	require.Error(t, err)
+	require.ErrorContains(t, err, "invalid refresh token")
+}
+
+func TestRefresh_InvalidToken(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	_, err := st.AuthClient.Refresh(ctx, &sso.RefreshRequest{
+		RefreshToken: "",
+	})
+	require.Error(t, err)
+	require.ErrorContains(t, err, "refresh_token is required")
+
+	_, err = st.AuthClient.Refresh(ctx, &sso.RefreshRequest{
+		RefreshToken: gofakeit.UUID(),
+	})
+	require.Error(t, err)
+	require.ErrorContains(t, err, "invalid refresh token")
+}
+
+func registerAndLogin(ctx context.Context, t *testing.T, st *suite.Suite) *sso.LoginResponse {
+	t.Helper()
+
+	email := gofakeit.Email()
+	password := generateRandomPassword()
+
+	_, err := st.AuthClient.Register(ctx, &sso.RegisterRequest{
+		Email:    email,
+		Password: password,
+	})
+	require.NoError(t, err)
+
+	respLogin, err := st.AuthClient.Login(ctx, &sso.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    appId,
+	})
+	require.NoError(t, err)
+	return respLogin
+}
@@ -102,7 +102,7 @@ func TestRegisterLogin_Register_InvalidCredentials(t *testing.T) {
 			name:        gofakeit.Name(),
 			email:       gofakeit.Email(),
 			password:    "1",
-			expectedErr: "password must contain at least one upper, one digit and one spec.symbol",
+			expectedErr: "password does not meet the policy",
 		},
 		{
 			num:         3,
@@ -0,0 +1,330 @@
+package tests
+
+import (
+	"encoding/json"
+	sso "github.com/Rasikrr/protobuff/protos/gen/go/sso"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"net/url"
+	"sso/tests/suite"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const (
+	tokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+	tokenTypeUserID      = "urn:sso:params:oauth:token-type:user_id"
+
+	// audienceAppID is the orders app the test client may exchange tokens for.
+	audienceAppID     = 100
+	audienceAppSecret = "orders-secret"
+
+	supportEmail    = "support@sso.test"
+	supportPassword = "Support-Passw0rd!"
+)
+
+func TestTokenExchange_Delegation_HappyPath(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	respLogin := registerAndLogin(ctx, t, st)
+
+	resp, err := st.AuthClient.ExchangeToken(ctx, &sso.ExchangeTokenRequest{
+		ClientId:         clientID,
+		ClientSecret:     clientSecret,
+		SubjectToken:     respLogin.GetToken(),
+		SubjectTokenType: tokenTypeAccessToken,
+		Audience:         strconv.Itoa(audienceAppID),
+		Scope:            "orders:read",
+	})
+	require.NoError(t, err)
+	require.Equal(t, "orders:read", resp.GetScope())
+	require.Equal(t, tokenTypeAccessToken, resp.GetIssuedTokenType())
+
+	subject := parseClaims(t, respLogin.GetToken(), appSecret)
+	claims := parseClaims(t, resp.GetToken(), audienceAppSecret)
+	require.Equal(t, subject["uid"], claims["uid"])
+	require.Equal(t, float64(audienceAppID), claims["app_id"])
+	require.Equal(t, "orders:read", claims["scope"])
+	require.Equal(t, map[string]interface{}{"sub": clientID}, claims["act"])
+
+	info, err := st.AuthClient.ValidateToken(ctx, &sso.ValidateTokenRequest{
+		Token: resp.GetToken(),
+		AppId: audienceAppID,
+	})
+	require.NoError(t, err)
+	require.True(t, info.GetActive())
+	require.Equal(t, clientID, info.GetActor())
+
+	// every scope of the policy is granted when none is requested
+	resp, err = st.AuthClient.ExchangeToken(ctx, &sso.ExchangeTokenRequest{
+		ClientId:         clientID,
+		ClientSecret:     clientSecret,
+		SubjectToken:     respLogin.GetToken(),
+		SubjectTokenType: tokenTypeAccessToken,
+		Audience:         strconv.Itoa(audienceAppID),
+	})
+	require.NoError(t, err)
+	require.Equal(t, "orders:read orders:write", resp.GetScope())
+}
+
+func TestTokenExchange_Impersonation_HappyPath(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	respUser := registerAndLogin(ctx, t, st)
+	userID := parseClaims(t, respUser.GetToken(), appSecret)["uid"].(float64)
+
+	respSupport, err := st.AuthClient.Login(ctx, &sso.LoginRequest{
+		Email:    supportEmail,
+		Password: supportPassword,
+		AppId:    appId,
+	})
+	require.NoError(t, err)
+	supportID := parseClaims(t, respSupport.GetToken(), appSecret)["uid"].(float64)
+
+	resp, err := st.AuthClient.ExchangeToken(ctx, &sso.ExchangeTokenRequest{
+		ClientId:         clientID,
+		ClientSecret:     clientSecret,
+		SubjectToken:     strconv.FormatInt(int64(userID), 10),
+		SubjectTokenType: tokenTypeUserID,
+		ActorToken:       respSupport.GetToken(),
+		ActorTokenType:   tokenTypeAccessToken,
+		Audience:         strconv.Itoa(audienceAppID),
+		Scope:            "orders:read",
+	})
+	require.NoError(t, err)
+
+	claims := parseClaims(t, resp.GetToken(), audienceAppSecret)
+	require.Equal(t, userID, claims["uid"])
+	require.Equal(t, map[string]interface{}{
+		"sub": strconv.FormatInt(int64(supportID), 10),
+	}, claims["act"])
+}
+
+func TestTokenExchange_ImpersonationCannotChangeAccount(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email, password := registerUser(ctx, t, st)
+	userID := parseClaims(t, login(ctx, t, st, email, password, appId), appSecret)["uid"].(float64)
+
+	resp, err := st.AuthClient.ExchangeToken(ctx, &sso.ExchangeTokenRequest{
+		ClientId:         clientID,
+		ClientSecret:     clientSecret,
+		SubjectToken:     strconv.FormatInt(int64(userID), 10),
+		SubjectTokenType: tokenTypeUserID,
+		ActorToken:       login(ctx, t, st, supportEmail, supportPassword, appId),
+		ActorTokenType:   tokenTypeAccessToken,
+		Audience:         strconv.Itoa(audienceAppID),
+	})
+	require.NoError(t, err)
+	token := resp.GetToken()
+
+	// the token is rejected even with the password of the user
+	_, err = st.AuthClient.BeginPasskeyRegistration(ctx, &sso.BeginPasskeyRegistrationRequest{
+		Token:    token,
+		Password: password,
+	})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = st.AuthClient.EnrollTOTP(ctx, &sso.EnrollTOTPRequest{
+		Token:    token,
+		Password: password,
+	})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	device := startDeviceAuthorization(t, st, "")
+	_, err = st.AuthClient.ApproveDevice(ctx, &sso.ApproveDeviceRequest{
+		Token:    token,
+		UserCode: device["user_code"].(string),
+		Approve:  true,
+	})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	code, body := pollDevice(t, st, device["device_code"].(string))
+	require.Equal(t, http.StatusBadRequest, code)
+	require.Equal(t, "authorization_pending", body["error"])
+}
+
+func TestTokenExchange_FailCases(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	respLogin := registerAndLogin(ctx, t, st)
+	userID := parseClaims(t, respLogin.GetToken(), appSecret)["uid"].(float64)
+
+	respClient, err := st.AuthClient.ClientCredentials(ctx, &sso.ClientCredentialsRequest{
+		ClientId:     clientID,
+		ClientSecret: clientSecret,
+	})
+	require.NoError(t, err)
+
+	respSupport, err := st.AuthClient.Login(ctx, &sso.LoginRequest{
+		Email:    supportEmail,
+		Password: supportPassword,
+		AppId:    appId,
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		req         *sso.ExchangeTokenRequest
+		expectedErr string
+	}{
+		{
+			name: "Audience without policy",
+			req: &sso.ExchangeTokenRequest{
+				SubjectToken:     respLogin.GetToken(),
+				SubjectTokenType: tokenTypeAccessToken,
+				Audience:         strconv.Itoa(appId),
+			},
+			expectedErr: "audience not allowed",
+		},
+		{
+			name: "Scope not allowed",
+			req: &sso.ExchangeTokenRequest{
+				SubjectToken:     respLogin.GetToken(),
+				SubjectTokenType: tokenTypeAccessToken,
+				Audience:         strconv.Itoa(audienceAppID),
+				Scope:            "users:admin",
+			},
+			expectedErr: "scope not allowed",
+		},
+		{
+			name: "Invalid subject token",
+			req: &sso.ExchangeTokenRequest{
+				SubjectToken:     "not-a-token",
+				SubjectTokenType: tokenTypeAccessToken,
+				Audience:         strconv.Itoa(audienceAppID),
+			},
+			expectedErr: "invalid subject or actor token",
+		},
+		{
+			name: "Client token as subject",
+			req: &sso.ExchangeTokenRequest{
+				SubjectToken:     respClient.GetToken(),
+				SubjectTokenType: tokenTypeAccessToken,
+				Audience:         strconv.Itoa(audienceAppID),
+			},
+			expectedErr: "invalid subject or actor token",
+		},
+		{
+			name: "Subject token with an actor",
+			req: &sso.ExchangeTokenRequest{
+				SubjectToken:     withActor(t, respLogin.GetToken(), "other-client"),
+				SubjectTokenType: tokenTypeAccessToken,
+				Audience:         strconv.Itoa(audienceAppID),
+			},
+			expectedErr: "invalid subject or actor token",
+		},
+		{
+			name: "Actor token with an actor",
+			req: &sso.ExchangeTokenRequest{
+				SubjectToken:     strconv.FormatInt(int64(userID), 10),
+				SubjectTokenType: tokenTypeUserID,
+				ActorToken:       withActor(t, respSupport.GetToken(), "other-client"),
+				ActorTokenType:   tokenTypeAccessToken,
+				Audience:         strconv.Itoa(audienceAppID),
+			},
+			expectedErr: "invalid subject or actor token",
+		},
+		{
+			name: "Impersonation by a non admin",
+			req: &sso.ExchangeTokenRequest{
+				SubjectToken:     strconv.FormatInt(int64(userID), 10),
+				SubjectTokenType: tokenTypeUserID,
+				ActorToken:       respLogin.GetToken(),
+				ActorTokenType:   tokenTypeAccessToken,
+				Audience:         strconv.Itoa(audienceAppID),
+			},
+			expectedErr: "impersonation not allowed",
+		},
+		{
+			name: "Unsupported token type",
+			req: &sso.ExchangeTokenRequest{
+				SubjectToken:     respLogin.GetToken(),
+				SubjectTokenType: "urn:ietf:params:oauth:token-type:saml2",
+				Audience:         strconv.Itoa(audienceAppID),
+			},
+			expectedErr: "unsupported token type",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.req.ClientId = clientID
+			tt.req.ClientSecret = clientSecret
+			_, err := st.AuthClient.ExchangeToken(ctx, tt.req)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tt.expectedErr)
+		})
+	}
+}
+
+func TestTokenExchange_HTTP(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	respLogin := registerAndLogin(ctx, t, st)
+
+	status, body := postTokenExchange(t, st, url.Values{
+		"grant_type":         {"urn:ietf:params:oauth:grant-type:token-exchange"},
+		"subject_token":      {respLogin.GetToken()},
+		"subject_token_type": {tokenTypeAccessToken},
+		"audience":           {strconv.Itoa(audienceAppID)},
+		"scope":              {"orders:write"},
+	})
+	require.Equal(t, http.StatusOK, status)
+	require.NotEmpty(t, body["access_token"])
+	require.Equal(t, tokenTypeAccessToken, body["issued_token_type"])
+	require.Equal(t, "orders:write", body["scope"])
+	require.NotContains(t, body, "refresh_token")
+
+	status, body = postTokenExchange(t, st, url.Values{
+		"grant_type":         {"urn:ietf:params:oauth:grant-type:token-exchange"},
+		"subject_token":      {respLogin.GetToken()},
+		"subject_token_type": {tokenTypeAccessToken},
+		"audience":           {"unknown"},
+	})
+	require.Equal(t, http.StatusBadRequest, status)
+	require.Equal(t, "invalid_target", body["error"])
+}
+
+func postTokenExchange(t *testing.T, st *suite.Suite, form url.Values) (int, map[string]interface{}) {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodPost, st.HTTPURL("/oauth/token"), strings.NewReader(form.Encode()))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(clientID, clientSecret)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var body map[string]interface{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	return resp.StatusCode, body
+}
+
+func parseClaims(t *testing.T, token, secret string) jwt.MapClaims {
+	t.Helper()
+
+	parsed, err := jwt.Parse(token, func(t *jwt.Token) (interface{}, error) {
+		return []byte(secret), nil
+	})
+	require.NoError(t, err)
+	return parsed.Claims.(jwt.MapClaims)
+}
+
+// withActor returns the token of the test app with an act claim of the actor,
+// like a token issued by an exchange for the test app.
+func withActor(t *testing.T, token, actor string) string {
+	t.Helper()
+
+	claims := parseClaims(t, token, appSecret)
+	claims["act"] = map[string]interface{}{"sub": actor}
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(appSecret))
+	require.NoError(t, err)
+	return signed
+}
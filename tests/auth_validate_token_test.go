@@ -0,0 +1,138 @@
+package tests
+
+import (
+	"encoding/json"
+	sso "github.com/Rasikrr/protobuff/protos/gen/go/sso"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/url"
+	"sso/tests/suite"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestValidateToken_GRPC_UserToken(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	respLogin := registerAndLogin(ctx, t, st)
+
+	resp, err := st.AuthClient.ValidateToken(ctx, &sso.ValidateTokenRequest{
+		Token: respLogin.GetToken(),
+		AppId: appId,
+	})
+	require.NoError(t, err)
+	require.True(t, resp.GetActive())
+	require.NotEmpty(t, resp.GetTokenId())
+	require.NotZero(t, resp.GetUserId())
+	require.NotEmpty(t, resp.GetEmail())
+	require.Equal(t, int64(appId), resp.GetAppId())
+	require.Greater(t, resp.GetExpiresAt(), int64(0))
+
+	// a token issued for another app is not active for this one
+	resp, err = st.AuthClient.ValidateToken(ctx, &sso.ValidateTokenRequest{
+		Token: respLogin.GetToken(),
+		AppId: appId + 1,
+	})
+	require.NoError(t, err)
+	require.False(t, resp.GetActive())
+
+	_, err = st.AuthClient.Logout(ctx, &sso.LogoutRequest{
+		Token:        respLogin.GetToken(),
+		RefreshToken: respLogin.GetRefreshToken(),
+	})
+	require.NoError(t, err)
+
+	resp, err = st.AuthClient.ValidateToken(ctx, &sso.ValidateTokenRequest{
+		Token: respLogin.GetToken(),
+		AppId: appId,
+	})
+	require.NoError(t, err)
+	require.False(t, resp.GetActive())
+	require.Empty(t, resp.GetEmail())
+}
+
+func TestValidateToken_GRPC_ClientToken(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	respToken, err := st.AuthClient.ClientCredentials(ctx, &sso.ClientCredentialsRequest{
+		ClientId:     clientID,
+		ClientSecret: clientSecret,
+		Scope:        "orders:read",
+	})
+	require.NoError(t, err)
+
+	resp, err := st.AuthClient.ValidateToken(ctx, &sso.ValidateTokenRequest{
+		Token: respToken.GetToken(),
+		AppId: appId,
+	})
+	require.NoError(t, err)
+	require.True(t, resp.GetActive())
+	require.Equal(t, clientID, resp.GetClientId())
+	require.Equal(t, "orders:read", resp.GetScope())
+	require.Zero(t, resp.GetUserId())
+}
+
+func TestValidateToken_GRPC_FailCases(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	resp, err := st.AuthClient.ValidateToken(ctx, &sso.ValidateTokenRequest{
+		Token: "not-a-token",
+		AppId: appId,
+	})
+	require.NoError(t, err)
+	require.False(t, resp.GetActive())
+
+	_, err = st.AuthClient.ValidateToken(ctx, &sso.ValidateTokenRequest{
+		AppId: appId,
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "token is required")
+
+	_, err = st.AuthClient.ValidateToken(ctx, &sso.ValidateTokenRequest{
+		Token: "not-a-token",
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "app_id is required")
+}
+
+func TestIntrospect_HTTP(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	respLogin := registerAndLogin(ctx, t, st)
+
+	status, body := postIntrospect(t, st, clientID, clientSecret, respLogin.GetToken())
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, true, body["active"])
+	require.Equal(t, "Bearer", body["token_type"])
+	require.Equal(t, strconv.Itoa(appId), body["aud"])
+	require.NotEmpty(t, body["sub"])
+	require.NotEmpty(t, body["username"])
+	require.NotEmpty(t, body["exp"])
+
+	status, body = postIntrospect(t, st, clientID, clientSecret, "not-a-token")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, map[string]interface{}{"active": false}, body)
+
+	status, body = postIntrospect(t, st, clientID, "wrong-secret", respLogin.GetToken())
+	require.Equal(t, http.StatusUnauthorized, status)
+	require.Equal(t, "invalid_client", body["error"])
+}
+
+func postIntrospect(t *testing.T, st *suite.Suite, id, secret, token string) (int, map[string]interface{}) {
+	t.Helper()
+
+	form := url.Values{"token": {token}}
+	req, err := http.NewRequest(http.MethodPost, st.HTTPURL("/oauth/introspect"), strings.NewReader(form.Encode()))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(id, secret)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var body map[string]interface{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	return resp.StatusCode, body
+}
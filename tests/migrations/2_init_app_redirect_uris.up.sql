@@ -0,0 +1,3 @@
+INSERT INTO app_redirect_uris(app_id, redirect_uri)
+SELECT id, 'http://localhost:3000/callback' FROM apps WHERE name = 'test'
+ON CONFLICT DO NOTHING;
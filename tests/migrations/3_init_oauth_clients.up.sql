@@ -0,0 +1,4 @@
+-- the secret is test-client-secret
+INSERT INTO oauth_clients(client_id, secret_hash, app_id, scopes)
+SELECT 'test-client', '8ac950188678f9bb3524b275130332b511bf5092394da6975b5fb9e84302f026', id, 'orders:read orders:write' FROM apps WHERE name = 'test'
+ON CONFLICT DO NOTHING;
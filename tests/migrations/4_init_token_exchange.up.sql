@@ -0,0 +1,13 @@
+-- the orders service is the audience of exchanged tokens
+INSERT INTO apps(id, name, secret)
+VALUES (100, 'orders', 'orders-secret')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO token_exchange_policies(client_id, audience_app_id, scopes, allow_impersonation)
+VALUES ('test-client', 100, 'orders:read orders:write', TRUE)
+ON CONFLICT DO NOTHING;
+
+-- the password is Support-Passw0rd!
+INSERT INTO users(email, pass_hash, is_admin)
+VALUES ('support@sso.test', '$2a$10$vwC7sad.rsXShO/E4782GOqB7Xg8ee1pk2WM2e6cp7VEro7rXuFni', TRUE)
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,4 @@
+-- the fake provider started by the tests on localhost:9099
+INSERT INTO identity_providers(app_id, name, issuer, client_id, client_secret)
+SELECT id, 'fake', 'http://localhost:9099', 'sso', 'sso-secret' FROM apps WHERE name = 'test'
+ON CONFLICT DO NOTHING;
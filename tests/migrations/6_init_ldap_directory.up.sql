@@ -0,0 +1,22 @@
+-- the corp app signs users in at the fake directory started by the tests on localhost:3899,
+-- by their email or their uid
+INSERT INTO apps(id, name, secret)
+VALUES (101, 'corp', 'corp-secret')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO app_redirect_uris(app_id, redirect_uri)
+VALUES (101, 'http://localhost:3000/callback')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO ldap_directories(app_id, url, bind_dn, bind_password, base_dn, user_filter)
+VALUES (101, 'ldap://localhost:3899', 'cn=sso,dc=corp,dc=test', 'sso-secret',
+        'ou=people,dc=corp,dc=test', '(&(objectClass=person)(|(mail=%s)(uid=%s)))')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO ldap_group_roles(directory_id, group_dn, role)
+SELECT id, 'cn=admins,ou=groups,dc=corp,dc=test', 'admin' FROM ldap_directories WHERE app_id = 101
+ON CONFLICT DO NOTHING;
+
+INSERT INTO ldap_group_roles(directory_id, group_dn, role)
+SELECT id, 'cn=staff,ou=groups,dc=corp,dc=test', 'staff' FROM ldap_directories WHERE app_id = 101
+ON CONFLICT DO NOTHING;
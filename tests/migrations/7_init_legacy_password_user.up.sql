@@ -0,0 +1,4 @@
+-- the password of the legacy user is "legacy-password", hashed with bcrypt before hashing was configurable
+INSERT INTO users(email, pass_hash, email_verified)
+VALUES ('legacy@sso.test', '$2a$10$1l6gbUis0y.Rojx6IgvpWOuMi/rKRn7hyIhjCn84Kcbbyh.ThogN.', TRUE)
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,9 @@
+-- the strict app requires long passwords with every character class
+INSERT INTO apps(id, name, secret)
+VALUES (102, 'strict', 'strict-secret')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO app_password_policies(app_id, min_length, max_length, require_upper, require_lower,
+                                  require_digit, require_special, banned_words, email_similarity)
+VALUES (102, 16, 64, TRUE, TRUE, TRUE, TRUE, 'password acme', 0.5)
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,16 @@
+-- the regulated app remembers the last three passwords and lets them expire after 90 days
+INSERT INTO apps(id, name, secret)
+VALUES (103, 'regulated', 'regulated-secret')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO app_password_policies(app_id, min_length, max_length, require_upper, require_lower,
+                                  require_digit, require_special, banned_words, email_similarity,
+                                  history_size, max_age_days)
+VALUES (103, 10, 128, TRUE, TRUE, TRUE, TRUE, '', 0.7, 3, 90)
+ON CONFLICT DO NOTHING;
+
+-- the password of the expired user is "legacy-password", it was last changed 100 days ago
+INSERT INTO users(email, pass_hash, email_verified, password_changed_at)
+VALUES ('expired@sso.test', '$2a$10$1l6gbUis0y.Rojx6IgvpWOuMi/rKRn7hyIhjCn84Kcbbyh.ThogN.', TRUE,
+        NOW() - INTERVAL '100 days')
+ON CONFLICT DO NOTHING;
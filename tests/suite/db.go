@@ -0,0 +1,33 @@
+package suite
+
+import (
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+	"sync"
+	"testing"
+)
+
+var (
+	dbOnce sync.Once
+	db     *sqlx.DB
+	dbErr  error
+)
+
+// DB returns the database of the service, for the state the API does not expose.
+// Tests change only the rows of the users they registered.
+func (s *Suite) DB(t *testing.T) *sqlx.DB {
+	t.Helper()
+
+	dbOnce.Do(func() {
+		cfg := s.Cfg.Storage
+		db, dbErr = sqlx.Connect("postgres", fmt.Sprintf(
+			"host=%s port=%s user=%s dbname=%s sslmode=%s password=%s",
+			cfg.Host, cfg.Port, cfg.User, cfg.Dbname, cfg.SslMode, cfg.Password,
+		))
+	})
+	if dbErr != nil {
+		t.Fatalf("failed to connect to the database: %v", dbErr)
+	}
+	return db
+}
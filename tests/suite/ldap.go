@@ -0,0 +1,294 @@
+package suite
+
+import (
+	"bufio"
+	"github.com/brianvoe/gofakeit"
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const (
+	// LDAPBaseDN is where the users of the fake directory are, the directory fixture points to it.
+	LDAPBaseDN = "ou=people,dc=corp,dc=test"
+	// LDAPAdminsGroup and LDAPStaffGroup are mapped to the admin and staff roles by the fixture.
+	LDAPAdminsGroup = "cn=admins,ou=groups,dc=corp,dc=test"
+	LDAPStaffGroup  = "cn=staff,ou=groups,dc=corp,dc=test"
+
+	ldapAddress         = "localhost:3899"
+	ldapServiceDN       = "cn=sso,dc=corp,dc=test"
+	ldapServicePassword = "sso-secret"
+)
+
+// LDAP protocol operations and result codes (RFC 4511).
+const (
+	ldapBindRequest      ber.Tag = 0
+	ldapBindResponse     ber.Tag = 1
+	ldapUnbindRequest    ber.Tag = 2
+	ldapSearchRequest    ber.Tag = 3
+	ldapSearchResultItem ber.Tag = 4
+	ldapSearchResultDone ber.Tag = 5
+
+	ldapSuccess            = 0
+	ldapProtocolError      = 2
+	ldapInvalidCredentials = 49
+	ldapInsufficientAccess = 50
+)
+
+// LDAP search filters the fake directory evaluates.
+const (
+	ldapFilterAnd      ber.Tag = 0
+	ldapFilterOr       ber.Tag = 1
+	ldapFilterNot      ber.Tag = 2
+	ldapFilterEquality ber.Tag = 3
+	ldapFilterPresent  ber.Tag = 7
+)
+
+var (
+	ldapOnce      sync.Once
+	ldapDirectory *LDAPDirectory
+	ldapErr       error
+)
+
+// LDAPDirectory is a fake LDAP directory speaking just enough of the protocol for simple
+// binds and searches. Only its service account may search.
+type LDAPDirectory struct {
+	mu      sync.Mutex
+	entries map[string]ldapEntry
+}
+
+type ldapEntry struct {
+	password   string
+	attributes map[string][]string
+}
+
+// LDAPDirectory returns the fake directory, it is started on the first call and shared by the tests.
+func (s *Suite) LDAPDirectory(t *testing.T) *LDAPDirectory {
+	t.Helper()
+
+	ldapOnce.Do(func() {
+		ldapDirectory, ldapErr = startLDAPDirectory()
+	})
+	if ldapErr != nil {
+		t.Fatalf("failed to start ldap directory: %v", ldapErr)
+	}
+	return ldapDirectory
+}
+
+func startLDAPDirectory() (*LDAPDirectory, error) {
+	lis, err := net.Listen("tcp", ldapAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &LDAPDirectory{entries: make(map[string]ldapEntry)}
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go d.serve(conn)
+		}
+	}()
+	return d, nil
+}
+
+// AddUser adds a person with the email and password who is a member of the groups
+// and returns the DN of the entry.
+func (d *LDAPDirectory) AddUser(email, password string, groups ...string) string {
+	uid := gofakeit.UUID()
+	dn := "uid=" + uid + "," + LDAPBaseDN
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.entries[strings.ToLower(dn)] = ldapEntry{
+		password: password,
+		attributes: map[string][]string{
+			"dn":          {dn},
+			"objectclass": {"person"},
+			"uid":         {uid},
+			"mail":        {email},
+			"memberof":    groups,
+		},
+	}
+	return dn
+}
+
+// SetGroups replaces the groups of the entry.
+func (d *LDAPDirectory) SetGroups(dn string, groups ...string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if entry, ok := d.entries[strings.ToLower(dn)]; ok {
+		entry.attributes["memberof"] = groups
+	}
+}
+
+func (d *LDAPDirectory) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	var boundDN string
+	for {
+		packet, err := ber.ReadPacket(r)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		messageID, _ := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+
+		var responses []*ber.Packet
+		switch op.Tag {
+		case ldapBindRequest:
+			code := d.bind(op)
+			if code == ldapSuccess {
+				boundDN = ldapString(op.Children[1])
+			} else {
+				boundDN = ""
+			}
+			responses = append(responses, ldapResult(messageID, ldapBindResponse, code))
+		case ldapSearchRequest:
+			if !strings.EqualFold(boundDN, ldapServiceDN) {
+				responses = append(responses, ldapResult(messageID, ldapSearchResultDone, ldapInsufficientAccess))
+				break
+			}
+			for _, dn := range d.search(op) {
+				responses = append(responses, d.searchEntry(messageID, dn))
+			}
+			responses = append(responses, ldapResult(messageID, ldapSearchResultDone, ldapSuccess))
+		case ldapUnbindRequest:
+			return
+		default:
+			responses = append(responses, ldapResult(messageID, op.Tag+1, ldapProtocolError))
+		}
+
+		for _, resp := range responses {
+			if _, err := conn.Write(resp.Bytes()); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (d *LDAPDirectory) bind(op *ber.Packet) int64 {
+	if len(op.Children) < 3 {
+		return ldapProtocolError
+	}
+	dn := ldapString(op.Children[1])
+	password := ldapString(op.Children[2])
+	if password == "" {
+		return ldapInvalidCredentials
+	}
+	if strings.EqualFold(dn, ldapServiceDN) {
+		if password == ldapServicePassword {
+			return ldapSuccess
+		}
+		return ldapInvalidCredentials
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	entry, ok := d.entries[strings.ToLower(dn)]
+	if !ok || entry.password != password {
+		return ldapInvalidCredentials
+	}
+	return ldapSuccess
+}
+
+func (d *LDAPDirectory) search(op *ber.Packet) []string {
+	if len(op.Children) < 7 {
+		return nil
+	}
+	base := strings.ToLower(ldapString(op.Children[0]))
+	filter := op.Children[6]
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var dns []string
+	for dn, entry := range d.entries {
+		if strings.HasSuffix(dn, base) && entry.matches(filter) {
+			dns = append(dns, dn)
+		}
+	}
+	return dns
+}
+
+func (d *LDAPDirectory) searchEntry(messageID int64, dn string) *ber.Packet {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	entry := d.entries[dn]
+
+	item := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldapSearchResultItem, nil, "search result entry")
+	item.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.attributes["dn"][0], "dn"))
+	attributes := ber.NewSequence("attributes")
+	for _, name := range []string{"mail", "memberOf"} {
+		attribute := ber.NewSequence("attribute")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "type"))
+		values := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "values")
+		for _, v := range entry.attributes[strings.ToLower(name)] {
+			values.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, "value"))
+		}
+		attribute.AppendChild(values)
+		attributes.AppendChild(attribute)
+	}
+	item.AppendChild(attributes)
+
+	return ldapMessage(messageID, item)
+}
+
+func (e ldapEntry) matches(filter *ber.Packet) bool {
+	switch filter.Tag {
+	case ldapFilterAnd:
+		for _, f := range filter.Children {
+			if !e.matches(f) {
+				return false
+			}
+		}
+		return true
+	case ldapFilterOr:
+		for _, f := range filter.Children {
+			if e.matches(f) {
+				return true
+			}
+		}
+		return false
+	case ldapFilterNot:
+		return len(filter.Children) == 1 && !e.matches(filter.Children[0])
+	case ldapFilterEquality:
+		if len(filter.Children) != 2 {
+			return false
+		}
+		want := ldapString(filter.Children[1])
+		for _, v := range e.attributes[strings.ToLower(ldapString(filter.Children[0]))] {
+			if strings.EqualFold(v, want) {
+				return true
+			}
+		}
+		return false
+	case ldapFilterPresent:
+		return len(e.attributes[strings.ToLower(ldapString(filter))]) > 0
+	}
+	return false
+}
+
+func ldapResult(messageID int64, op ber.Tag, code int64) *ber.Packet {
+	result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, op, nil, "result")
+	result.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, "result code"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matched dn"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "diagnostic message"))
+	return ldapMessage(messageID, result)
+}
+
+func ldapMessage(messageID int64, op *ber.Packet) *ber.Packet {
+	message := ber.NewSequence("ldap message")
+	message.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "message id"))
+	message.AppendChild(op)
+	return message
+}
+
+// ldapString returns the content of a primitive packet, values of context specific tags are not decoded.
+func ldapString(p *ber.Packet) string {
+	return p.Data.String()
+}
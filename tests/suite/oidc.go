@@ -0,0 +1,244 @@
+package suite
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"net"
+	"net/http"
+	"net/url"
+	"sso/internal/lib/jwk"
+	"sso/internal/lib/opaque"
+	"sso/internal/lib/pkce"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	// OIDCIssuer is the issuer of the fake provider, the identity provider fixture points to it.
+	OIDCIssuer       = "http://" + oidcAddress
+	OIDCClientID     = "sso"
+	OIDCClientSecret = "sso-secret"
+
+	oidcAddress = "localhost:9099"
+	oidcKeyID   = "fake-provider"
+)
+
+var (
+	oidcOnce     sync.Once
+	oidcProvider *OIDCProvider
+	oidcErr      error
+)
+
+// OIDCProvider is a fake upstream OpenID provider. Its authorization endpoint signs in
+// whichever account the test passes along and redirects back right away.
+type OIDCProvider struct {
+	key   *rsa.PrivateKey
+	jwks  jwk.Set
+	mu    sync.Mutex
+	codes map[string]oidcGrant
+}
+
+type oidcGrant struct {
+	clientID      string
+	redirectURI   string
+	challenge     string
+	nonce         string
+	subject       string
+	email         string
+	emailVerified bool
+}
+
+// OIDCProvider returns the fake provider, it is started on the first call and shared by the tests.
+func (s *Suite) OIDCProvider(t *testing.T) *OIDCProvider {
+	t.Helper()
+
+	oidcOnce.Do(func() {
+		oidcProvider, oidcErr = startOIDCProvider()
+	})
+	if oidcErr != nil {
+		t.Fatalf("failed to start oidc provider: %v", oidcErr)
+	}
+	return oidcProvider
+}
+
+func startOIDCProvider() (*OIDCProvider, error) {
+	privatePEM, publicPEM, err := jwk.Generate(jwk.AlgRS256)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := jwk.ParsePrivateKey(privatePEM)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := signer.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("unexpected key type")
+	}
+	publicKey, err := jwk.FromPublicKey(oidcKeyID, jwk.AlgRS256, publicPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &OIDCProvider{
+		key:   key,
+		jwks:  jwk.Set{Keys: []jwk.Key{publicKey}},
+		codes: make(map[string]oidcGrant),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/authorize", p.authorize)
+	mux.HandleFunc("/token", p.token)
+	mux.HandleFunc("/jwks", p.keys)
+
+	lis, err := net.Listen("tcp", oidcAddress)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		_ = http.Serve(lis, mux)
+	}()
+	return p, nil
+}
+
+// Authorize signs the account in at the provider for the authorization url
+// and returns the code and state of the redirect back to the service.
+func (p *OIDCProvider) Authorize(t *testing.T, authURL, subject, email string, emailVerified bool) (code, state string) {
+	t.Helper()
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("invalid authorization url: %v", err)
+	}
+	q := u.Query()
+	q.Set("sub", subject)
+	q.Set("email", email)
+	q.Set("email_verified", strconv.FormatBool(emailVerified))
+	u.RawQuery = q.Encode()
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get(u.String())
+	if err != nil {
+		t.Fatalf("authorization request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("unexpected authorization status %d", resp.StatusCode)
+	}
+
+	location, err := resp.Location()
+	if err != nil {
+		t.Fatalf("no redirect location: %v", err)
+	}
+	return location.Query().Get("code"), location.Query().Get("state")
+}
+
+func (p *OIDCProvider) discovery(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                 OIDCIssuer,
+		"authorization_endpoint": OIDCIssuer + "/authorize",
+		"token_endpoint":         OIDCIssuer + "/token",
+		"jwks_uri":               OIDCIssuer + "/jwks",
+	})
+}
+
+func (p *OIDCProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("response_type") != "code" || q.Get("code_challenge_method") != pkce.MethodS256 {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	code, _, err := opaque.New()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	p.mu.Lock()
+	p.codes[code] = oidcGrant{
+		clientID:      q.Get("client_id"),
+		redirectURI:   q.Get("redirect_uri"),
+		challenge:     q.Get("code_challenge"),
+		nonce:         q.Get("nonce"),
+		subject:       q.Get("sub"),
+		email:         q.Get("email"),
+		emailVerified: q.Get("email_verified") == "true",
+	}
+	p.mu.Unlock()
+
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	params := redirect.Query()
+	params.Set("code", code)
+	params.Set("state", q.Get("state"))
+	redirect.RawQuery = params.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (p *OIDCProvider) token(w http.ResponseWriter, r *http.Request) {
+	id, secret, ok := r.BasicAuth()
+	if !ok || id != OIDCClientID || secret != OIDCClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	code := r.PostFormValue("code")
+	p.mu.Lock()
+	grant, ok := p.codes[code]
+	delete(p.codes, code)
+	p.mu.Unlock()
+
+	if !ok ||
+		r.PostFormValue("grant_type") != "authorization_code" ||
+		grant.clientID != id ||
+		grant.redirectURI != r.PostFormValue("redirect_uri") ||
+		!pkce.Verify(r.PostFormValue("code_verifier"), grant.challenge) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            OIDCIssuer,
+		"aud":            id,
+		"sub":            grant.subject,
+		"email":          grant.email,
+		"email_verified": grant.emailVerified,
+		"nonce":          grant.nonce,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Minute).Unix(),
+	})
+	token.Header["kid"] = oidcKeyID
+	idToken, err := token.SignedString(p.key)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": "upstream-access-token",
+		"token_type":   "Bearer",
+		"id_token":     idToken,
+	})
+}
+
+func (p *OIDCProvider) keys(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, p.jwks)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
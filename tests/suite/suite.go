@@ -2,10 +2,14 @@ package suite
 
 import (
 	"context"
+	"fmt"
 	sso "github.com/Rasikrr/protobuff/protos/gen/go/sso"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 	"net"
+	"os"
+	"path/filepath"
+	"sort"
 	"sso/internal/config"
 	"strconv"
 	"testing"
@@ -13,6 +17,9 @@ import (
 
 const (
 	grpcHost = "localhost"
+	// rootPath is the repository root relative to the tests directory,
+	// the service is expected to run from there.
+	rootPath = ".."
 )
 
 type Suite struct {
@@ -52,3 +59,36 @@ func New(t *testing.T) (context.Context, *Suite) {
 func grpcAddress(cfg *config.Config) string {
 	return net.JoinHostPort(grpcHost, strconv.Itoa(cfg.GRPC.Port))
 }
+
+// HTTPURL returns the url of the path on the HTTP server.
+func (s *Suite) HTTPURL(path string) string {
+	return fmt.Sprintf("http://localhost:%d%s", s.Cfg.HTTP.Port, path)
+}
+
+// LastMail returns the body of the latest message the file mailer sent to the recipient.
+func (s *Suite) LastMail(t *testing.T, to string) string {
+	t.Helper()
+
+	dir := s.Cfg.Mailer.Dir
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(rootPath, dir)
+	}
+	entries, err := os.ReadDir(filepath.Join(dir, to))
+	if err != nil {
+		t.Fatalf("failed to read mailbox of %s: %v", to, err)
+	}
+	if len(entries) == 0 {
+		t.Fatalf("no mail sent to %s", to)
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+
+	content, err := os.ReadFile(filepath.Join(dir, to, names[len(names)-1]))
+	if err != nil {
+		t.Fatalf("failed to read mail: %v", err)
+	}
+	return string(content)
+}